@@ -2,21 +2,27 @@ package main
 
 import (
 	"context"
+	"errors"
+	"expvar"
+	"flag"
+	"fmt"
 	"github.com/vlad-marlo/gophermart/pkg/logger"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/vlad-marlo/gophermart/internal/config"
 	"github.com/vlad-marlo/gophermart/internal/poller"
 	"github.com/vlad-marlo/gophermart/internal/server"
+	"github.com/vlad-marlo/gophermart/internal/store"
+	"github.com/vlad-marlo/gophermart/internal/store/cachestore"
+	"github.com/vlad-marlo/gophermart/internal/store/sqlitestore"
 	"github.com/vlad-marlo/gophermart/internal/store/sqlstore"
 )
 
-const pollInterval = 10000 * time.Millisecond
-
 func main() {
 	ctx := context.Background()
 
@@ -29,26 +35,126 @@ func main() {
 		log.Panicf("new config: %v", err)
 	}
 
+	// gophermart [flags] migrate up|down|status
+	if args := flag.Args(); len(args) > 0 {
+		if args[0] != "migrate" || len(args) != 2 {
+			log.Fatalf("unknown command %q; usage: gophermart [flags] migrate up|down|status", strings.Join(args, " "))
+		}
+		if err := migrate(ctx, log, cfg, args[1]); err != nil {
+			log.Fatalf("migrate %s: %v", args[1], err)
+		}
+		return
+	}
+
 	// init storage
-	storage, err := sqlstore.New(ctx, log, cfg)
+	storage, err := newStorage(ctx, log, cfg)
 	if err != nil {
-		log.Panicf("new sql store: %v", err)
+		log.Panicf("new storage: %v", err)
 	}
 	defer storage.Close()
 
-	p := poller.New(log, storage, cfg, pollInterval)
-	defer p.Close()
+	accrual, err := poller.NewAccrualClient(log, cfg)
+	if err != nil {
+		log.Panicf("new accrual client: %v", err)
+	}
+	p := poller.New(ctx, log, storage, cfg, accrual)
 	s := server.New(log, storage, cfg)
+	// with LISTEN poller receives orders registered by this instance from database too, so it is not notified twice
+	if !cfg.PollListen {
+		s.SetOrderNotifier(p)
+	}
 
+	srv := &http.Server{
+		Addr:    cfg.BindAddr,
+		Handler: s.Router,
+	}
 	go func() {
-		if err := http.ListenAndServe(cfg.BindAddr, s.Router); err != nil {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Panicf("start server: %v", err)
 		}
 	}()
+	if cfg.MetricsAddr != "" {
+		go func() {
+			if err := http.ListenAndServe(cfg.MetricsAddr, expvar.Handler()); err != nil {
+				log.Errorf("start metrics server: %v", err)
+			}
+		}()
+	}
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM, syscall.SIGINT, syscall.SIGSEGV)
 
 	sig := <-interrupt
 
 	log.WithField("signal", sig.String()).Info("graceful shut down")
+
+	// server is stopped first, so no new orders are sent to poller
+	shutdownCtx, cancel := context.WithTimeout(ctx, cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Errorf("shutdown server: %v", err)
+	}
+	if err := p.Close(shutdownCtx); err != nil {
+		log.Errorf("close poller: %v", err)
+	}
+}
+
+// newStorage opens storage selected by scheme of database URI: SQLite for sqlite: URIs and Postgres otherwise.
+func newStorage(ctx context.Context, log logger.Logger, cfg *config.Config) (s store.Storage, err error) {
+	if sqlitestore.IsURI(cfg.DBURI) {
+		s, err = sqlitestore.New(ctx, log, cfg)
+	} else {
+		s, err = sqlstore.New(ctx, log, cfg)
+	}
+	if err != nil || cfg.CacheSize <= 0 {
+		return s, err
+	}
+	return cachestore.New(log, s, cachestore.NewLRU(cfg.CacheSize), cfg.CacheTTL), nil
+}
+
+// newMigrator returns migrator of database selected by scheme of database URI.
+func newMigrator(ctx context.Context, log logger.Logger, cfg *config.Config) (store.Migrator, error) {
+	if sqlitestore.IsURI(cfg.DBURI) {
+		return sqlitestore.NewMigrator(ctx, log, cfg)
+	}
+	return sqlstore.NewMigrator(ctx, log, cfg)
+}
+
+// migrate runs migrations command.
+func migrate(ctx context.Context, log logger.Logger, cfg *config.Config, cmd string) error {
+	m, err := newMigrator(ctx, log, cfg)
+	if err != nil {
+		return fmt.Errorf("new migrator: %w", err)
+	}
+	defer m.Close()
+
+	switch cmd {
+	case "up":
+		n, err := m.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("applied %d migrations\n", n)
+	case "down":
+		s, err := m.Down(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("reverted %04d_%s\n", s.Version, s.Name)
+	case "status":
+		states, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range states {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = "applied at " + s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, applied)
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q", cmd)
+	}
+	return nil
 }
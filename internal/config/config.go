@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/caarlos0/env/v6"
 )
@@ -12,6 +13,80 @@ type Config struct {
 	BindAddr             string `env:"RUN_ADDRESS" envDefault:":8000"`
 	DBURI                string `env:"DATABASE_URI"`
 	AccuralSystemAddress string `env:"ACCRUAL_SYSTEM_ADDRESS" envDefault:"http://localhost:8080"`
+	// AccrualProviders are additional accrual systems in format "prefix=address"; orders which numbers start with
+	// prefix are checked in accrual system on address
+	AccrualProviders []string `env:"ACCRUAL_PROVIDERS" envSeparator:","`
+	// DBMigrate enables applying of not applied migrations on start
+	DBMigrate bool `env:"DATABASE_MIGRATE" envDefault:"true"`
+	// DBReplicaURIs are URIs of read-only replicas of database; balance and history of user are read from replicas.
+	// Writes of user are tracked in memory of instance, so replicas are supported by single instance only: instance
+	// with replicas doesn't start while database is used by other instances and vice versa
+	DBReplicaURIs []string `env:"DATABASE_REPLICA_URIS" envSeparator:","`
+	// DBReplicaMaxLag is max lag of replica which serves reads; reads of user are served by primary during this time
+	// after write of user
+	DBReplicaMaxLag time.Duration `env:"DATABASE_REPLICA_MAX_LAG" envDefault:"5s"`
+	// DBReplicaCheckInterval is interval between checks of replica lag
+	DBReplicaCheckInterval time.Duration `env:"DATABASE_REPLICA_CHECK_INTERVAL" envDefault:"5s"`
+	// DBMaxConns is max count of connections of pool; zero keeps default of driver
+	DBMaxConns int `env:"DATABASE_MAX_CONNS" envDefault:"0"`
+	// DBMinConns is count of connections which pool keeps open
+	DBMinConns int `env:"DATABASE_MIN_CONNS" envDefault:"0"`
+	// DBMaxConnLifetime is time after which connection is closed
+	DBMaxConnLifetime time.Duration `env:"DATABASE_MAX_CONN_LIFETIME" envDefault:"1h"`
+	// DBMaxConnIdleTime is time after which idle connection is closed
+	DBMaxConnIdleTime time.Duration `env:"DATABASE_MAX_CONN_IDLE_TIME" envDefault:"30m"`
+	// DBHealthCheckPeriod is interval between checks of idle connections of pool
+	DBHealthCheckPeriod time.Duration `env:"DATABASE_HEALTH_CHECK_PERIOD" envDefault:"1m"`
+	// DBStatementTimeout is deadline of every query; query is cancelled after it by client and by statement_timeout of
+	// server. Zero disables deadline
+	DBStatementTimeout time.Duration `env:"DATABASE_STATEMENT_TIMEOUT" envDefault:"10s"`
+	// CacheSize is max count of balances and withdrawal histories of users cached in memory; zero disables cache. Cache
+	// is local to instance and is invalidated only by its writes, so it is disabled by default
+	CacheSize int `env:"CACHE_SIZE" envDefault:"0"`
+	// CacheTTL is time after which cached value is read from database again; it limits staleness of values changed by
+	// other instances
+	CacheTTL time.Duration `env:"CACHE_TTL" envDefault:"30s"`
+	// AccrualWebhookSecret is shared secret which signs status pushes of accrual system; empty secret disables callback
+	AccrualWebhookSecret string `env:"ACCRUAL_WEBHOOK_SECRET"`
+	// TransferDailyLimit is max sum of user's outgoing transfers per day; zero disables limit
+	TransferDailyLimit float64 `env:"TRANSFER_DAILY_LIMIT" envDefault:"10000"`
+	// PollWorkers is count of workers which are checking orders in accrual system concurrently
+	PollWorkers int `env:"POLL_WORKERS" envDefault:"4"`
+	// PollQueueSize is max count of orders waiting for worker
+	PollQueueSize int `env:"POLL_QUEUE_SIZE" envDefault:"1000"`
+	// PollInterval is interval between checks of all unprocessed orders
+	PollInterval time.Duration `env:"POLL_INTERVAL" envDefault:"10s"`
+	// PollListen enables receiving of orders registered by all instances through database notifications; they replace
+	// in-process notifications of poller
+	PollListen bool `env:"POLL_LISTEN" envDefault:"false"`
+	// PollLease is time during which order claimed by instance is not checked by other instances; request to accrual
+	// system, including wait for its rate limit, is cancelled after half of lease
+	PollLease time.Duration `env:"POLL_LEASE" envDefault:"1m"`
+	// InstanceID identifies instance in order leases; random id is used if it is empty
+	InstanceID string `env:"INSTANCE_ID"`
+	// PollBackoffBase is delay before second check of order; delay is doubled after each check which didn't give final
+	// status of order
+	PollBackoffBase time.Duration `env:"POLL_BACKOFF_BASE" envDefault:"10s"`
+	// PollBackoffMax is max delay between checks of order
+	PollBackoffMax time.Duration `env:"POLL_BACKOFF_MAX" envDefault:"10m"`
+	// PollMaxFailures is count of checks which state of order could not be resolved from answer of accrual system
+	// after which order is dead-lettered; zero disables dead-lettering
+	PollMaxFailures int `env:"POLL_MAX_FAILURES" envDefault:"10"`
+	// AdminToken is bearer token of admin endpoints; empty token disables them
+	AdminToken string `env:"ADMIN_TOKEN"`
+	// OrderMaxAge is age after which not processed order needs attention and is not polled anymore
+	OrderMaxAge time.Duration `env:"ORDER_MAX_AGE" envDefault:"72h"`
+	// BreakerThreshold is count of consecutive failed requests to accrual system after which polling is stopped; zero
+	// disables circuit breaker
+	BreakerThreshold int `env:"BREAKER_THRESHOLD" envDefault:"5"`
+	// BreakerTimeout is time after which polling is tried again
+	BreakerTimeout time.Duration `env:"BREAKER_TIMEOUT" envDefault:"30s"`
+	// BreakerProbes is count of concurrent probe requests after BreakerTimeout
+	BreakerProbes int `env:"BREAKER_PROBES" envDefault:"1"`
+	// ShutdownTimeout is max time to wait for in-flight requests and checks of orders on shutdown
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" envDefault:"30s"`
+	// MetricsAddr is address to serve metrics on; empty string disables metrics server
+	MetricsAddr string `env:"METRICS_ADDRESS"`
 }
 
 func New() (*Config, error) {
@@ -22,8 +97,45 @@ func New() (*Config, error) {
 	}
 	// parse flags
 	flag.StringVar(&c.BindAddr, "a", c.BindAddr, "address to run HTTP server")
-	flag.StringVar(&c.DBURI, "d", c.DBURI, "database URI")
+	flag.StringVar(&c.DBURI, "d", c.DBURI, "database URI; sqlite:<path> selects embedded SQLite storage")
+	flag.Func("database-replica", "URI of read-only replica of database", func(v string) error {
+		c.DBReplicaURIs = append(c.DBReplicaURIs, v)
+		return nil
+	})
+	flag.DurationVar(&c.DBReplicaMaxLag, "database-replica-max-lag", c.DBReplicaMaxLag, "max lag of replica which serves reads")
+	flag.DurationVar(&c.DBReplicaCheckInterval, "database-replica-check-interval", c.DBReplicaCheckInterval, "interval between checks of replica lag")
+	flag.IntVar(&c.DBMaxConns, "database-max-conns", c.DBMaxConns, "max count of database connections")
+	flag.IntVar(&c.DBMinConns, "database-min-conns", c.DBMinConns, "count of database connections kept open")
+	flag.DurationVar(&c.DBMaxConnLifetime, "database-max-conn-lifetime", c.DBMaxConnLifetime, "time after which database connection is closed")
+	flag.DurationVar(&c.DBMaxConnIdleTime, "database-max-conn-idle-time", c.DBMaxConnIdleTime, "time after which idle database connection is closed")
+	flag.DurationVar(&c.DBHealthCheckPeriod, "database-health-check-period", c.DBHealthCheckPeriod, "interval between checks of idle database connections")
+	flag.DurationVar(&c.DBStatementTimeout, "database-statement-timeout", c.DBStatementTimeout, "deadline of database query")
+	flag.BoolVar(&c.DBMigrate, "migrate", c.DBMigrate, "apply migrations on start")
+	flag.IntVar(&c.CacheSize, "cache-size", c.CacheSize, "max count of cached balances and withdrawal histories")
+	flag.DurationVar(&c.CacheTTL, "cache-ttl", c.CacheTTL, "time after which cached value is read from database again")
 	flag.StringVar(&c.AccuralSystemAddress, "r", c.AccuralSystemAddress, "accural system address")
+	flag.Func("accrual-provider", "additional accrual system in format prefix=address", func(v string) error {
+		c.AccrualProviders = append(c.AccrualProviders, v)
+		return nil
+	})
+	flag.StringVar(&c.AccrualWebhookSecret, "accrual-webhook-secret", c.AccrualWebhookSecret, "shared secret of accrual callback")
+	flag.Float64Var(&c.TransferDailyLimit, "transfer-daily-limit", c.TransferDailyLimit, "max sum of user's transfers per day")
+	flag.IntVar(&c.PollWorkers, "poll-workers", c.PollWorkers, "count of accrual poller workers")
+	flag.IntVar(&c.PollQueueSize, "poll-queue-size", c.PollQueueSize, "size of accrual poller job queue")
+	flag.DurationVar(&c.PollInterval, "poll-interval", c.PollInterval, "interval between checks of unprocessed orders")
+	flag.BoolVar(&c.PollListen, "poll-listen", c.PollListen, "listen orders registered by other instances")
+	flag.DurationVar(&c.PollLease, "poll-lease", c.PollLease, "time during which claimed order is not checked by other instances")
+	flag.StringVar(&c.InstanceID, "instance-id", c.InstanceID, "id of instance in order leases")
+	flag.DurationVar(&c.PollBackoffBase, "poll-backoff-base", c.PollBackoffBase, "delay before second check of order")
+	flag.DurationVar(&c.PollBackoffMax, "poll-backoff-max", c.PollBackoffMax, "max delay between checks of order")
+	flag.IntVar(&c.PollMaxFailures, "poll-max-failures", c.PollMaxFailures, "count of unresolved checks after which order is dead-lettered")
+	flag.StringVar(&c.AdminToken, "admin-token", c.AdminToken, "bearer token of admin endpoints")
+	flag.DurationVar(&c.OrderMaxAge, "order-max-age", c.OrderMaxAge, "age after which not processed order needs attention")
+	flag.IntVar(&c.BreakerThreshold, "breaker-threshold", c.BreakerThreshold, "count of failures which opens circuit breaker")
+	flag.DurationVar(&c.BreakerTimeout, "breaker-timeout", c.BreakerTimeout, "time during which circuit breaker stays open")
+	flag.IntVar(&c.BreakerProbes, "breaker-probes", c.BreakerProbes, "count of probe requests of half-open circuit breaker")
+	flag.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "max time to wait for in-flight work on shutdown")
+	flag.StringVar(&c.MetricsAddr, "metrics-address", c.MetricsAddr, "address to serve metrics on")
 	flag.Parse()
 
 	if len(c.DBURI) == 0 {
@@ -32,9 +144,23 @@ func New() (*Config, error) {
 	if len(c.AccuralSystemAddress) == 0 {
 		return nil, ErrEmptyDataBaseURI
 	}
+	if err := c.validatePoller(); err != nil {
+		return nil, err
+	}
 	return c, nil
 }
 
+// validatePoller refuses intervals of poller which can't be used by ticker and leases of orders.
+func (c *Config) validatePoller() error {
+	if c.PollInterval <= 0 {
+		return ErrBadPollInterval
+	}
+	if c.PollLease <= 0 {
+		return ErrBadPollLease
+	}
+	return nil
+}
+
 func TestConfig(t *testing.T) *Config {
 	c := &Config{}
 
@@ -4,4 +4,6 @@ import "errors"
 
 var (
 	ErrEmptyDataBaseURI = errors.New("DB URI must be not null")
+	ErrBadPollInterval  = errors.New("poll interval must be positive")
+	ErrBadPollLease     = errors.New("poll lease must be positive")
 )
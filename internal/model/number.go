@@ -0,0 +1,65 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/vlad-marlo/gophermart/pkg/luhn"
+)
+
+// MaxOrderNumberLength is max count of digits in order number
+const MaxOrderNumberLength = 64
+
+var ErrBadOrderNumber = errors.New("order number must contain only digits")
+
+// OrderNumber is decimal number of order. Number is kept as string, so leading zeros are preserved and number could be
+// longer than any integer type.
+type OrderNumber string
+
+// ParseOrderNumber checks that s is not empty and contains only digits.
+func ParseOrderNumber(s string) (OrderNumber, error) {
+	s = strings.TrimSpace(s)
+	if len(s) == 0 || len(s) > MaxOrderNumberLength {
+		return "", ErrBadOrderNumber
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return "", ErrBadOrderNumber
+		}
+	}
+	return OrderNumber(s), nil
+}
+
+// Valid checks number with Luhn algorithm.
+func (n OrderNumber) Valid() bool {
+	return luhn.Valid(string(n))
+}
+
+// String ...
+func (n OrderNumber) String() string {
+	return string(n)
+}
+
+// UnmarshalJSON accepts number as JSON string or as JSON number.
+func (n *OrderNumber) UnmarshalJSON(data []byte) error {
+	var s string
+
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return fmt.Errorf("json unmarshal: %w", err)
+		}
+	} else {
+		s = string(data)
+	}
+
+	num, err := ParseOrderNumber(s)
+	if err != nil {
+		return err
+	}
+	*n = num
+	return nil
+}
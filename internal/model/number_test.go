@@ -0,0 +1,82 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOrderNumber(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    OrderNumber
+		wantErr bool
+	}{
+		{
+			name: "positive case #1",
+			s:    "79927398713",
+			want: "79927398713",
+		},
+		{
+			name: "positive case #2 - leading zeros",
+			s:    "0079927398713",
+			want: "0079927398713",
+		},
+		{
+			name: "positive case #3 - long number",
+			s:    "49299728846762894929972884676289",
+			want: "49299728846762894929972884676289",
+		},
+		{
+			name: "positive case #4 - spaces",
+			s:    " 79927398713\n",
+			want: "79927398713",
+		},
+		{
+			name:    "negative case #1 - empty",
+			s:       "",
+			wantErr: true,
+		},
+		{
+			name:    "negative case #2 - not digits",
+			s:       "7992739871a",
+			wantErr: true,
+		},
+		{
+			name:    "negative case #3 - negative",
+			s:       "-79927398713",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseOrderNumber(tt.s)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrBadOrderNumber)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestOrderNumber_JSON(t *testing.T) {
+	var w *Withdraw
+
+	require.NoError(t, json.Unmarshal([]byte(`{"order": "0079927398713", "sum": 1}`), &w))
+	assert.Equal(t, OrderNumber("0079927398713"), w.Order)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"order": 79927398713, "sum": 1}`), &w))
+	assert.Equal(t, OrderNumber("79927398713"), w.Order)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"order": "abc", "sum": 1}`), &w))
+
+	data, err := json.Marshal(&Order{Number: "0079927398713"})
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"number":"0079927398713"`)
+}
@@ -1,20 +1,55 @@
 package model
 
+import "time"
+
+// results of order registration in batch
+const (
+	RegistrationAccepted       = "accepted"
+	RegistrationDuplicateOwn   = "duplicate-own"
+	RegistrationDuplicateOther = "duplicate-other"
+	RegistrationInvalid        = "invalid"
+)
+
 type (
 	Order struct {
-		Number     int     `json:"number,string"`
-		Status     string  `json:"status"`
-		Accrual    float64 `json:"accrual,omitempty"`
-		UploadedAt string  `json:"uploaded_at"`
+		Number     OrderNumber          `json:"number"`
+		Status     string               `json:"status"`
+		Accrual    float64              `json:"accrual,omitempty"`
+		UploadedAt string               `json:"uploaded_at"`
+		History    []*OrderStatusChange `json:"history,omitempty"`
+	}
+	// OrderStatusChange is record about order status transition
+	OrderStatusChange struct {
+		Status    string  `json:"status"`
+		Accrual   float64 `json:"accrual,omitempty"`
+		ChangedAt string  `json:"changed_at"`
+	}
+	// OrderRegistration is result of registration of single order in batch
+	OrderRegistration struct {
+		Number OrderNumber `json:"number"`
+		Result string      `json:"result"`
 	}
 	OrderInPoll struct {
-		Number int
-		Status string
-		User   int
+		Number OrderNumber `json:"number"`
+		Status string      `json:"status"`
+		User   int         `json:"user"`
+		// Attempts is count of checks in accrual system which didn't give final status
+		Attempts   int       `json:"attempts"`
+		UploadedAt time.Time `json:"-"`
+	}
+	// DeadLetter is order which state could not be resolved from answers of accrual system
+	DeadLetter struct {
+		Number         OrderNumber `json:"number"`
+		User           int         `json:"user"`
+		Status         string      `json:"status"`
+		Failures       int         `json:"failures"`
+		LastError      string      `json:"last_error"`
+		LastPayload    string      `json:"last_payload,omitempty"`
+		DeadLetteredAt string      `json:"dead_lettered_at"`
 	}
 	OrderInAccrual struct {
-		Number  int     `json:"order,string"`
-		Status  string  `json:"status"`
-		Accrual float64 `json:"accrual,omitempty"`
+		Number  OrderNumber `json:"order"`
+		Status  string      `json:"status"`
+		Accrual float64     `json:"accrual,omitempty"`
 	}
 )
@@ -1,8 +1,59 @@
 package model
 
+import "fmt"
+
 const (
 	StatusNew        = "NEW"
 	StatusProcessing = "PROCESSING"
 	StatusInvalid    = "INVALID"
 	StatusProcessed  = "PROCESSED"
+	StatusCancelled  = "CANCELLED"
+
+	// AccrualStatusRegistered is status of order which is registered in accrual system but is not processed yet; it
+	// doesn't change status of order
+	AccrualStatusRegistered = "REGISTERED"
 )
+
+// transitions lists statuses which order could get from its current status. Order could keep its non-final status.
+var transitions = map[string][]string{
+	StatusNew:        {StatusNew, StatusProcessing, StatusInvalid, StatusProcessed, StatusCancelled},
+	StatusProcessing: {StatusProcessing, StatusInvalid, StatusProcessed},
+	StatusInvalid:    nil,
+	StatusProcessed:  nil,
+	StatusCancelled:  nil,
+}
+
+// TransitionError is returned when order can't get status To from status From.
+type TransitionError struct {
+	From string
+	To   string
+}
+
+// Error ...
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("illegal transition of order status: %s -> %s", e.From, e.To)
+}
+
+// IsFinalStatus reports whether order with status is not changed by accrual system anymore.
+func IsFinalStatus(status string) bool {
+	next, ok := transitions[status]
+	return ok && len(next) == 0
+}
+
+// CanTransition reports whether order with status from could get status to.
+func CanTransition(from, to string) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateTransition returns *TransitionError if order with status from can't get status to.
+func ValidateTransition(from, to string) error {
+	if !CanTransition(from, to) {
+		return &TransitionError{From: from, To: to}
+	}
+	return nil
+}
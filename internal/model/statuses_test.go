@@ -0,0 +1,59 @@
+package model
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTransition(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		ok   bool
+	}{
+		{StatusNew, StatusNew, true},
+		{StatusNew, StatusProcessing, true},
+		{StatusNew, StatusProcessed, true},
+		{StatusNew, StatusInvalid, true},
+		{StatusNew, StatusCancelled, true},
+		{StatusProcessing, StatusProcessing, true},
+		{StatusProcessing, StatusProcessed, true},
+		{StatusProcessing, StatusInvalid, true},
+		{StatusProcessing, StatusNew, false},
+		{StatusProcessing, StatusCancelled, false},
+		{StatusProcessed, StatusProcessing, false},
+		{StatusProcessed, StatusProcessed, false},
+		{StatusInvalid, StatusProcessed, false},
+		{StatusCancelled, StatusNew, false},
+		{StatusNew, AccrualStatusRegistered, false},
+		{"UNKNOWN", StatusNew, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+"->"+tt.to, func(t *testing.T) {
+			assert.Equal(t, tt.ok, CanTransition(tt.from, tt.to))
+
+			err := ValidateTransition(tt.from, tt.to)
+			if tt.ok {
+				assert.NoError(t, err)
+				return
+			}
+			var te *TransitionError
+			if assert.True(t, errors.As(err, &te)) {
+				assert.Equal(t, tt.from, te.From)
+				assert.Equal(t, tt.to, te.To)
+			}
+		})
+	}
+}
+
+func TestIsFinalStatus(t *testing.T) {
+	for _, s := range []string{StatusProcessed, StatusInvalid, StatusCancelled} {
+		assert.Truef(t, IsFinalStatus(s), "status %s", s)
+	}
+	for _, s := range []string{StatusNew, StatusProcessing, "UNKNOWN"} {
+		assert.Falsef(t, IsFinalStatus(s), "status %s", s)
+	}
+}
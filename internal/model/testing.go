@@ -15,7 +15,7 @@ func TestUser(t *testing.T, login string) *User {
 	return u
 }
 
-func TestWithdraw(t *testing.T, order int, sum float64) *Withdraw {
+func TestWithdraw(t *testing.T, order OrderNumber, sum float64) *Withdraw {
 	t.Helper()
 	return &Withdraw{
 		Order: order,
@@ -23,7 +23,7 @@ func TestWithdraw(t *testing.T, order int, sum float64) *Withdraw {
 	}
 }
 
-func TestOrder(t *testing.T, number int, status string) *Order {
+func TestOrder(t *testing.T, number OrderNumber, status string) *Order {
 	t.Helper()
 	return &Order{
 		Number: number,
@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+const (
+	TransferOutgoing = "outgoing"
+	TransferIncoming = "incoming"
+)
+
+type Transfer struct {
+	// Login of recipient in request and of counterparty in history
+	Login             string    `json:"login"`
+	Sum               float64   `json:"sum"`
+	Direction         string    `json:"direction,omitempty"`
+	IdempotencyKey    string    `json:"-"`
+	ProcessedAt       time.Time `json:"-"`
+	ProcessedAtString string    `json:"processed_at,omitempty"`
+}
+
+func (t *Transfer) ToRepresentation() {
+	t.ProcessedAtString = t.ProcessedAt.Format(time.RFC3339)
+}
@@ -3,10 +3,10 @@ package model
 import "time"
 
 type Withdraw struct {
-	Order             int       `json:"order,string"`
-	Sum               float64   `json:"sum"`
-	ProcessedAt       time.Time `json:"-"`
-	ProcessedAtString string    `json:"processed_at,omitempty"`
+	Order             OrderNumber `json:"order"`
+	Sum               float64     `json:"sum"`
+	ProcessedAt       time.Time   `json:"-"`
+	ProcessedAtString string      `json:"processed_at,omitempty"`
 }
 
 func (w *Withdraw) ToRepresentation() {
@@ -1,16 +1,19 @@
 package poller
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/go-resty/resty/v2"
 	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/pkg/logger"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
-// retryFunc ...
+// retryFunc returns duration from Retry-After header of response with 429 status code
 func retryFunc(_ *resty.Client, response *resty.Response) (time.Duration, error) {
 	if response.StatusCode() != http.StatusTooManyRequests {
 		return 0, nil
@@ -28,15 +31,38 @@ func retryFunc(_ *resty.Client, response *resty.Response) (time.Duration, error)
 	return time.Duration(seconds) * time.Second, nil
 }
 
-// GetOrderFromAccrual ...
-func (s *OrderPoller) GetOrderFromAccrual(number int) (o *model.OrderInAccrual, err error) {
+// httpClient is client of accrual system with HTTP API. Requests of all workers to the same accrual system are spaced
+// out by shared limiter. Failed requests are not retried by client, so every attempt passes limiter and is seen by
+// circuit breaker; orders are checked again by poller.
+type httpClient struct {
+	address string
+	client  *resty.Client
+	limiter *limiter
+	logger  logger.Logger
+}
 
+// NewHTTPClient returns client of accrual system with HTTP API served on address.
+func NewHTTPClient(l logger.Logger, address string) AccrualClient {
+	return &httpClient{
+		address: strings.TrimRight(address, "/"),
+		client:  resty.New(),
+		limiter: new(limiter),
+		logger:  l,
+	}
+}
+
+// GetOrder requests state of order in accrual system; request is cancelled when ctx is done.
+func (s *httpClient) GetOrder(ctx context.Context, number model.OrderNumber) (o *model.OrderInAccrual, err error) {
 	l := s.logger
 	o = new(model.OrderInAccrual)
 
-	endpoint := fmt.Sprintf("%s/api/orders/%d", s.config.AccuralSystemAddress, number)
+	endpoint := fmt.Sprintf("%s/api/orders/%s", s.address, number)
 
-	r := s.client.NewRequest()
+	if err := s.limiter.Wait(ctx); err != nil {
+		return nil, fmt.Errorf("limiter: wait: %w", err)
+	}
+
+	r := s.client.NewRequest().SetContext(ctx)
 	response, err := r.Get(endpoint)
 	if err != nil {
 		return nil, fmt.Errorf("http get: %w ", err)
@@ -52,16 +78,54 @@ func (s *OrderPoller) GetOrderFromAccrual(number int) (o *model.OrderInAccrual,
 
 	switch response.StatusCode() {
 	case http.StatusTooManyRequests:
+		s.slowDown(response)
 		return nil, ErrTooManyRequests
 	case http.StatusInternalServerError:
 		return nil, ErrInternal
+	case http.StatusNoContent:
+		return nil, &ResponseError{StatusCode: response.StatusCode(), Err: ErrNotRegistered}
 	case http.StatusOK:
 		if err := json.Unmarshal(response.Body(), &o); err != nil {
-			return nil, fmt.Errorf("json unmarshal: %w", err)
+			return nil, &ResponseError{
+				StatusCode: response.StatusCode(),
+				Body:       response.Body(),
+				Err:        fmt.Errorf("json unmarshal: %w", err),
+			}
 		}
 		return o, nil
 	default:
 		l.Error(fmt.Sprintf("got unexpected status code: %v", response.StatusCode()))
-		return nil, ErrUnexpectedStatus
+		return nil, &ResponseError{StatusCode: response.StatusCode(), Body: response.Body(), Err: ErrUnexpectedStatus}
+	}
+}
+
+// Blocked returns time during which requests are paused because accrual system asked to slow down.
+func (s *httpClient) Blocked() time.Duration {
+	return s.limiter.PausedFor()
+}
+
+// BlockedFor ...
+func (s *httpClient) BlockedFor(model.OrderNumber) time.Duration {
+	return s.Blocked()
+}
+
+// slowDown pauses all requests to accrual system for duration from Retry-After header and applies rate limit from
+// response body.
+func (s *httpClient) slowDown(response *resty.Response) {
+	metrics.Add(metricRateLimited, 1)
+
+	retryAfter, err := retryFunc(nil, response)
+	if err != nil {
+		s.logger.Warnf("parse retry-after header: %v", err)
+	}
+	if retryAfter > 0 {
+		s.limiter.Pause(retryAfter)
+		s.logger.Debugf("requests to accrual system are paused for %s", retryAfter)
+	}
+
+	if perMinute, ok := parseRateLimit(response.Body()); ok {
+		s.limiter.SetRate(perMinute)
+		rateLimit.Set(int64(perMinute))
+		s.logger.Debugf("requests to accrual system are limited to %d per minute", perMinute)
 	}
 }
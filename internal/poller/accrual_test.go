@@ -0,0 +1,131 @@
+package poller
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/pkg/logger"
+)
+
+// testHTTPClient returns client of accrual system served on address.
+func testHTTPClient(t *testing.T, address string) *httpClient {
+	t.Helper()
+
+	log := logrus.New()
+	log.Out = io.Discard
+
+	return NewHTTPClient(logger.GetLoggerByEntry(logrus.NewEntry(log)), address).(*httpClient)
+}
+
+func TestHTTPClient_GetOrder_TooManyRequests(t *testing.T) {
+	defer logger.DeleteLogFolderAndFile(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte("No more than 30 requests per minute allowed"))
+	}))
+	defer ts.Close()
+
+	p := testHTTPClient(t, ts.URL)
+
+	_, err := p.GetOrder(context.Background(), "79927398713")
+	require.ErrorIs(t, err, ErrTooManyRequests)
+
+	p.limiter.mu.Lock()
+	defer p.limiter.mu.Unlock()
+	assert.WithinDuration(t, time.Now().Add(time.Minute), p.limiter.pausedUntil, time.Second)
+	assert.Equal(t, 2*time.Second, p.limiter.interval)
+}
+
+func TestHTTPClient_GetOrder_NoRetries(t *testing.T) {
+	defer logger.DeleteLogFolderAndFile(t)
+
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	p := testHTTPClient(t, ts.URL)
+
+	_, err := p.GetOrder(context.Background(), "79927398713")
+	require.ErrorIs(t, err, ErrInternal)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests), "failed request was retried")
+}
+
+func TestHTTPClient_GetOrder_Cancel(t *testing.T) {
+	defer logger.DeleteLogFolderAndFile(t)
+
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	p := testHTTPClient(t, ts.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := p.GetOrder(ctx, "79927398713")
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second, "request was not cancelled")
+}
+
+func TestHTTPClient_GetOrder(t *testing.T) {
+	defer logger.DeleteLogFolderAndFile(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/orders/0079927398713":
+		case "/api/orders/4532733309529845":
+			_, _ = w.Write([]byte(`{"order":`))
+			return
+		case "/api/orders/4929972884676289":
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		default:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		_, _ = w.Write([]byte(`{"order":"0079927398713","status":"PROCESSED","accrual":500}`))
+	}))
+	defer ts.Close()
+
+	p := testHTTPClient(t, ts.URL+"/")
+
+	o, err := p.GetOrder(context.Background(), "0079927398713")
+	require.NoError(t, err)
+	assert.Equal(t, &model.OrderInAccrual{Number: "0079927398713", Status: model.StatusProcessed, Accrual: 500}, o)
+
+	var re *ResponseError
+
+	_, err = p.GetOrder(context.Background(), "79927398713")
+	assert.ErrorIs(t, err, ErrNotRegistered)
+	require.ErrorAs(t, err, &re)
+	assert.Equal(t, http.StatusNoContent, re.StatusCode)
+
+	_, err = p.GetOrder(context.Background(), "4929972884676289")
+	assert.ErrorIs(t, err, ErrUnexpectedStatus)
+	require.ErrorAs(t, err, &re)
+	assert.Equal(t, http.StatusBadGateway, re.StatusCode)
+
+	// malformed body is kept for investigation
+	_, err = p.GetOrder(context.Background(), "4532733309529845")
+	require.ErrorAs(t, err, &re)
+	assert.Equal(t, `{"order":`, string(re.Body))
+}
@@ -0,0 +1,66 @@
+package poller
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/vlad-marlo/gophermart/internal/model"
+)
+
+// backoff returns delay before next check of order after attempts checks; delay grows exponentially from base up to
+// max and is randomized in range [d/2, d) so checks of orders registered at the same time are spread out.
+func backoff(attempts int, base, max time.Duration) time.Duration {
+	d := max
+	if attempts < 32 {
+		if exp := base << uint(attempts); exp > 0 && exp < max {
+			d = exp
+		}
+	}
+	if d <= 1 {
+		return d
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
+
+// reschedule releases lease of order which was not checked because accrual system rejected request in advance; order
+// is checked again after delay and rejection is not counted as check attempt.
+func (s *OrderPoller) reschedule(ctx context.Context, o *model.OrderInPoll, delay time.Duration) {
+	l := s.logger.WithFields(map[string]interface{}{
+		"user":  o.User,
+		"order": o.Number,
+	})
+
+	if err := s.store.Order().Reschedule(ctx, s.id, o.Number, delay); err != nil {
+		s.updateFailed(l, "reschedule", err)
+		return
+	}
+	l.Tracef("accrual system rejects requests; next check of order in %s", delay)
+}
+
+// postpone schedules next check of order which didn't get final status. Orders which are not processed for too long
+// are excluded from polling.
+func (s *OrderPoller) postpone(ctx context.Context, o *model.OrderInPoll) {
+	l := s.logger.WithFields(map[string]interface{}{
+		"user":     o.User,
+		"order":    o.Number,
+		"attempts": o.Attempts,
+	})
+
+	if s.config.OrderMaxAge > 0 && !o.UploadedAt.IsZero() && time.Since(o.UploadedAt) > s.config.OrderMaxAge {
+		if err := s.store.Order().MarkNeedsAttention(ctx, s.id, o.Number); err != nil {
+			s.updateFailed(l, "mark needs attention", err)
+			return
+		}
+		l.Warnf("order was not processed in %s and needs attention", s.config.OrderMaxAge)
+		return
+	}
+
+	delay := backoff(o.Attempts, s.config.PollBackoffBase, s.config.PollBackoffMax)
+	if err := s.store.Order().Postpone(ctx, s.id, o.Number, delay); err != nil {
+		s.updateFailed(l, "postpone", err)
+		return
+	}
+	l.Tracef("next check of order in %s", delay)
+}
@@ -0,0 +1,32 @@
+package poller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	base := time.Second
+	max := time.Minute
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{3, 8 * time.Second},
+		{6, time.Minute},
+		{100, time.Minute},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			got := backoff(tt.attempts, base, max)
+			assert.GreaterOrEqualf(t, got, tt.want/2, "attempts=%d", tt.attempts)
+			assert.Lessf(t, got, tt.want, "attempts=%d", tt.attempts)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package poller
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/pkg/logger"
+)
+
+const (
+	// breakerClosed lets all requests to accrual system through
+	breakerClosed breakerState = iota
+	// breakerOpen rejects all requests until timeout is expired
+	breakerOpen
+	// breakerHalfOpen lets limited count of probe requests through
+	breakerHalfOpen
+)
+
+type (
+	breakerState int
+	// breaker stops requests to accrual system after threshold of consecutive failures. After timeout breaker lets
+	// probes through; successful probes close breaker and failed probe opens it again.
+	breaker struct {
+		mu sync.Mutex
+		// threshold is count of consecutive failures which opens breaker
+		threshold int
+		// timeout is time during which breaker stays open
+		timeout time.Duration
+		// probes is max count of concurrent requests in half-open state
+		probes int
+
+		state    breakerState
+		failures int
+		openedAt time.Time
+		inFlight int
+
+		// stateVar publishes state of breaker with expvar
+		stateVar *expvar.String
+		logger   logger.Logger
+		now      func() time.Time
+	}
+	// breakerClient is AccrualClient which stops requests to one accrual system while its breaker is open, so
+	// unavailable provider doesn't stop polling of orders routed to other providers.
+	breakerClient struct {
+		client  AccrualClient
+		breaker *breaker
+	}
+)
+
+// String ...
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// newBreaker returns closed breaker of accrual system published under name; not positive threshold disables breaker.
+func newBreaker(l logger.Logger, name string, threshold int, timeout time.Duration, probes int) *breaker {
+	if probes <= 0 {
+		probes = 1
+	}
+	stateVar := new(expvar.String)
+	stateVar.Set(breakerClosed.String())
+	breakerStates.Set(name, stateVar)
+	return &breaker{
+		threshold: threshold,
+		timeout:   timeout,
+		probes:    probes,
+		stateVar:  stateVar,
+		logger:    l,
+		now:       time.Now,
+	}
+}
+
+// withBreaker returns client which sends requests to c through b.
+func withBreaker(c AccrualClient, b *breaker) AccrualClient {
+	return &breakerClient{
+		client:  c,
+		breaker: b,
+	}
+}
+
+// GetOrder returns ErrBreakerOpen without request to accrual system while breaker is open.
+func (c *breakerClient) GetOrder(ctx context.Context, number model.OrderNumber) (*model.OrderInAccrual, error) {
+	if !c.breaker.Allow() {
+		metrics.Add(metricBreakerRejected, 1)
+		return nil, ErrBreakerOpen
+	}
+
+	o, err := c.client.GetOrder(ctx, number)
+	var re *ResponseError
+	switch {
+	case err == nil:
+		c.breaker.Success()
+	case errors.Is(ctx.Err(), context.Canceled):
+		// request was interrupted by shutdown, so it is not counted as failure of accrual system
+		c.breaker.Abort()
+	case errors.Is(err, ErrTooManyRequests), errors.As(err, &re):
+		// too many requests and unexpected responses are answers of working accrual system
+		c.breaker.Success()
+	default:
+		c.breaker.Failure()
+	}
+	return o, err
+}
+
+// Blocked returns time during which requests are rejected by breaker or by rate limit of accrual system.
+func (c *breakerClient) Blocked() time.Duration {
+	d := c.breaker.OpenFor()
+	if paused := blocked(c.client); paused > d {
+		d = paused
+	}
+	return d
+}
+
+// BlockedFor ...
+func (c *breakerClient) BlockedFor(model.OrderNumber) time.Duration {
+	return c.Blocked()
+}
+
+// Allow reports whether request could be sent to accrual system. Every allowed request must be followed by Success or
+// Failure.
+func (b *breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen && b.now().Sub(b.openedAt) >= b.timeout {
+		b.setState(breakerHalfOpen)
+	}
+
+	switch b.state {
+	case breakerOpen:
+		return false
+	case breakerHalfOpen:
+		if b.inFlight >= b.probes {
+			return false
+		}
+		b.inFlight++
+	}
+	return true
+}
+
+// OpenFor returns time during which breaker rejects all requests; zero means that breaker is not open.
+func (b *breaker) OpenFor() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != breakerOpen {
+		return 0
+	}
+	if d := b.timeout - b.now().Sub(b.openedAt); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// Success records successful request.
+func (b *breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	if b.state == breakerHalfOpen {
+		b.inFlight--
+		b.setState(breakerClosed)
+	}
+}
+
+// Failure records failed request.
+func (b *breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerHalfOpen:
+		b.inFlight--
+		b.open()
+	case breakerClosed:
+		b.failures++
+		if b.threshold > 0 && b.failures >= b.threshold {
+			b.open()
+		}
+	}
+}
+
+// Abort records request which was cancelled before it got result.
+func (b *breaker) Abort() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerHalfOpen {
+		b.inFlight--
+	}
+}
+
+// open must be called with mu locked.
+func (b *breaker) open() {
+	b.openedAt = b.now()
+	b.failures = 0
+	b.setState(breakerOpen)
+}
+
+// setState must be called with mu locked.
+func (b *breaker) setState(s breakerState) {
+	if b.state == s {
+		return
+	}
+	if s == breakerClosed {
+		b.inFlight = 0
+	}
+	b.logger.Warnf("accrual circuit breaker: %s -> %s", b.state, s)
+	b.state = s
+	b.stateVar.Set(s.String())
+	metrics.Add(metricBreakerTransitions, 1)
+}
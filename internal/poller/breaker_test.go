@@ -0,0 +1,137 @@
+package poller
+
+import (
+	"context"
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/pkg/logger"
+)
+
+func TestBreaker(t *testing.T) {
+	defer logger.DeleteLogFolderAndFile(t)
+
+	now := time.Now()
+	b := newBreaker(testPoller(t, 1).logger, "test", 3, time.Minute, 1)
+	b.now = func() time.Time { return now }
+
+	// failures which are not consecutive don't open breaker
+	for i := 0; i < 2; i++ {
+		require.True(t, b.Allow())
+		b.Failure()
+	}
+	require.True(t, b.Allow())
+	b.Success()
+	for i := 0; i < 2; i++ {
+		require.True(t, b.Allow())
+		b.Failure()
+	}
+	assert.Equal(t, breakerClosed, b.state)
+
+	require.True(t, b.Allow())
+	b.Failure()
+	assert.Equal(t, breakerOpen, b.state)
+	assert.Equal(t, time.Minute, b.OpenFor())
+	assert.False(t, b.Allow())
+	assert.Equal(t, breakerOpen.String(), breakerStates.Get("test").(*expvar.String).Value())
+
+	// only one probe is allowed after timeout
+	now = now.Add(time.Minute)
+	assert.Zero(t, b.OpenFor())
+	require.True(t, b.Allow())
+	assert.Equal(t, breakerHalfOpen, b.state)
+	assert.False(t, b.Allow())
+
+	// failed probe opens breaker again
+	b.Failure()
+	assert.Equal(t, breakerOpen, b.state)
+	assert.False(t, b.Allow())
+
+	// cancelled probe doesn't change state
+	now = now.Add(time.Minute)
+	require.True(t, b.Allow())
+	b.Abort()
+	assert.Equal(t, breakerHalfOpen, b.state)
+
+	// successful probe closes breaker
+	require.True(t, b.Allow())
+	b.Success()
+	assert.Equal(t, breakerClosed, b.state)
+	assert.True(t, b.Allow())
+	assert.Equal(t, breakerClosed.String(), breakerStates.Get("test").(*expvar.String).Value())
+}
+
+func TestBreaker_Disabled(t *testing.T) {
+	defer logger.DeleteLogFolderAndFile(t)
+
+	b := newBreaker(testPoller(t, 1).logger, "disabled", 0, time.Minute, 1)
+	for i := 0; i < 100; i++ {
+		require.True(t, b.Allow())
+		b.Failure()
+	}
+	assert.Zero(t, b.OpenFor())
+}
+
+func TestBreakerClient(t *testing.T) {
+	defer logger.DeleteLogFolderAndFile(t)
+	ctx := context.Background()
+	l := testPoller(t, 1).logger
+
+	def := &fakeClient{order: &model.OrderInAccrual{Status: model.StatusProcessed}}
+	down := &fakeClient{err: ErrInternal}
+	r := NewRouter(
+		withBreaker(def, newBreaker(l, "default", 1, time.Minute, 1)),
+		Route{Prefix: "4", Client: withBreaker(down, newBreaker(l, "down", 1, time.Minute, 1))},
+	)
+
+	_, err := r.GetOrder(ctx, "4929972884676289")
+	assert.ErrorIs(t, err, ErrInternal)
+	_, err = r.GetOrder(ctx, "4532733309529845")
+	assert.ErrorIs(t, err, ErrBreakerOpen)
+	assert.Len(t, down.requested, 1, "request was sent through open breaker")
+	assert.Equal(t, time.Minute, r.BlockedFor("4532733309529845").Round(time.Second))
+	assert.Zero(t, r.BlockedFor("79927398713"))
+	assert.Zero(t, r.Blocked(), "router is blocked while default provider accepts requests")
+
+	// provider which is down doesn't stop requests to other providers
+	o, err := r.GetOrder(ctx, "79927398713")
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusProcessed, o.Status)
+	assert.Equal(t, breakerOpen.String(), breakerStates.Get("down").(*expvar.String).Value())
+	assert.Equal(t, breakerClosed.String(), breakerStates.Get("default").(*expvar.String).Value())
+}
+
+func TestBreakerClient_Answers(t *testing.T) {
+	defer logger.DeleteLogFolderAndFile(t)
+	ctx := context.Background()
+	l := testPoller(t, 1).logger
+
+	// answers of working accrual system don't open breaker
+	for _, err := range []error{ErrTooManyRequests, &ResponseError{StatusCode: 502, Err: ErrUnexpectedStatus}} {
+		c := withBreaker(&fakeClient{err: err}, newBreaker(l, "answers", 1, time.Minute, 1))
+		for i := 0; i < 3; i++ {
+			_, got := c.GetOrder(ctx, "79927398713")
+			assert.ErrorIs(t, got, err)
+		}
+	}
+
+	// cancelled request is not counted as failure
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	c := withBreaker(&fakeClient{err: context.Canceled}, newBreaker(l, "cancelled", 1, time.Minute, 1))
+	_, _ = c.GetOrder(cancelled, "79927398713")
+	_, err := c.GetOrder(ctx, "79927398713")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// request which is not answered before deadline is counted as failure
+	expired, cancel := context.WithTimeout(ctx, -time.Second)
+	defer cancel()
+	c = withBreaker(&fakeClient{err: context.DeadlineExceeded}, newBreaker(l, "timeout", 1, time.Minute, 1))
+	_, _ = c.GetOrder(expired, "79927398713")
+	_, err = c.GetOrder(ctx, "79927398713")
+	assert.ErrorIs(t, err, ErrBreakerOpen)
+}
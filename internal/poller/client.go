@@ -0,0 +1,131 @@
+package poller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vlad-marlo/gophermart/internal/config"
+	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/pkg/logger"
+)
+
+type (
+	// AccrualClient returns state of order in accrual system.
+	AccrualClient interface {
+		// GetOrder returns state of order with number. Returns ErrTooManyRequests if accrual system asked to slow down,
+		// ErrInternal or ErrUnexpectedStatus if order state could not be received
+		GetOrder(ctx context.Context, number model.OrderNumber) (*model.OrderInAccrual, error)
+	}
+	// blocker is implemented by clients which reject requests without sending them to accrual system while its circuit
+	// breaker is open or accrual system asked to slow down.
+	blocker interface {
+		// Blocked returns time during which requests for all orders are rejected; zero means that some requests
+		// could be sent
+		Blocked() time.Duration
+		// BlockedFor returns time during which requests for order with number are rejected
+		BlockedFor(number model.OrderNumber) time.Duration
+	}
+	// Route sends orders which numbers start with Prefix to Client.
+	Route struct {
+		Prefix string
+		Client AccrualClient
+	}
+	// Router is AccrualClient which sends every order to accrual system chosen by the longest matching prefix of its
+	// number; orders without matching route are sent to default client.
+	Router struct {
+		def    AccrualClient
+		routes []Route
+	}
+)
+
+// NewRouter returns router which sends orders to def if none of routes is matching order number.
+func NewRouter(def AccrualClient, routes ...Route) *Router {
+	r := &Router{
+		def:    def,
+		routes: append([]Route(nil), routes...),
+	}
+	sort.SliceStable(r.routes, func(i, j int) bool {
+		return len(r.routes[i].Prefix) > len(r.routes[j].Prefix)
+	})
+	return r
+}
+
+// GetOrder ...
+func (r *Router) GetOrder(ctx context.Context, number model.OrderNumber) (*model.OrderInAccrual, error) {
+	return r.route(number).GetOrder(ctx, number)
+}
+
+// Blocked returns time during which requests to all accrual systems are rejected.
+func (r *Router) Blocked() time.Duration {
+	d := blocked(r.def)
+	for _, rt := range r.routes {
+		if rd := blocked(rt.Client); rd < d {
+			d = rd
+		}
+	}
+	return d
+}
+
+// BlockedFor ...
+func (r *Router) BlockedFor(number model.OrderNumber) time.Duration {
+	return blockedFor(r.route(number), number)
+}
+
+// blocked returns time during which c rejects requests for all orders.
+func blocked(c AccrualClient) time.Duration {
+	if b, ok := c.(blocker); ok {
+		return b.Blocked()
+	}
+	return 0
+}
+
+// blockedFor returns time during which c rejects requests for order with number.
+func blockedFor(c AccrualClient, number model.OrderNumber) time.Duration {
+	if b, ok := c.(blocker); ok {
+		return b.BlockedFor(number)
+	}
+	return 0
+}
+
+// route returns client of accrual system which is responsible for order.
+func (r *Router) route(number model.OrderNumber) AccrualClient {
+	for _, rt := range r.routes {
+		if strings.HasPrefix(number.String(), rt.Prefix) {
+			return rt.Client
+		}
+	}
+	return r.def
+}
+
+// NewAccrualClient returns client of accrual systems configured in cfg: orders are routed to providers from
+// cfg.AccrualProviders by number prefix, other orders are sent to cfg.AccuralSystemAddress. Every accrual system has
+// its own circuit breaker.
+func NewAccrualClient(l logger.Logger, cfg *config.Config) (AccrualClient, error) {
+	def := newProviderClient(l, cfg, cfg.AccuralSystemAddress)
+	if len(cfg.AccrualProviders) == 0 {
+		return def, nil
+	}
+
+	routes := make([]Route, 0, len(cfg.AccrualProviders))
+	for _, p := range cfg.AccrualProviders {
+		prefix, address, ok := strings.Cut(p, "=")
+		if !ok || prefix == "" || address == "" {
+			return nil, fmt.Errorf("bad accrual provider %q: want prefix=address", p)
+		}
+		routes = append(routes, Route{
+			Prefix: prefix,
+			Client: newProviderClient(l, cfg, address),
+		})
+	}
+	return NewRouter(def, routes...), nil
+}
+
+// newProviderClient returns client of accrual system served on address guarded by circuit breaker.
+func newProviderClient(l logger.Logger, cfg *config.Config, address string) AccrualClient {
+	l = l.WithField("provider", address)
+	b := newBreaker(l, address, cfg.BreakerThreshold, cfg.BreakerTimeout, cfg.BreakerProbes)
+	return withBreaker(NewHTTPClient(l, address), b)
+}
@@ -0,0 +1,90 @@
+package poller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vlad-marlo/gophermart/internal/config"
+	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/pkg/logger"
+)
+
+// fakeClient is accrual system which returns the same order state for every number and remembers requested numbers.
+type fakeClient struct {
+	order     *model.OrderInAccrual
+	err       error
+	requested []model.OrderNumber
+}
+
+// GetOrder ...
+func (c *fakeClient) GetOrder(_ context.Context, number model.OrderNumber) (*model.OrderInAccrual, error) {
+	c.requested = append(c.requested, number)
+	if c.err != nil {
+		return nil, c.err
+	}
+	o := *c.order
+	o.Number = number
+	return &o, nil
+}
+
+func TestRouter_GetOrder(t *testing.T) {
+	def := &fakeClient{order: &model.OrderInAccrual{Status: model.StatusProcessed}}
+	short := &fakeClient{order: &model.OrderInAccrual{Status: model.StatusProcessing}}
+	long := &fakeClient{err: ErrInternal}
+
+	r := NewRouter(def, Route{Prefix: "4", Client: short}, Route{Prefix: "45", Client: long})
+
+	tests := []struct {
+		number model.OrderNumber
+		client *fakeClient
+	}{
+		{"79927398713", def},
+		{"4929972884676289", short},
+		{"4532733309529845", long},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.number.String(), func(t *testing.T) {
+			_, _ = r.GetOrder(context.Background(), tt.number)
+			require.NotEmpty(t, tt.client.requested)
+			assert.Equal(t, tt.number, tt.client.requested[len(tt.client.requested)-1])
+		})
+	}
+
+	o, err := r.GetOrder(context.Background(), "4929972884676289")
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusProcessing, o.Status)
+
+	_, err = r.GetOrder(context.Background(), "4532733309529845")
+	assert.ErrorIs(t, err, ErrInternal)
+}
+
+func TestNewAccrualClient(t *testing.T) {
+	defer logger.DeleteLogFolderAndFile(t)
+	l := testPoller(t, 1).logger
+
+	c, err := NewAccrualClient(l, &config.Config{AccuralSystemAddress: "http://localhost:8080"})
+	require.NoError(t, err)
+	require.IsType(t, &breakerClient{}, c)
+	assert.IsType(t, &httpClient{}, c.(*breakerClient).client)
+
+	c, err = NewAccrualClient(l, &config.Config{
+		AccuralSystemAddress: "http://localhost:8080",
+		AccrualProviders:     []string{"4=http://localhost:8081", "5=http://localhost:8082"},
+	})
+	require.NoError(t, err)
+	require.IsType(t, &Router{}, c)
+	provider := func(number model.OrderNumber) *breakerClient {
+		return c.(*Router).route(number).(*breakerClient)
+	}
+	assert.Equal(t, "http://localhost:8081", provider("4929972884676289").client.(*httpClient).address)
+	assert.Equal(t, "http://localhost:8080", provider("79927398713").client.(*httpClient).address)
+	assert.NotSame(t, provider("4929972884676289").breaker, provider("79927398713").breaker, "providers share breaker")
+
+	for _, p := range []string{"http://localhost:8081", "4=", "=http://localhost:8081"} {
+		_, err = NewAccrualClient(l, &config.Config{AccrualProviders: []string{p}})
+		assert.Errorf(t, err, "provider %q", p)
+	}
+}
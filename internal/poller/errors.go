@@ -1,9 +1,31 @@
 package poller
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	ErrInternal         = errors.New("internal server error")
 	ErrTooManyRequests  = errors.New("too many requests")
 	ErrUnexpectedStatus = errors.New("got unexpected status")
+	ErrNotRegistered    = errors.New("order is not registered in accrual system")
+	ErrBreakerOpen      = errors.New("accrual circuit breaker is open")
 )
+
+// ResponseError is returned when accrual system answered, but order state could not be got from its response.
+type ResponseError struct {
+	StatusCode int
+	Body       []byte
+	Err        error
+}
+
+// Error ...
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("response with status code %d: %v", e.StatusCode, e.Err)
+}
+
+// Unwrap ...
+func (e *ResponseError) Unwrap() error {
+	return e.Err
+}
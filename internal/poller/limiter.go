@@ -0,0 +1,101 @@
+package poller
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimitRe matches body of accrual system response with 429 status code
+var rateLimitRe = regexp.MustCompile(`(\d+) requests per minute`)
+
+// limiter spaces out requests to accrual system. Limiter is shared by all workers, so when accrual system asks one of
+// them to slow down all the others are paused too.
+type limiter struct {
+	mu sync.Mutex
+	// interval is min duration between two requests; zero means no limit
+	interval time.Duration
+	// next is time when next request is allowed by rate limit
+	next time.Time
+	// pausedUntil is time until which all requests are postponed
+	pausedUntil time.Time
+}
+
+// Wait blocks until request is allowed or ctx is done. If request is not allowed before deadline of ctx, then Wait
+// returns ErrTooManyRequests right away instead of waiting for deadline.
+func (l *limiter) Wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		at := l.next
+		if at.Before(l.pausedUntil) {
+			at = l.pausedUntil
+		}
+		if !at.After(now) {
+			l.next = now.Add(l.interval)
+			l.mu.Unlock()
+			return nil
+		}
+		l.mu.Unlock()
+
+		if deadline, ok := ctx.Deadline(); ok && at.After(deadline) {
+			return ErrTooManyRequests
+		}
+
+		t := time.NewTimer(at.Sub(now))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Pause postpones all requests for d.
+func (l *limiter) Pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until := time.Now().Add(d); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+// PausedFor returns time during which all requests are postponed.
+func (l *limiter) PausedFor() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if d := time.Until(l.pausedUntil); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// SetRate limits count of requests per minute; not positive rate removes limit.
+func (l *limiter) SetRate(perMinute int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if perMinute <= 0 {
+		l.interval = 0
+		return
+	}
+	l.interval = time.Minute / time.Duration(perMinute)
+}
+
+// parseRateLimit returns count of requests per minute advertised in body of response with 429 status code.
+func parseRateLimit(body []byte) (int, bool) {
+	m := rateLimitRe.FindSubmatch(body)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(string(m[1]))
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
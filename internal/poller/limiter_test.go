@@ -0,0 +1,76 @@
+package poller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter_Pause(t *testing.T) {
+	l := new(limiter)
+
+	start := time.Now()
+	require.NoError(t, l.Wait(context.Background()))
+	assert.Less(t, time.Since(start), 50*time.Millisecond, "request without limit was postponed")
+
+	l.Pause(100 * time.Millisecond)
+
+	start = time.Now()
+	require.NoError(t, l.Wait(context.Background()))
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond, "request was not paused")
+}
+
+func TestLimiter_SetRate(t *testing.T) {
+	l := new(limiter)
+	// 1200 requests per minute is one request per 50ms
+	l.SetRate(1200)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, l.Wait(context.Background()))
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond, "requests were not spaced out")
+}
+
+func TestLimiter_PausedFor(t *testing.T) {
+	l := new(limiter)
+	assert.Zero(t, l.PausedFor())
+
+	l.Pause(time.Minute)
+	assert.InDelta(t, time.Minute, l.PausedFor(), float64(time.Second))
+}
+
+func TestLimiter_WaitCancelled(t *testing.T) {
+	l := new(limiter)
+	l.Pause(time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	assert.ErrorIs(t, l.Wait(ctx), context.Canceled)
+}
+
+func TestLimiter_WaitPastDeadline(t *testing.T) {
+	l := new(limiter)
+	l.Pause(time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	start := time.Now()
+	assert.ErrorIs(t, l.Wait(ctx), ErrTooManyRequests)
+	assert.Less(t, time.Since(start), 100*time.Millisecond, "limiter waited for deadline")
+}
+
+func TestParseRateLimit(t *testing.T) {
+	n, ok := parseRateLimit([]byte("No more than 10 requests per minute allowed"))
+	assert.True(t, ok)
+	assert.Equal(t, 10, n)
+
+	_, ok = parseRateLimit([]byte("too many requests"))
+	assert.False(t, ok)
+}
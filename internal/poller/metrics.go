@@ -0,0 +1,55 @@
+package poller
+
+import (
+	"expvar"
+	"time"
+)
+
+// metrics of poller are published with expvar under "poller" key
+var (
+	metrics = expvar.NewMap("poller")
+
+	// queueDepth is count of orders waiting in job queue
+	queueDepth = new(expvar.Int)
+	// inFlight is count of orders which are queued or processed by workers
+	inFlight = new(expvar.Int)
+	// lastLatency is duration of last order processing in seconds
+	lastLatency = new(expvar.Float)
+	// rateLimit is count of requests per minute allowed by accrual system
+	rateLimit = new(expvar.Int)
+	// breakerStates contains state of circuit breaker of every accrual system by its address
+	breakerStates = new(expvar.Map)
+)
+
+const (
+	metricQueueDepth   = "queue_depth"
+	metricInFlight     = "in_flight"
+	metricProcessed    = "processed"
+	metricDeduplicated = "deduplicated"
+	metricDropped      = "dropped"
+	metricClaimLost    = "claim_lost"
+	metricDeadLettered = "dead_lettered"
+	metricLatencyLast  = "latency_last_seconds"
+	metricLatencyTotal = "latency_total_seconds"
+	metricRateLimited  = "rate_limited"
+	metricRateLimit    = "rate_limit_per_minute"
+
+	metricBreakerState       = "breaker_state"
+	metricBreakerTransitions = "breaker_transitions"
+	metricBreakerRejected    = "breaker_rejected"
+)
+
+func init() {
+	metrics.Set(metricQueueDepth, queueDepth)
+	metrics.Set(metricInFlight, inFlight)
+	metrics.Set(metricLatencyLast, lastLatency)
+	metrics.Set(metricRateLimit, rateLimit)
+	metrics.Set(metricBreakerState, breakerStates)
+}
+
+// observeLatency ...
+func observeLatency(d time.Duration) {
+	metrics.Add(metricProcessed, 1)
+	metrics.AddFloat(metricLatencyTotal, d.Seconds())
+	lastLatency.Set(d.Seconds())
+}
@@ -4,47 +4,122 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"github.com/go-resty/resty/v2"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
 	"github.com/vlad-marlo/gophermart/internal/config"
+	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/internal/service"
 	"github.com/vlad-marlo/gophermart/internal/store"
 	"github.com/vlad-marlo/gophermart/pkg/logger"
-	"time"
 )
 
+// listenReconnectInterval is pause before listening of registered orders is restarted after error
+const listenReconnectInterval = time.Second
+
 type (
 	OrderPoller struct {
-		queue  chan struct{}
+		// id identifies poller in leases of orders, so orders are checked by one instance of application at a time
+		id string
+		// done is closed when poller stops taking new orders
+		done chan struct{}
+		// ctx is passed to checks of orders; it is cancelled when in-flight checks didn't finish before deadline of Close
+		ctx    context.Context
+		cancel context.CancelFunc
+		// wg waits for goroutines of poller
+		wg     sync.WaitGroup
+		closed sync.Once
+		jobs   chan *model.OrderInPoll
+		// notify receives freshly registered orders which must be checked without waiting for ticker
+		notify chan *model.OrderInPoll
 		store  store.Storage
+		// orders applies state of orders got from accrual system
+		orders *service.OrderService
 		logger logger.Logger
 		config *config.Config
-		client *resty.Client
+		// accrual is shared by all workers
+		accrual AccrualClient
+
+		// mu protects inFlight which contains numbers of queued and processed orders
+		mu       sync.Mutex
+		inFlight map[model.OrderNumber]struct{}
 	}
 )
 
-func New(l logger.Logger, s store.Storage, cfg *config.Config, interval time.Duration) *OrderPoller {
+// New starts poller which checks orders with client; ctx is parent of context of all requests to accrual system and
+// store made by poller.
+func New(ctx context.Context, l logger.Logger, s store.Storage, cfg *config.Config, client AccrualClient) *OrderPoller {
+	id := cfg.InstanceID
+	if id == "" {
+		id = uuid.NewString()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
 	p := &OrderPoller{
-		queue:  make(chan struct{}),
-		store:  s,
-		logger: l,
-		config: cfg,
-		client: resty.New().SetRetryAfter(retryFunc).SetRetryCount(3),
+		id:       id,
+		done:     make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+		jobs:     make(chan *model.OrderInPoll, cfg.PollQueueSize),
+		notify:   make(chan *model.OrderInPoll, cfg.PollQueueSize),
+		store:    s,
+		orders:   service.NewOrderService(l, s),
+		logger:   l,
+		config:   cfg,
+		accrual:  client,
+		inFlight: make(map[model.OrderNumber]struct{}),
 	}
 
+	workers := cfg.PollWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.worker()
+	}
+
+	if cfg.PollListen {
+		p.wg.Add(1)
+		go p.listen()
+	}
+
+	p.wg.Add(1)
 	go func() {
-		t := time.NewTicker(interval)
+		defer p.wg.Done()
+		// ticker is safety sweep for orders which notifications were lost
+		t := time.NewTicker(cfg.PollInterval)
+		defer t.Stop()
 		for {
 			select {
 			case <-t.C:
-				orders, err := p.store.Order().GetUnprocessedOrders(context.Background())
+				// orders are not claimed while all accrual systems reject requests
+				if d := blocked(p.accrual); d > 0 {
+					l.Debugf("accrual systems reject requests for %s; orders are not claimed", d)
+					continue
+				}
+				orders, err := p.store.Order().ClaimUnprocessedOrders(
+					p.ctx,
+					p.id,
+					cap(p.jobs)-len(p.jobs),
+					cfg.PollLease,
+				)
 				if err != nil && !errors.Is(err, store.ErrNoContent) {
 					l.Error(fmt.Sprintf("get unprocessed orders: %v", err))
 					continue
 				}
 				for _, order := range orders {
-					p.pollWork(order)
+					// orders of accrual system which rejects requests are not dispatched to workers
+					if d := blockedFor(p.accrual, order.Number); d > 0 {
+						p.reschedule(p.ctx, order, d)
+						continue
+					}
+					p.enqueue(order)
 				}
-			case <-p.queue:
-				l.Trace("graceful closed poller")
+			case o := <-p.notify:
+				p.enqueue(o)
+			case <-p.done:
 				return
 			}
 		}
@@ -52,7 +127,150 @@ func New(l logger.Logger, s store.Storage, cfg *config.Config, interval time.Dur
 	return p
 }
 
-// Close ...
-func (s *OrderPoller) Close() {
-	close(s.queue)
+// Notify asks poller to check freshly registered order right away. Notify doesn't block; if too many orders are
+// waiting then order will be checked by ticker.
+func (s *OrderPoller) Notify(o *model.OrderInPoll) {
+	select {
+	case s.notify <- o:
+	default:
+		metrics.Add(metricDropped, 1)
+	}
+}
+
+// listen receives orders registered by all instances of gophermart through database notifications.
+func (s *OrderPoller) listen() {
+	defer s.wg.Done()
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		err := s.store.Order().ListenRegistered(ctx, s.notify)
+		if ctx.Err() != nil {
+			return
+		}
+		s.logger.Errorf("listen registered orders: %v", err)
+
+		// reconnect after pause
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(listenReconnectInterval):
+		}
+	}
+}
+
+// enqueue adds order to job queue if it is not already queued or processed by worker.
+func (s *OrderPoller) enqueue(o *model.OrderInPoll) bool {
+	s.mu.Lock()
+	if _, ok := s.inFlight[o.Number]; ok {
+		s.mu.Unlock()
+		metrics.Add(metricDeduplicated, 1)
+		return false
+	}
+	s.inFlight[o.Number] = struct{}{}
+	inFlight.Set(int64(len(s.inFlight)))
+	s.mu.Unlock()
+
+	select {
+	case s.jobs <- o:
+		queueDepth.Set(int64(len(s.jobs)))
+		return true
+	default:
+		s.release(o.Number)
+		metrics.Add(metricDropped, 1)
+		s.logger.WithField("order", o.Number).Debug("job queue is full")
+		return false
+	}
+}
+
+// release allows order to be queued again.
+func (s *OrderPoller) release(number model.OrderNumber) {
+	s.mu.Lock()
+	delete(s.inFlight, number)
+	inFlight.Set(int64(len(s.inFlight)))
+	s.mu.Unlock()
+}
+
+// worker processes orders from job queue until poller is closed. Orders left in queue are checked after their leases
+// are expired.
+func (s *OrderPoller) worker() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case o := <-s.jobs:
+			queueDepth.Set(int64(len(s.jobs)))
+			select {
+			case <-s.done:
+				s.release(o.Number)
+				return
+			default:
+			}
+
+			// accrual system could start to reject requests while order was waiting in queue; order leased by poller
+			// is released until accrual system accepts requests again and freshly registered order is left for sweep
+			if d := blockedFor(s.accrual, o.Number); d > 0 {
+				s.reschedule(s.ctx, o, d)
+				s.release(o.Number)
+				continue
+			}
+
+			// order could wait in queue longer than lease, so lease is renewed before check
+			if s.claim(o) {
+				start := time.Now()
+				s.pollWork(o)
+				observeLatency(time.Since(start))
+			}
+
+			s.release(o.Number)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// claim leases order to poller and reports whether order must be checked by it.
+func (s *OrderPoller) claim(o *model.OrderInPoll) bool {
+	ok, err := s.store.Order().ClaimOrder(s.ctx, s.id, o.Number, s.config.PollLease)
+	if err != nil {
+		s.logger.WithField("order", o.Number).Errorf("claim order: %v", err)
+		return false
+	}
+	if !ok {
+		metrics.Add(metricClaimLost, 1)
+	}
+	return ok
+}
+
+// Close stops polling and waits until in-flight checks of orders are finished. If ctx is done earlier, then checks are
+// cancelled and ctx error is returned; transactions of cancelled checks are rolled back.
+func (s *OrderPoller) Close(ctx context.Context) error {
+	s.closed.Do(func() {
+		close(s.done)
+	})
+	defer s.cancel()
+
+	finished := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		s.logger.Trace("graceful closed poller")
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		<-finished
+		return fmt.Errorf("wait for in-flight orders: %w", ctx.Err())
+	}
 }
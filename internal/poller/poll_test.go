@@ -0,0 +1,181 @@
+package poller
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vlad-marlo/gophermart/internal/config"
+	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/internal/store/memstore"
+	"github.com/vlad-marlo/gophermart/pkg/logger"
+)
+
+// testPoller returns poller without workers and store.
+func testPoller(t *testing.T, queueSize int) *OrderPoller {
+	t.Helper()
+
+	log := logrus.New()
+	log.Out = io.Discard
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	return &OrderPoller{
+		done:     make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+		jobs:     make(chan *model.OrderInPoll, queueSize),
+		notify:   make(chan *model.OrderInPoll, queueSize),
+		logger:   logger.GetLoggerByEntry(logrus.NewEntry(log)),
+		config:   &config.Config{},
+		accrual:  new(fakeClient),
+		inFlight: make(map[model.OrderNumber]struct{}),
+	}
+}
+
+func TestOrderPoller_Enqueue(t *testing.T) {
+	defer logger.DeleteLogFolderAndFile(t)
+	p := testPoller(t, 2)
+
+	o1 := &model.OrderInPoll{Number: "79927398713"}
+	o2 := &model.OrderInPoll{Number: "4929972884676289"}
+	o3 := &model.OrderInPoll{Number: "4532733309529845"}
+
+	assert.True(t, p.enqueue(o1))
+	assert.False(t, p.enqueue(o1), "order was queued twice")
+	assert.True(t, p.enqueue(o2))
+	assert.False(t, p.enqueue(o3), "order was queued to full queue")
+	assert.Len(t, p.jobs, 2)
+
+	// dropped order could be queued after queue was freed
+	<-p.jobs
+	assert.True(t, p.enqueue(o3))
+
+	// order could be queued again after it was processed
+	assert.False(t, p.enqueue(o1))
+	p.release(o1.Number)
+	<-p.jobs
+	assert.True(t, p.enqueue(o1))
+}
+
+func TestOrderPoller_Notify(t *testing.T) {
+	defer logger.DeleteLogFolderAndFile(t)
+	p := testPoller(t, 1)
+
+	o1 := &model.OrderInPoll{Number: "79927398713"}
+	o2 := &model.OrderInPoll{Number: "4929972884676289"}
+
+	p.Notify(o1)
+	// notification doesn't block when channel is full
+	p.Notify(o2)
+
+	assert.Equal(t, o1, <-p.notify)
+	assert.Len(t, p.notify, 0)
+}
+
+func TestOrderPoller_Close(t *testing.T) {
+	defer logger.DeleteLogFolderAndFile(t)
+
+	t.Run("waits for in-flight work", func(t *testing.T) {
+		p := testPoller(t, 1)
+
+		p.wg.Add(1)
+		finished := make(chan struct{})
+		go func() {
+			defer p.wg.Done()
+			<-p.done
+			time.Sleep(10 * time.Millisecond)
+			close(finished)
+		}()
+
+		require.NoError(t, p.Close(context.Background()))
+		select {
+		case <-finished:
+		default:
+			t.Fatal("close returned before in-flight work was finished")
+		}
+		assert.NoError(t, p.Close(context.Background()), "second close must not panic")
+	})
+
+	t.Run("cancels work after deadline", func(t *testing.T) {
+		p := testPoller(t, 1)
+
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			<-p.ctx.Done()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err := p.Close(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Error(t, p.ctx.Err())
+	})
+}
+
+func TestOrderPoller_CheckOrder_Outcome(t *testing.T) {
+	defer logger.DeleteLogFolderAndFile(t)
+	o := &model.OrderInPoll{Number: "79927398713"}
+
+	tests := []struct {
+		name    string
+		err     error
+		cancel  bool
+		want    checkOutcome
+		failure bool
+	}{
+		{name: "breaker is open", err: ErrBreakerOpen, want: checkRejected},
+		{name: "too many requests", err: ErrTooManyRequests, want: checkRejected},
+		{name: "accrual is down", err: ErrInternal, want: checkRetry},
+		{name: "unexpected response", err: &ResponseError{StatusCode: 502, Err: ErrUnexpectedStatus}, want: checkRetry, failure: true},
+		{name: "shutdown", err: context.Canceled, cancel: true, want: checkSkip},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := testPoller(t, 1)
+			p.accrual = &fakeClient{err: tt.err}
+			if tt.cancel {
+				p.cancel()
+			}
+
+			outcome, failure := p.checkOrder(p.ctx, o)
+			assert.Equal(t, tt.want, outcome)
+			assert.Equal(t, tt.failure, failure != nil)
+		})
+	}
+}
+
+func TestOrderPoller_PollWork_Rejected(t *testing.T) {
+	defer logger.DeleteLogFolderAndFile(t)
+	ctx := context.Background()
+
+	p := testPoller(t, 1)
+	p.id = "test"
+	p.store = memstore.New()
+	p.config.PollBackoffBase = time.Hour
+
+	const number model.OrderNumber = "79927398713"
+	require.NoError(t, p.store.Order().Register(ctx, 1, number))
+	ok, err := p.store.Order().ClaimOrder(ctx, p.id, number, time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// rejected request releases lease without counting attempt and order is not due until accrual accepts requests
+	p.accrual = &fakeClient{err: ErrBreakerOpen}
+	p.pollWork(&model.OrderInPoll{Number: number, User: 1})
+
+	o, err := p.store.Order().GetActiveByNumber(ctx, number)
+	require.NoError(t, err)
+	assert.Equal(t, 0, o.Attempts)
+	orders, err := p.store.Order().ClaimUnprocessedOrders(ctx, "other", 10, time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, orders)
+}
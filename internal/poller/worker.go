@@ -2,49 +2,144 @@ package poller
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"time"
+
 	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/internal/service"
+	"github.com/vlad-marlo/gophermart/internal/store"
+	"github.com/vlad-marlo/gophermart/pkg/logger"
+)
+
+const (
+	// checkDone means that order got final status or must not be checked anymore
+	checkDone checkOutcome = iota
+	// checkRetry means that order must be checked again after backoff
+	checkRetry
+	// checkSkip means that check was interrupted by shutdown; order is checked again after its lease is expired
+	checkSkip
+	// checkRejected means that request was rejected by circuit breaker or rate limit without check of order, so order
+	// must be checked again when accrual system accepts requests
+	checkRejected
 )
 
-// pollWork ...
+// checkOutcome is result of check of order in accrual system.
+type checkOutcome int
+
+// pollWork checks order in accrual system and schedules next check if order didn't get final status. Orders which
+// state can't be got from accrual system are dead-lettered after cfg.PollMaxFailures checks.
 func (s *OrderPoller) pollWork(o *model.OrderInPoll) {
-	ctx := context.Background()
+	ctx := s.ctx
+	outcome, failure := s.checkOrder(ctx, o)
+	switch {
+	case outcome == checkDone, outcome == checkSkip, ctx.Err() != nil:
+		return
+	case outcome == checkRejected:
+		s.reschedule(ctx, o, s.rejectedFor(o.Number))
+		return
+	case failure != nil && s.recordFailure(ctx, o, failure):
+		return
+	}
+	s.postpone(ctx, o)
+}
 
+// checkOrder updates order by its state in accrual system and returns what must be done with order next. failure is
+// not nil if accrual system answered but state of order could not be resolved from answer.
+func (s *OrderPoller) checkOrder(ctx context.Context, o *model.OrderInPoll) (outcome checkOutcome, failure *ResponseError) {
 	l := s.logger.WithFields(map[string]interface{}{
 		"user":  o.User,
 		"order": o.Number,
 	})
 
-	order, err := s.GetOrderFromAccrual(o.Number)
+	order, err := s.getOrder(ctx, o.Number)
 	if err != nil {
-		return
-	}
-
-	l.Trace(fmt.Sprintf("got order from accrual Order{Status: %s, Accrual: %f, Number: %d}", order.Status, order.Accrual, order.Number))
-	switch order.Status {
-	case model.StatusProcessing:
-		if err := s.store.Order().ChangeStatus(ctx, o.User, order); err != nil {
-			l.Warnf("change status: %v", err)
+		// check was interrupted by shutdown, so it is not counted as failed attempt
+		if ctx.Err() != nil {
+			return checkSkip, nil
 		}
-	case "REGISTERED":
-	case model.StatusInvalid:
-		if err := s.store.Order().ChangeStatus(ctx, o.User, order); err != nil {
-			l.Warnf("change status: %v", err)
+		// breaker of accrual system is open or accrual system asked to slow down, so order is checked again when
+		// accrual system accepts requests
+		if errors.Is(err, ErrBreakerOpen) || errors.Is(err, ErrTooManyRequests) {
+			l.Debugf("get order from accrual: %v", err)
+			return checkRejected, nil
 		}
-	case model.StatusProcessed:
-		if order.Accrual > 0.0 {
-			if err := s.store.Order().ChangeStatusAndIncrementUserBalance(ctx, o.User, order); err != nil {
-				return
-			}
-			l.Trace("successful changed status to processed and incremented user balance")
-			return
-		} else if err := s.store.Order().ChangeStatus(ctx, o.User, order); err != nil {
-			l.Warnf("change status: %v", err)
-			return
-		}
-		l.Trace("successful changed user status")
+		l.Warnf("get order from accrual: %v", err)
+		errors.As(err, &failure)
+		return checkRetry, failure
+	}
+
+	l.Trace(fmt.Sprintf("got order from accrual Order{Status: %s, Accrual: %f, Number: %s}", order.Status, order.Accrual, order.Number))
+	final, err := s.orders.Apply(ctx, o, order)
+	var te *model.TransitionError
+	switch {
+	case errors.As(err, &te):
+		// order already got status which can't be changed to status from accrual system, so it is not checked anymore
+		l.WithField("current_status", te.From).Warnf("apply order state: %v", err)
+		return checkDone, nil
+	case errors.Is(err, service.ErrUnknownStatus):
+		l.WithField("current_status", o.Status).Warnf("apply order state: %v", err)
+		payload, _ := json.Marshal(order)
+		return checkRetry, &ResponseError{StatusCode: http.StatusOK, Body: payload, Err: err}
+	case err != nil:
+		l.Warnf("apply order state: %v", err)
+		return checkRetry, nil
+	}
+	if final {
+		return checkDone, nil
+	}
+	return checkRetry, nil
+}
 
-	default:
-		l.Warnf("got unknown status: %s", o.Status)
+// rejectedFor returns delay of next check of order which request was rejected: time until circuit breaker lets
+// requests through or pause from Retry-After header is over. If it is unknown, then first backoff delay is used.
+func (s *OrderPoller) rejectedFor(number model.OrderNumber) time.Duration {
+	if d := blockedFor(s.accrual, number); d > 0 {
+		return d
+	}
+	return s.config.PollBackoffBase
+}
+
+// getOrder requests state of order from accrual system. Request, including wait for rate limit, is bounded by half of
+// lease, so order is still leased to poller when result of check is saved.
+func (s *OrderPoller) getOrder(ctx context.Context, number model.OrderNumber) (*model.OrderInAccrual, error) {
+	if s.config.PollLease > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.config.PollLease/2)
+		defer cancel()
+	}
+	return s.accrual.GetOrder(ctx, number)
+}
+
+// recordFailure saves reason of failed check of order and reports whether order must not be postponed because it was
+// dead-lettered or its lease was lost.
+func (s *OrderPoller) recordFailure(ctx context.Context, o *model.OrderInPoll, failure *ResponseError) bool {
+	l := s.logger.WithFields(map[string]interface{}{
+		"user":  o.User,
+		"order": o.Number,
+	})
+
+	dead, err := s.store.Order().RecordFailure(ctx, s.id, o.Number, failure.Error(), string(failure.Body), s.config.PollMaxFailures)
+	if err != nil {
+		s.updateFailed(l, "record failure", err)
+		return errors.Is(err, store.ErrLeaseLost)
+	}
+	if dead {
+		metrics.Add(metricDeadLettered, 1)
+		l.Warnf("order is dead-lettered: %v", failure)
+	}
+	return dead
+}
+
+// updateFailed logs error of update of leased order. Lost lease means that order is checked by other poller, so it is
+// only counted.
+func (s *OrderPoller) updateFailed(l logger.Logger, action string, err error) {
+	if errors.Is(err, store.ErrLeaseLost) {
+		metrics.Add(metricClaimLost, 1)
+		l.Debugf("%s: %v", action, err)
+		return
 	}
+	l.Errorf("%s: %v", action, err)
 }
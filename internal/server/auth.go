@@ -1,8 +1,11 @@
 package server
 
 import (
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/vlad-marlo/gophermart/pkg/encryptor"
 
@@ -29,7 +32,7 @@ func (s *Server) CheckAuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		if ok := s.store.User().ExistsWithID(r.Context(), id); !ok {
+		if ok := s.auth.Exists(r.Context(), id); !ok {
 			s.error(w, fmt.Errorf("auth middleware: exists with id: %v", err), fields, http.StatusInternalServerError)
 			w.WriteHeader(http.StatusUnauthorized)
 			return
@@ -48,3 +51,26 @@ func (s *Server) authenticate(w http.ResponseWriter, id int) {
 	}
 	http.SetCookie(w, c)
 }
+
+// CheckAdminMiddleware lets through requests with bearer token equal to admin token; admin endpoints are not available
+// if admin token is not configured.
+func (s *Server) CheckAdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fields := map[string]interface{}{
+			"request_id": middleware.GetReqID(r.Context()),
+			"middleware": "check admin middleware",
+		}
+
+		if s.config.AdminToken == "" {
+			s.error(w, errors.New("admin endpoints are disabled"), fields, http.StatusNotFound)
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) != 1 {
+			s.error(w, errors.New("bad admin token"), fields, http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -4,13 +4,25 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/internal/service"
 	"github.com/vlad-marlo/gophermart/internal/store"
-	"github.com/vlad-marlo/gophermart/pkg/luhn"
 	"io"
 	"net/http"
-	"strconv"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// SignatureHeader contains hex encoded HMAC-SHA256 of request body signed with shared secret of accrual system
+	SignatureHeader = "X-Signature"
+	// maxCallbackSize is max size of body of accrual system callback
+	maxCallbackSize = 1 << 20
+	// maxOrdersBatchSize is max count of numbers in bulk order upload
+	maxOrdersBatchSize = 1000
+	// maxOrdersBatchBodySize is max size of body of bulk order upload
+	maxOrdersBatchBodySize = 1 << 20
 )
 
 // handleAuthRegister ...
@@ -39,17 +51,16 @@ func (s *Server) handleAuthRegister() http.HandlerFunc {
 			s.error(w, fmt.Errorf("json unmarshal: %w", err), fields, http.StatusBadRequest)
 			return
 		}
-		if !u.Valid() {
-			s.error(w, err, fields, http.StatusBadRequest)
-			return
-		}
-
-		if err := s.store.User().Create(r.Context(), u); err != nil {
-			if errors.Is(err, store.ErrLoginAlreadyInUse) {
-				s.error(w, fmt.Errorf("auth register: create user: %w", err), fields, http.StatusConflict)
-				return
+		if err := s.auth.Register(r.Context(), u); err != nil {
+			err = fmt.Errorf("auth register: %w", err)
+			switch {
+			case errors.Is(err, service.ErrInvalidArgument):
+				s.error(w, err, fields, http.StatusBadRequest)
+			case errors.Is(err, store.ErrLoginAlreadyInUse):
+				s.error(w, err, fields, http.StatusConflict)
+			default:
+				s.error(w, err, fields, http.StatusInternalServerError)
 			}
-			s.error(w, fmt.Errorf("auth register: create user: %w", err), fields, http.StatusInternalServerError)
 			return
 		}
 
@@ -86,23 +97,22 @@ func (s *Server) handleAuthLogin() http.HandlerFunc {
 			return
 		}
 
-		if req.Login == "" || req.Password == "" {
+		if req == nil {
 			s.error(w, errors.New("bad request"), fields, http.StatusBadRequest)
 			return
 		}
 
-		user, err := s.store.User().GetByLogin(r.Context(), req.Login)
+		user, err := s.auth.Login(r.Context(), req.Login, req.Password)
 		if err != nil {
-			if errors.Is(err, store.ErrIncorrectLoginData) {
-				s.error(w, fmt.Errorf("login: unauthorized: %w", err), fields, http.StatusUnauthorized)
-				return
+			err = fmt.Errorf("login: %w", err)
+			switch {
+			case errors.Is(err, service.ErrInvalidArgument):
+				s.error(w, err, fields, http.StatusBadRequest)
+			case errors.Is(err, service.ErrUnauthorized):
+				s.error(w, err, fields, http.StatusUnauthorized)
+			default:
+				s.error(w, err, fields, http.StatusInternalServerError)
 			}
-			s.error(w, fmt.Errorf("get user by login: %w", err), fields, http.StatusInternalServerError)
-
-			return
-		}
-		if err := user.ComparePassword(req.Password); err != nil {
-			s.error(w, fmt.Errorf("login: compare pass: unauthorized: %w", err), fields, http.StatusUnauthorized)
 			return
 		}
 
@@ -139,20 +149,16 @@ func (s *Server) handleOrdersPost() http.HandlerFunc {
 			return
 		}
 
-		var num int
-		num, err = strconv.Atoi(string(data))
+		num, err := model.ParseOrderNumber(string(data))
 		if err != nil {
 			s.error(w, err, fields, http.StatusBadRequest)
 			return
 		}
 
-		if !luhn.Valid(num) {
-			s.error(w, fmt.Errorf("bad number: was not pass luhn test"), fields, http.StatusUnprocessableEntity)
-			return
-		}
-
-		if err := s.store.Order().Register(r.Context(), u, num); err != nil {
+		if err := s.orders.Register(r.Context(), u, num); err != nil {
 			switch {
+			case errors.Is(err, service.ErrBadOrderNumber):
+				s.error(w, err, fields, http.StatusUnprocessableEntity)
 			case errors.Is(err, store.ErrAlreadyRegisteredByAnotherUser):
 				s.error(w, err, fields, http.StatusConflict)
 			case errors.Is(err, store.ErrAlreadyRegisteredByUser):
@@ -166,6 +172,76 @@ func (s *Server) handleOrdersPost() http.HandlerFunc {
 	}
 }
 
+// handleOrdersBatchPost ...
+func (s *Server) handleOrdersBatchPost() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		fields := map[string]interface{}{
+			"request_id": middleware.GetReqID(ctx),
+			"handler":    "orders batch post",
+		}
+		l := s.logger.WithFields(fields)
+
+		u, err := GetUserIDFromRequest(r)
+		if err != nil {
+			s.error(w, err, fields, http.StatusUnauthorized)
+			return
+		}
+
+		defer func() {
+			if err := r.Body.Close(); err != nil {
+				l.Warn(fmt.Sprintf("close body: %v", err))
+			}
+		}()
+		r.Body = http.MaxBytesReader(w, r.Body, maxOrdersBatchBodySize)
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			// body is larger than limit or connection was broken while it was read
+			s.error(w, fmt.Errorf("read body: %w", err), fields, http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		numbers, err := parseOrderNumbers(data)
+		if err != nil {
+			s.error(w, fmt.Errorf("parse order numbers: %w", err), fields, http.StatusBadRequest)
+			return
+		}
+		if len(numbers) > maxOrdersBatchSize {
+			s.error(w, fmt.Errorf("too many numbers in batch: %d", len(numbers)), fields, http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		res, err := s.orders.RegisterBatch(ctx, u, numbers)
+		if err != nil {
+			s.error(w, fmt.Errorf("orders: %w", err), fields, http.StatusInternalServerError)
+			return
+		}
+		accepted := false
+		for _, reg := range res {
+			if reg.Result == model.RegistrationAccepted {
+				accepted = true
+				break
+			}
+		}
+
+		data, err = json.Marshal(res)
+		if err != nil {
+			s.error(w, fmt.Errorf("json marshal: %w", err), fields, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if accepted {
+			w.WriteHeader(http.StatusAccepted)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+		if _, err := w.Write(data); err != nil {
+			l.Errorf("write response: %v", err)
+		}
+	}
+}
+
 // handleOrdersGet ...
 func (s *Server) handleOrdersGet() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -188,7 +264,7 @@ func (s *Server) handleOrdersGet() http.HandlerFunc {
 			return
 		}
 
-		orders, err = s.store.Order().GetAllByUser(r.Context(), u)
+		orders, err = s.orders.GetAll(r.Context(), u)
 		if err != nil {
 			switch {
 			case errors.Is(err, store.ErrNoContent):
@@ -213,7 +289,96 @@ func (s *Server) handleOrdersGet() http.HandlerFunc {
 	}
 }
 
-// handleBalanceGet ...
+// handleOrderGet ...
+func (s *Server) handleOrderGet() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		fields := map[string]interface{}{
+			"request_id": middleware.GetReqID(ctx),
+			"handler":    "get user order",
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		u, err := GetUserIDFromRequest(r)
+		if err != nil {
+			s.error(w, err, fields, http.StatusUnauthorized)
+			return
+		}
+
+		num, err := model.ParseOrderNumber(chi.URLParam(r, "number"))
+		if err != nil {
+			s.error(w, err, fields, http.StatusBadRequest)
+			return
+		}
+
+		order, err := s.orders.Get(ctx, u, num)
+		if err != nil {
+			err = fmt.Errorf("orders: %w", err)
+
+			if errors.Is(err, store.ErrNoContent) {
+				s.error(w, err, fields, http.StatusNotFound)
+				return
+			}
+
+			s.error(w, err, fields, http.StatusInternalServerError)
+			return
+		}
+
+		data, err := json.Marshal(order)
+		if err != nil {
+			s.error(w, fmt.Errorf("json marshal: %w", err), fields, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(data); err != nil {
+			s.error(w, fmt.Errorf("write response: %w", err), fields, http.StatusInternalServerError)
+		}
+	}
+}
+
+// handleOrderDelete ...
+func (s *Server) handleOrderDelete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		fields := map[string]interface{}{
+			"request_id": middleware.GetReqID(ctx),
+			"handler":    "delete user order",
+		}
+
+		u, err := GetUserIDFromRequest(r)
+		if err != nil {
+			s.error(w, err, fields, http.StatusUnauthorized)
+			return
+		}
+
+		num, err := model.ParseOrderNumber(chi.URLParam(r, "number"))
+		if err != nil {
+			s.error(w, err, fields, http.StatusBadRequest)
+			return
+		}
+
+		if err := s.orders.Cancel(ctx, u, num); err != nil {
+			err = fmt.Errorf("orders: %w", err)
+			switch {
+			case errors.Is(err, store.ErrNoContent):
+				s.error(w, err, fields, http.StatusNotFound)
+			case errors.Is(err, store.ErrNotCancellable):
+				s.error(w, err, fields, http.StatusConflict)
+			default:
+				s.error(w, err, fields, http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// handleBalanceGet returns current balance of user and sum of withdrawals. Transfers between users are separate ledger
+// served only by handleGetAllTransfers: they change current balance but are not counted in withdrawn, so current plus
+// withdrawn equals sum of accruals only for user without transfers.
 func (s *Server) handleBalanceGet() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -230,7 +395,7 @@ func (s *Server) handleBalanceGet() http.HandlerFunc {
 			return
 		}
 
-		b, err := s.store.User().GetBalance(r.Context(), id)
+		b, err := s.balance.Get(r.Context(), id)
 		if err != nil {
 			s.error(w, err, fields, http.StatusInternalServerError)
 			return
@@ -266,9 +431,9 @@ func (s *Server) handleGetAllWithdraws() http.HandlerFunc {
 			return
 		}
 
-		withdrawals, err := s.store.Withdraws().GetAllByUser(ctx, id)
+		withdrawals, err := s.balance.Withdrawals(ctx, id)
 		if err != nil {
-			err = fmt.Errorf("withdraws: get all by user: %w", err)
+			err = fmt.Errorf("balance: %w", err)
 
 			if errors.Is(err, store.ErrNoContent) {
 				s.error(w, err, fields, http.StatusNoContent)
@@ -295,8 +460,8 @@ func (s *Server) handleGetAllWithdraws() http.HandlerFunc {
 // handleWithdrawsPost ...
 func (s *Server) handleWithdrawsPost() http.HandlerFunc {
 	type request struct {
-		Order int     `json:"order,string"`
-		Sum   float64 `json:"sum"`
+		Order model.OrderNumber `json:"order"`
+		Sum   float64           `json:"sum"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -335,11 +500,13 @@ func (s *Server) handleWithdrawsPost() http.HandlerFunc {
 			Sum:   req.Sum,
 		}
 
-		if err := s.store.Withdraws().Withdraw(ctx, u, withdraw); err != nil {
-			err = fmt.Errorf("withdraw: %w", err)
+		if err := s.balance.Withdraw(ctx, u, withdraw); err != nil {
+			err = fmt.Errorf("balance: %w", err)
 			switch {
-			case errors.Is(err, store.ErrIncorrectData):
+			case errors.Is(err, service.ErrBadOrderNumber), errors.Is(err, store.ErrIncorrectData):
 				s.error(w, err, fields, http.StatusUnprocessableEntity)
+			case errors.Is(err, service.ErrInvalidArgument):
+				s.error(w, err, fields, http.StatusBadRequest)
 			case errors.Is(err, store.ErrPaymentRequired):
 				s.error(w, err, fields, http.StatusPaymentRequired)
 			default:
@@ -352,3 +519,294 @@ func (s *Server) handleWithdrawsPost() http.HandlerFunc {
 		w.WriteHeader(http.StatusOK)
 	}
 }
+
+// handleTransferPost moves points to user with login. Transfer is not withdrawal: it is not counted in withdrawn and is
+// not listed in withdrawals, it is listed only by handleGetAllTransfers.
+func (s *Server) handleTransferPost() http.HandlerFunc {
+	type request struct {
+		Login string  `json:"login"`
+		Sum   float64 `json:"sum"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		fields := map[string]interface{}{
+			"request_id": middleware.GetReqID(ctx),
+			"handler":    "transfer",
+		}
+		l := s.logger.WithFields(fields)
+
+		u, err := GetUserIDFromRequest(r)
+		if err != nil {
+			s.error(w, err, fields, http.StatusUnauthorized)
+			return
+		}
+
+		defer func() {
+			if err := r.Body.Close(); err != nil {
+				l.Errorf(fmt.Sprintf("request body close: %v", err))
+			}
+		}()
+
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			s.error(w, err, fields, http.StatusInternalServerError)
+			return
+		}
+
+		var req *request
+		if err := json.Unmarshal(data, &req); err != nil {
+			s.error(w, err, fields, http.StatusBadRequest)
+			return
+		}
+		if req == nil {
+			s.error(w, errors.New("bad request"), fields, http.StatusBadRequest)
+			return
+		}
+
+		t := &model.Transfer{
+			Login:          req.Login,
+			Sum:            req.Sum,
+			IdempotencyKey: r.Header.Get(IdempotencyKeyHeader),
+		}
+
+		if err := s.balance.Transfer(ctx, u, t); err != nil {
+			err = fmt.Errorf("balance: %w", err)
+			switch {
+			case errors.Is(err, service.ErrInvalidArgument):
+				s.error(w, err, fields, http.StatusBadRequest)
+			case errors.Is(err, store.ErrIncorrectData):
+				s.error(w, err, fields, http.StatusUnprocessableEntity)
+			case errors.Is(err, store.ErrRecipientNotFound):
+				s.error(w, err, fields, http.StatusNotFound)
+			case errors.Is(err, store.ErrPaymentRequired):
+				s.error(w, err, fields, http.StatusPaymentRequired)
+			case errors.Is(err, store.ErrDailyLimitExceeded):
+				s.error(w, err, fields, http.StatusForbidden)
+			case errors.Is(err, store.ErrIdempotencyKeyReused):
+				s.error(w, err, fields, http.StatusUnprocessableEntity)
+			default:
+				s.error(w, err, fields, http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// handleGetAllTransfers returns sent and received transfers of user; it is the only history of transfers.
+func (s *Server) handleGetAllTransfers() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		fields := map[string]interface{}{
+			"request_id": middleware.GetReqID(ctx),
+			"handler":    "get all transfers",
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		id, err := GetUserIDFromRequest(r)
+		if err != nil {
+			s.error(w, err, fields, http.StatusUnauthorized)
+			return
+		}
+
+		transfers, err := s.balance.Transfers(ctx, id)
+		if err != nil {
+			err = fmt.Errorf("balance: %w", err)
+
+			if errors.Is(err, store.ErrNoContent) {
+				s.error(w, err, fields, http.StatusNoContent)
+				return
+			}
+
+			s.error(w, err, fields, http.StatusInternalServerError)
+			return
+		}
+
+		data, err := json.Marshal(transfers)
+		if err != nil {
+			s.error(w, err, fields, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(data); err != nil {
+			s.error(w, err, fields, http.StatusInternalServerError)
+		}
+	}
+}
+
+// handleAccrualCallback applies order state pushed by accrual system. Callback is available only if shared secret
+// is configured.
+func (s *Server) handleAccrualCallback() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		fields := map[string]interface{}{
+			"request_id": middleware.GetReqID(ctx),
+			"handler":    "accrual callback",
+		}
+
+		if s.config.AccrualWebhookSecret == "" || s.updater == nil {
+			s.error(w, errors.New("accrual callback is disabled"), fields, http.StatusNotFound)
+			return
+		}
+
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxCallbackSize))
+		if err != nil {
+			s.error(w, fmt.Errorf("read body: %w", err), fields, http.StatusBadRequest)
+			return
+		}
+		defer func() {
+			if err := r.Body.Close(); err != nil {
+				s.logger.WithFields(fields).Errorf("close body: %v", err)
+			}
+		}()
+
+		if !validSignature([]byte(s.config.AccrualWebhookSecret), data, r.Header.Get(SignatureHeader)) {
+			s.error(w, errors.New("bad signature"), fields, http.StatusUnauthorized)
+			return
+		}
+
+		order := new(model.OrderInAccrual)
+		if err := json.Unmarshal(data, order); err != nil {
+			s.error(w, fmt.Errorf("json unmarshal: %w", err), fields, http.StatusBadRequest)
+			return
+		}
+		if _, err := model.ParseOrderNumber(order.Number.String()); err != nil {
+			s.error(w, err, fields, http.StatusBadRequest)
+			return
+		}
+		fields["order"] = order.Number
+
+		if err := s.updater.Update(ctx, order); err != nil {
+			err = fmt.Errorf("accrual: update: %w", err)
+			switch {
+			case errors.Is(err, store.ErrNoContent):
+				s.error(w, err, fields, http.StatusNotFound)
+			case errors.Is(err, service.ErrUnknownStatus):
+				s.error(w, err, fields, http.StatusUnprocessableEntity)
+			case errors.As(err, new(*model.TransitionError)):
+				s.error(w, err, fields, http.StatusConflict)
+			default:
+				s.error(w, err, fields, http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// handleDeadLetterGet returns orders which state could not be resolved from answers of accrual system.
+func (s *Server) handleDeadLetterGet() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		fields := map[string]interface{}{
+			"request_id": middleware.GetReqID(ctx),
+			"handler":    "get dead-lettered orders",
+		}
+
+		orders, err := s.orders.DeadLettered(ctx)
+		if err != nil {
+			if errors.Is(err, store.ErrNoContent) {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			s.error(w, fmt.Errorf("orders: %w", err), fields, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(orders); err != nil {
+			s.logger.WithFields(fields).Errorf("json encode: %v", err)
+		}
+	}
+}
+
+// handleOrderRetry returns dead-lettered order to polling.
+func (s *Server) handleOrderRetry() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		fields := map[string]interface{}{
+			"request_id": middleware.GetReqID(ctx),
+			"handler":    "retry dead-lettered order",
+		}
+
+		num, err := model.ParseOrderNumber(chi.URLParam(r, "number"))
+		if err != nil {
+			s.error(w, err, fields, http.StatusBadRequest)
+			return
+		}
+
+		if err := s.orders.Retry(ctx, num); err != nil {
+			err = fmt.Errorf("orders: %w", err)
+			if errors.Is(err, store.ErrNoContent) {
+				s.error(w, err, fields, http.StatusNotFound)
+				return
+			}
+			s.error(w, err, fields, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// handleOrderResolve sets final status of order by admin decision; user is credited if order is processed.
+func (s *Server) handleOrderResolve() http.HandlerFunc {
+	type request struct {
+		Status  string  `json:"status"`
+		Accrual float64 `json:"accrual"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		fields := map[string]interface{}{
+			"request_id": middleware.GetReqID(ctx),
+			"handler":    "resolve order",
+		}
+
+		if s.updater == nil {
+			s.error(w, errors.New("accrual updater is not set"), fields, http.StatusInternalServerError)
+			return
+		}
+
+		num, err := model.ParseOrderNumber(chi.URLParam(r, "number"))
+		if err != nil {
+			s.error(w, err, fields, http.StatusBadRequest)
+			return
+		}
+
+		req := new(request)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, fmt.Errorf("json decode: %w", err), fields, http.StatusBadRequest)
+			return
+		}
+		if req.Status != model.StatusProcessed && req.Status != model.StatusInvalid || req.Accrual < 0 {
+			s.error(w, fmt.Errorf("bad final status %q", req.Status), fields, http.StatusBadRequest)
+			return
+		}
+
+		order := &model.OrderInAccrual{
+			Number:  num,
+			Status:  req.Status,
+			Accrual: req.Accrual,
+		}
+		if err := s.updater.Update(ctx, order); err != nil {
+			err = fmt.Errorf("accrual: update: %w", err)
+			switch {
+			case errors.Is(err, store.ErrNoContent):
+				s.error(w, err, fields, http.StatusNotFound)
+			case errors.As(err, new(*model.TransitionError)):
+				s.error(w, err, fields, http.StatusConflict)
+			default:
+				s.error(w, err, fields, http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -1,31 +1,33 @@
 package server_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/go-resty/resty/v2"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/vlad-marlo/gophermart/internal/config"
 	"github.com/vlad-marlo/gophermart/internal/model"
 	"github.com/vlad-marlo/gophermart/internal/server"
+	"github.com/vlad-marlo/gophermart/internal/service"
 	"github.com/vlad-marlo/gophermart/internal/store"
-	"github.com/vlad-marlo/gophermart/internal/store/sqlstore"
+	"github.com/vlad-marlo/gophermart/internal/store/memstore"
 	"github.com/vlad-marlo/gophermart/pkg/logger"
-	"github.com/vlad-marlo/gophermart/pkg/luhn"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestAuthUserRegister_MainCases(t *testing.T) {
-	if conStr == "" {
-		t.Skip("connect string is not provided")
-	}
-
 	type (
 		request struct {
 			Login    string `json:"login,omitempty"`
@@ -39,8 +41,7 @@ func TestAuthUserRegister_MainCases(t *testing.T) {
 		}
 	)
 
-	storage, teardown := sqlstore.TestStore(t, conStr)
-	defer teardown(userTableName, ordersTableName, withdrawalsTableName)
+	storage := memstore.New()
 
 	cfg := config.TestConfig(t)
 
@@ -138,9 +139,6 @@ func TestAuthUserRegister_MainCases(t *testing.T) {
 }
 
 func TestAuthUserRegister_CheckAuth(t *testing.T) {
-	if conStr == "" {
-		t.Skip("connect string is not provided")
-	}
 	type request struct {
 		Login    string `json:"login,omitempty"`
 		Password string `json:"password,omitempty"`
@@ -161,8 +159,7 @@ func TestAuthUserRegister_CheckAuth(t *testing.T) {
 		},
 	}
 
-	storage, teardown := sqlstore.TestStore(t, conStr)
-	defer teardown(userTableName)
+	storage := memstore.New()
 
 	cfg := config.TestConfig(t)
 	s := server.New(l, storage, cfg)
@@ -188,10 +185,6 @@ func TestAuthUserRegister_CheckAuth(t *testing.T) {
 }
 
 func TestAuthUserLogin(t *testing.T) {
-	if conStr == "" {
-		t.Skip("connect string is not provided")
-	}
-
 	ctx := context.Background()
 
 	type (
@@ -267,8 +260,7 @@ func TestAuthUserLogin(t *testing.T) {
 		},
 	}
 
-	storage, teardown := sqlstore.TestStore(t, conStr)
-	defer teardown(userTableName)
+	storage := memstore.New()
 
 	err := storage.User().Create(ctx, u)
 	require.NoErrorf(t, err, "create user: %v", err)
@@ -298,14 +290,9 @@ func TestAuthUserLogin(t *testing.T) {
 }
 
 func TestOrdersPost(t *testing.T) {
-	if conStr == "" {
-		t.Skip("connect string is not provided")
-	}
-
 	cfg := config.TestConfig(t)
 
-	storage, teardown := sqlstore.TestStore(t, conStr)
-	defer teardown(userTableName, ordersTableName)
+	storage := memstore.New()
 
 	log := logrus.New()
 	log.Out = io.Discard
@@ -322,43 +309,43 @@ func TestOrdersPost(t *testing.T) {
 
 	tests := []struct {
 		name    string
-		request int
+		request model.OrderNumber
 		code    int
 		cookies []*http.Cookie
 	}{
 		{
 			name:    "positive case #1",
-			request: 12345678903,
+			request: "12345678903",
 			code:    http.StatusAccepted,
 			cookies: cookiesUser1,
 		},
 		{
 			name:    "negative conflict #1",
-			request: 12345678903,
+			request: "12345678903",
 			code:    http.StatusConflict,
 			cookies: cookiesUser2,
 		},
 		{
 			name:    "positive case #2",
-			request: 12345678903,
+			request: "12345678903",
 			code:    http.StatusOK,
 			cookies: cookiesUser1,
 		},
 		{
 			name:    "positive case #3",
-			request: 1234562,
+			request: "1234562",
 			code:    0,
 			cookies: cookiesUser1,
 		},
 		{
 			name:    "positive case #4",
-			request: 12345123,
+			request: "12345123",
 			code:    0,
 			cookies: cookiesUser1,
 		},
 		{
 			name:    "positive case #5",
-			request: 12345675,
+			request: "12345675",
 			code:    0,
 			cookies: cookiesUser1,
 		},
@@ -369,7 +356,7 @@ func TestOrdersPost(t *testing.T) {
 			resp, _ := testRequest(t, ts, http.MethodPost, userOrdersPath, []byte(fmt.Sprint(tt.request)), tt.cookies)
 			if tt.code != 0 {
 				require.Equal(t, tt.code, resp.StatusCode(), fmt.Sprintf("got unexpected status code want=%d got=%d", tt.code, resp.StatusCode()))
-			} else if luhn.Valid(tt.request) {
+			} else if tt.request.Valid() {
 				require.NotEqual(t, http.StatusUnprocessableEntity, resp.StatusCode(), fmt.Sprintf("got unexpected status code: %d", resp.StatusCode()))
 			}
 		})
@@ -377,15 +364,10 @@ func TestOrdersPost(t *testing.T) {
 }
 
 func TestOrdersGet(t *testing.T) {
-	if conStr == "" {
-		t.Skip("connect string is not provided")
-	}
-
 	cfg := config.TestConfig(t)
 	ctx := context.Background()
 
-	storage, teardown := sqlstore.TestStore(t, conStr)
-	defer teardown(userTableName, ordersTableName)
+	storage := memstore.New()
 
 	log := logrus.New()
 	log.Out = io.Discard
@@ -413,25 +395,37 @@ func TestOrdersGet(t *testing.T) {
 
 	tests := []struct {
 		name   string
-		o      int
+		o      model.OrderNumber
 		u      *model.User
 		otherU *model.User
 	}{
 		{
 			name:   "positive case #1",
-			o:      12345678903,
+			o:      "12345678903",
 			u:      u1,
 			otherU: u2,
 		},
 		{
 			name:   "positive case #2",
-			o:      79927398713,
+			o:      "79927398713",
 			u:      u1,
 			otherU: u2,
 		},
 		{
 			name:   "positive case #2",
-			o:      4532733309529845,
+			o:      "4532733309529845",
+			u:      u1,
+			otherU: u2,
+		},
+		{
+			name:   "positive case #3 - leading zeros",
+			o:      "0049927398716",
+			u:      u1,
+			otherU: u2,
+		},
+		{
+			name:   "positive case #4 - long number",
+			o:      "49299728846762894929972884676289",
 			u:      u1,
 			otherU: u2,
 		},
@@ -539,14 +533,10 @@ func TestOrdersGet(t *testing.T) {
 }
 
 func TestWithdrawsPost(t *testing.T) {
-	if conStr == "" {
-		t.Skip("connect string is not provided")
-	}
 	cfg := config.TestConfig(t)
 	ctx := context.Background()
 
-	storage, teardown := sqlstore.TestStore(t, conStr)
-	defer teardown(userTableName, ordersTableName)
+	storage := memstore.New()
 
 	log := logrus.New()
 	log.Out = io.Discard
@@ -569,8 +559,8 @@ func TestWithdrawsPost(t *testing.T) {
 		status int
 	}
 	type args struct {
-		Order int     `json:"order,string"`
-		Sum   float64 `json:"sum"`
+		Order model.OrderNumber `json:"order"`
+		Sum   float64           `json:"sum"`
 	}
 
 	tests := []struct {
@@ -657,10 +647,6 @@ func TestWithdrawsPost(t *testing.T) {
 }
 
 func TestAuth(t *testing.T) {
-	if conStr == "" {
-		t.Skip("connect string was not provided")
-	}
-
 	tests := []struct {
 		name       string
 		path       string
@@ -706,8 +692,7 @@ func TestAuth(t *testing.T) {
 	}
 	cfg := config.TestConfig(t)
 
-	storage, teardown := sqlstore.TestStore(t, conStr)
-	defer teardown(userTableName)
+	storage := memstore.New()
 
 	log := logrus.New()
 	log.Out = io.Discard
@@ -725,3 +710,493 @@ func TestAuth(t *testing.T) {
 		})
 	}
 }
+
+func TestTransferPost(t *testing.T) {
+	cfg := config.TestConfig(t)
+	ctx := context.Background()
+
+	storage := memstore.New()
+
+	log := logrus.New()
+	log.Out = io.Discard
+
+	s := server.New(logger.GetLoggerByEntry(logrus.NewEntry(log)), storage, cfg)
+	ts := httptest.NewServer(s.Router)
+	defer ts.Close()
+
+	u1 := &model.User{Login: userLogin1, Password: userPassword}
+	cookiesU1 := getUserCookies(t, ts, u1)
+	u1, err := storage.User().GetByLogin(ctx, u1.Login)
+	require.NoError(t, err, fmt.Sprintf("get user by login: %v", err))
+
+	u2 := &model.User{Login: userLogin2, Password: userPassword}
+	cookiesU2 := getUserCookies(t, ts, u2)
+
+	err = storage.User().IncrementBalance(ctx, u1.ID, 100)
+	require.NoError(t, err, "increment balance")
+
+	tests := []struct {
+		name string
+		body string
+		key  string
+		code int
+	}{
+		{
+			name: "positive case #1",
+			body: fmt.Sprintf(`{"login": "%s", "sum": 10}`, userLogin2),
+			key:  "key",
+			code: http.StatusOK,
+		},
+		{
+			name: "positive case #2 - repeated",
+			body: fmt.Sprintf(`{"login": "%s", "sum": 10}`, userLogin2),
+			key:  "key",
+			code: http.StatusOK,
+		},
+		{
+			name: "negative case #0 - repeated key with other sum",
+			body: fmt.Sprintf(`{"login": "%s", "sum": 20}`, userLogin2),
+			key:  "key",
+			code: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "negative case #1 - payment required",
+			body: fmt.Sprintf(`{"login": "%s", "sum": 1000}`, userLogin2),
+			code: http.StatusPaymentRequired,
+		},
+		{
+			name: "negative case #2 - unknown recipient",
+			body: `{"login": "unknown", "sum": 10}`,
+			code: http.StatusNotFound,
+		},
+		{
+			name: "negative case #3 - bad request",
+			body: `{"login": "unknown"}`,
+			code: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := resty.New().R().
+				SetCookies(cookiesU1).
+				SetHeader(server.IdempotencyKeyHeader, tt.key).
+				SetBody(tt.body).
+				Post(ts.URL + userTransferPath)
+			require.NoError(t, err)
+			require.Equal(t, tt.code, resp.StatusCode())
+		})
+	}
+
+	bal, err := storage.User().GetBalance(ctx, u1.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 90.0, bal.Current)
+	// transfers are separate ledger, so they are not counted as withdrawals
+	assert.Zero(t, bal.Withdrawn)
+	resp, _ := testRequest(t, ts, http.MethodGet, userWithdrawalsPath, nil, cookiesU1)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode())
+
+	for _, cookies := range [][]*http.Cookie{cookiesU1, cookiesU2} {
+		resp, body := testRequest(t, ts, http.MethodGet, userTransfersPath, nil, cookies)
+		require.Equal(t, http.StatusOK, resp.StatusCode())
+
+		var transfers []*model.Transfer
+		require.NoError(t, json.Unmarshal(body, &transfers))
+		require.Len(t, transfers, 1)
+		assert.Equal(t, 10.0, transfers[0].Sum)
+	}
+}
+
+func TestOrderGet(t *testing.T) {
+	cfg := config.TestConfig(t)
+
+	storage := memstore.New()
+
+	log := logrus.New()
+	log.Out = io.Discard
+
+	s := server.New(logger.GetLoggerByEntry(logrus.NewEntry(log)), storage, cfg)
+	ts := httptest.NewServer(s.Router)
+	defer ts.Close()
+
+	cookiesU1 := getUserCookies(t, ts, &model.User{Login: userLogin1, Password: userPassword})
+	cookiesU2 := getUserCookies(t, ts, &model.User{Login: userLogin2, Password: userPassword})
+
+	resp, _ := testRequest(t, ts, http.MethodPost, userOrdersPath, []byte(fmt.Sprint(validOrderNum1)), cookiesU1)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode())
+
+	tests := []struct {
+		name    string
+		path    string
+		cookies []*http.Cookie
+		code    int
+	}{
+		{
+			name:    "positive case #1",
+			path:    fmt.Sprintf("%s/%s", userOrdersPath, validOrderNum1),
+			cookies: cookiesU1,
+			code:    http.StatusOK,
+		},
+		{
+			name:    "negative case #1 - order of another user",
+			path:    fmt.Sprintf("%s/%s", userOrdersPath, validOrderNum1),
+			cookies: cookiesU2,
+			code:    http.StatusNotFound,
+		},
+		{
+			name:    "negative case #2 - unknown order",
+			path:    fmt.Sprintf("%s/%s", userOrdersPath, validOrderNum2),
+			cookies: cookiesU1,
+			code:    http.StatusNotFound,
+		},
+		{
+			name:    "negative case #3 - bad number",
+			path:    userOrdersPath + "/abc",
+			cookies: cookiesU1,
+			code:    http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := testRequest(t, ts, http.MethodGet, tt.path, nil, tt.cookies)
+			require.Equal(t, tt.code, resp.StatusCode())
+			if tt.code != http.StatusOK {
+				return
+			}
+
+			var o *model.Order
+			require.NoError(t, json.Unmarshal(body, &o))
+			assert.Equal(t, validOrderNum1, o.Number)
+			assert.Equal(t, model.StatusNew, o.Status)
+			require.Len(t, o.History, 1)
+			assert.Equal(t, model.StatusNew, o.History[0].Status)
+		})
+	}
+}
+
+func TestOrdersBatchPost(t *testing.T) {
+	cfg := config.TestConfig(t)
+
+	storage := memstore.New()
+
+	log := logrus.New()
+	log.Out = io.Discard
+
+	s := server.New(logger.GetLoggerByEntry(logrus.NewEntry(log)), storage, cfg)
+	ts := httptest.NewServer(s.Router)
+	defer ts.Close()
+
+	cookiesU1 := getUserCookies(t, ts, &model.User{Login: userLogin1, Password: userPassword})
+	cookiesU2 := getUserCookies(t, ts, &model.User{Login: userLogin2, Password: userPassword})
+
+	resp, _ := testRequest(t, ts, http.MethodPost, userOrdersPath, []byte(fmt.Sprint(validOrderNum2)), cookiesU2)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode())
+
+	body := fmt.Sprintf("%s\n%s\n%s", validOrderNum1, validOrderNum2, "12345")
+	resp, data := testRequest(t, ts, http.MethodPost, userOrdersBatchPath, []byte(body), cookiesU1)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode())
+
+	var res []*model.OrderRegistration
+	require.NoError(t, json.Unmarshal(data, &res))
+	assert.Equal(t, []*model.OrderRegistration{
+		{Number: validOrderNum1, Result: model.RegistrationAccepted},
+		{Number: validOrderNum2, Result: model.RegistrationDuplicateOther},
+		{Number: "12345", Result: model.RegistrationInvalid},
+	}, res)
+
+	body = fmt.Sprintf(`["%s"]`, validOrderNum1)
+	resp, data = testRequest(t, ts, http.MethodPost, userOrdersBatchPath, []byte(body), cookiesU1)
+	require.Equal(t, http.StatusOK, resp.StatusCode())
+	require.NoError(t, json.Unmarshal(data, &res))
+	assert.Equal(t, []*model.OrderRegistration{
+		{Number: validOrderNum1, Result: model.RegistrationDuplicateOwn},
+	}, res)
+
+	// entry which is not a number doesn't fail the whole batch
+	body = fmt.Sprintf(`["abc", %s]`, validOrderNum1)
+	resp, data = testRequest(t, ts, http.MethodPost, userOrdersBatchPath, []byte(body), cookiesU1)
+	require.Equal(t, http.StatusOK, resp.StatusCode())
+	// invalid entry is echoed as it was sent, so it is not decoded as order number
+	assert.JSONEq(t, fmt.Sprintf(
+		`[{"number":"abc","result":%q},{"number":%q,"result":%q}]`,
+		model.RegistrationInvalid, validOrderNum1, model.RegistrationDuplicateOwn,
+	), string(data))
+
+	resp, _ = testRequest(t, ts, http.MethodPost, userOrdersBatchPath, []byte(`["abc"`), cookiesU1)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode())
+
+	big := bytes.Repeat([]byte(fmt.Sprintf("%s\n", validOrderNum1)), 1<<20/len(validOrderNum1))
+	resp, _ = testRequest(t, ts, http.MethodPost, userOrdersBatchPath, big, cookiesU1)
+	require.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode())
+}
+
+func TestOrderDelete(t *testing.T) {
+	cfg := config.TestConfig(t)
+
+	storage := memstore.New()
+
+	log := logrus.New()
+	log.Out = io.Discard
+
+	s := server.New(logger.GetLoggerByEntry(logrus.NewEntry(log)), storage, cfg)
+	ts := httptest.NewServer(s.Router)
+	defer ts.Close()
+
+	cookiesU1 := getUserCookies(t, ts, &model.User{Login: userLogin1, Password: userPassword})
+	cookiesU2 := getUserCookies(t, ts, &model.User{Login: userLogin2, Password: userPassword})
+
+	resp, _ := testRequest(t, ts, http.MethodPost, userOrdersPath, []byte(fmt.Sprint(validOrderNum1)), cookiesU1)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode())
+
+	path := fmt.Sprintf("%s/%s", userOrdersPath, validOrderNum1)
+
+	resp, _ = testRequest(t, ts, http.MethodDelete, path, nil, cookiesU2)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode())
+
+	resp, _ = testRequest(t, ts, http.MethodDelete, path, nil, cookiesU1)
+	require.Equal(t, http.StatusOK, resp.StatusCode())
+
+	resp, _ = testRequest(t, ts, http.MethodGet, path, nil, cookiesU1)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode())
+
+	// cancelled number could be registered by another user
+	resp, _ = testRequest(t, ts, http.MethodPost, userOrdersPath, []byte(fmt.Sprint(validOrderNum1)), cookiesU2)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode())
+}
+
+type testNotifier struct {
+	orders []*model.OrderInPoll
+}
+
+func (n *testNotifier) Notify(o *model.OrderInPoll) {
+	n.orders = append(n.orders, o)
+}
+
+func TestOrdersPost_Notify(t *testing.T) {
+	cfg := config.TestConfig(t)
+
+	storage := memstore.New()
+
+	log := logrus.New()
+	log.Out = io.Discard
+
+	n := new(testNotifier)
+	s := server.New(logger.GetLoggerByEntry(logrus.NewEntry(log)), storage, cfg)
+	s.SetOrderNotifier(n)
+	ts := httptest.NewServer(s.Router)
+	defer ts.Close()
+
+	cookies := getUserCookies(t, ts, &model.User{Login: userLogin1, Password: userPassword})
+
+	resp, _ := testRequest(t, ts, http.MethodPost, userOrdersPath, []byte(validOrderNum1), cookies)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode())
+
+	// already registered order is not notified again
+	resp, _ = testRequest(t, ts, http.MethodPost, userOrdersPath, []byte(validOrderNum1), cookies)
+	require.Equal(t, http.StatusOK, resp.StatusCode())
+
+	body := fmt.Sprintf("%s\n%s", validOrderNum1, validOrderNum2)
+	resp, _ = testRequest(t, ts, http.MethodPost, userOrdersBatchPath, []byte(body), cookies)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode())
+
+	require.Len(t, n.orders, 2)
+	assert.Equal(t, validOrderNum1, n.orders[0].Number)
+	assert.Equal(t, validOrderNum2, n.orders[1].Number)
+	assert.Equal(t, model.StatusNew, n.orders[1].Status)
+}
+
+type testUpdater struct {
+	orders []*model.OrderInAccrual
+}
+
+func (u *testUpdater) Update(_ context.Context, o *model.OrderInAccrual) error {
+	switch o.Status {
+	case model.StatusNew, model.StatusProcessing, model.StatusProcessed, model.StatusInvalid:
+	default:
+		return service.ErrUnknownStatus
+	}
+	if o.Number != validOrderNum1 {
+		return store.ErrNoContent
+	}
+	if o.Status == model.StatusNew {
+		return &model.TransitionError{From: model.StatusProcessed, To: o.Status}
+	}
+	u.orders = append(u.orders, o)
+	return nil
+}
+
+func TestAccrualCallback(t *testing.T) {
+	const secret = "secret"
+
+	sign := func(body string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(body))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	processed := fmt.Sprintf(`{"order":"%s","status":"PROCESSED","accrual":500}`, validOrderNum1)
+	unknown := fmt.Sprintf(`{"order":"%s","status":"PROCESSED","accrual":500}`, validOrderNum2)
+	badStatus := fmt.Sprintf(`{"order":"%s","status":"DONE"}`, validOrderNum1)
+	illegal := fmt.Sprintf(`{"order":"%s","status":"NEW"}`, validOrderNum1)
+
+	tests := []struct {
+		name      string
+		body      string
+		signature string
+		want      int
+	}{
+		{"applied", processed, sign(processed), http.StatusOK},
+		{"applied with prefixed signature", processed, "sha256=" + sign(processed), http.StatusOK},
+		{"no signature", processed, "", http.StatusUnauthorized},
+		{"bad signature", processed, sign(unknown), http.StatusUnauthorized},
+		{"not registered order", unknown, sign(unknown), http.StatusNotFound},
+		{"unknown status", badStatus, sign(badStatus), http.StatusUnprocessableEntity},
+		{"illegal transition", illegal, sign(illegal), http.StatusConflict},
+		{"bad json", "{", sign("{"), http.StatusBadRequest},
+		{"bad number", `{"order":"12ab"}`, sign(`{"order":"12ab"}`), http.StatusBadRequest},
+	}
+
+	log := logrus.New()
+	log.Out = io.Discard
+
+	cfg := config.TestConfig(t)
+	cfg.AccrualWebhookSecret = secret
+
+	u := new(testUpdater)
+	s := server.New(logger.GetLoggerByEntry(logrus.NewEntry(log)), nil, cfg)
+	s.SetAccrualUpdater(u)
+	ts := httptest.NewServer(s.Router)
+	defer ts.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := resty.New().R().
+				SetHeader(server.SignatureHeader, tt.signature).
+				SetBody(tt.body).
+				Post(ts.URL + accrualCallbackPath)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, resp.StatusCode())
+		})
+	}
+
+	require.Len(t, u.orders, 2)
+	assert.Equal(t, &model.OrderInAccrual{Number: validOrderNum1, Status: model.StatusProcessed, Accrual: 500}, u.orders[0])
+
+	t.Run("disabled without secret", func(t *testing.T) {
+		cfg := config.TestConfig(t)
+		s := server.New(logger.GetLoggerByEntry(logrus.NewEntry(log)), nil, cfg)
+		s.SetAccrualUpdater(u)
+		ts := httptest.NewServer(s.Router)
+		defer ts.Close()
+
+		resp, err := resty.New().R().
+			SetHeader(server.SignatureHeader, sign(processed)).
+			SetBody(processed).
+			Post(ts.URL + accrualCallbackPath)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode())
+	})
+}
+
+func TestOrderResolve(t *testing.T) {
+	const token = "admin"
+
+	log := logrus.New()
+	log.Out = io.Discard
+
+	cfg := config.TestConfig(t)
+	cfg.AdminToken = token
+
+	u := new(testUpdater)
+	s := server.New(logger.GetLoggerByEntry(logrus.NewEntry(log)), nil, cfg)
+	s.SetAccrualUpdater(u)
+	ts := httptest.NewServer(s.Router)
+	defer ts.Close()
+
+	tests := []struct {
+		name   string
+		token  string
+		number model.OrderNumber
+		body   string
+		want   int
+	}{
+		{"no token", "", validOrderNum1, `{"status":"PROCESSED","accrual":100}`, http.StatusUnauthorized},
+		{"bad token", "user", validOrderNum1, `{"status":"PROCESSED","accrual":100}`, http.StatusUnauthorized},
+		{"not final status", token, validOrderNum1, `{"status":"PROCESSING"}`, http.StatusBadRequest},
+		{"negative accrual", token, validOrderNum1, `{"status":"PROCESSED","accrual":-1}`, http.StatusBadRequest},
+		{"bad json", token, validOrderNum1, `{`, http.StatusBadRequest},
+		{"not registered", token, validOrderNum2, `{"status":"INVALID"}`, http.StatusNotFound},
+		{"resolved", token, validOrderNum1, `{"status":"PROCESSED","accrual":100}`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := resty.New().R().
+				SetAuthToken(tt.token).
+				SetBody(tt.body).
+				Post(fmt.Sprintf("%s%s/%s/resolve", ts.URL, adminOrdersPath, tt.number))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, resp.StatusCode())
+		})
+	}
+
+	require.Len(t, u.orders, 1)
+	assert.Equal(t, &model.OrderInAccrual{Number: validOrderNum1, Status: model.StatusProcessed, Accrual: 100}, u.orders[0])
+
+	t.Run("disabled without token", func(t *testing.T) {
+		s := server.New(logger.GetLoggerByEntry(logrus.NewEntry(log)), nil, config.TestConfig(t))
+		ts := httptest.NewServer(s.Router)
+		defer ts.Close()
+
+		resp, err := resty.New().R().SetAuthToken(token).Get(ts.URL + adminDeadLetterPath)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode())
+	})
+}
+
+func TestDeadLetter(t *testing.T) {
+	ctx := context.Background()
+	const token = "admin"
+
+	cfg := config.TestConfig(t)
+	cfg.AdminToken = token
+
+	storage := memstore.New()
+
+	s := server.New(l, storage, cfg)
+	ts := httptest.NewServer(s.Router)
+	defer ts.Close()
+
+	u := model.TestUser(t, userLogin1)
+	require.NoError(t, storage.User().Create(ctx, u))
+	require.NoError(t, storage.Order().Register(ctx, u.ID, validOrderNum1))
+
+	resp, err := resty.New().R().SetAuthToken(token).Get(ts.URL + adminDeadLetterPath)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode())
+
+	ok, err := storage.Order().ClaimOrder(ctx, "test", validOrderNum1, time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok)
+	_, err = storage.Order().RecordFailure(ctx, "test", validOrderNum1, "unexpected status", "", 1)
+	require.NoError(t, err)
+
+	resp, err = resty.New().R().SetAuthToken(token).Get(ts.URL + adminDeadLetterPath)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode())
+	var letters []*model.DeadLetter
+	require.NoError(t, json.Unmarshal(resp.Body(), &letters))
+	require.Len(t, letters, 1)
+	assert.Equal(t, validOrderNum1, letters[0].Number)
+	assert.Equal(t, "unexpected status", letters[0].LastError)
+
+	retryPath := fmt.Sprintf("%s%s/%s/retry", ts.URL, adminOrdersPath, validOrderNum1)
+	resp, err = resty.New().R().SetAuthToken(token).Post(retryPath)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+
+	resp, err = resty.New().R().SetAuthToken(token).Post(retryPath)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode())
+}
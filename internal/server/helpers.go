@@ -1,11 +1,19 @@
 package server
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/sirupsen/logrus"
+	"github.com/vlad-marlo/gophermart/internal/model"
 	"github.com/vlad-marlo/gophermart/pkg/encryptor"
 )
 
@@ -40,3 +48,58 @@ func GetUserIDFromRequest(r *http.Request) (int, error) {
 	}
 	return num, nil
 }
+
+// parseOrderNumbers parses JSON array or newline separated list of order numbers. Entries which are not order numbers
+// are returned as is, so they are registered with invalid result and don't fail the whole batch.
+func parseOrderNumbers(data []byte) ([]model.OrderNumber, error) {
+	var raw []string
+
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("[")) {
+		var values []json.RawMessage
+		if err := json.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("json unmarshal: %w", err)
+		}
+		for _, v := range values {
+			var s string
+			if err := json.Unmarshal(v, &s); err != nil {
+				// numbers and other values are kept as they are written
+				s = string(v)
+			}
+			raw = append(raw, s)
+		}
+	} else {
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				raw = append(raw, line)
+			}
+		}
+	}
+
+	if len(raw) == 0 {
+		return nil, errors.New("empty list of numbers")
+	}
+
+	numbers := make([]model.OrderNumber, 0, len(raw))
+	for _, v := range raw {
+		num, err := model.ParseOrderNumber(v)
+		if err != nil {
+			num = model.OrderNumber(v)
+		}
+		numbers = append(numbers, num)
+	}
+	return numbers, nil
+}
+
+// validSignature reports whether sig is hex encoded HMAC-SHA256 of body signed with secret; sig could be prefixed
+// with "sha256=".
+func validSignature(secret, body []byte, sig string) bool {
+	got, err := hex.DecodeString(strings.TrimPrefix(sig, "sha256="))
+	if err != nil || len(got) == 0 {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vlad-marlo/gophermart/internal/model"
+)
+
+func TestParseOrderNumbers(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []model.OrderNumber
+		wantErr bool
+	}{
+		{
+			name: "json array of strings",
+			data: `["12345678903", "79927398713"]`,
+			want: []model.OrderNumber{"12345678903", "79927398713"},
+		},
+		{
+			name: "json array of numbers",
+			data: `[12345678903, 79927398713]`,
+			want: []model.OrderNumber{"12345678903", "79927398713"},
+		},
+		{
+			name: "newline separated list",
+			data: "12345678903\n\n 79927398713\r\n",
+			want: []model.OrderNumber{"12345678903", "79927398713"},
+		},
+		{
+			name:    "empty list",
+			data:    " \n ",
+			wantErr: true,
+		},
+		{
+			name:    "empty array",
+			data:    "[]",
+			wantErr: true,
+		},
+		{
+			name: "not a number",
+			data: "12345678903\nabc",
+			want: []model.OrderNumber{"12345678903", "abc"},
+		},
+		{
+			name:    "bad json",
+			data:    `["12345678903"`,
+			wantErr: true,
+		},
+		{
+			name: "object in array",
+			data: `[{"number": "12345678903"}, "79927398713"]`,
+			want: []model.OrderNumber{`{"number": "12345678903"}`, "79927398713"},
+		},
+		{
+			name: "not an array",
+			data: `{"number": "12345678903"}`,
+			want: []model.OrderNumber{`{"number": "12345678903"}`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOrderNumbers([]byte(tt.data))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
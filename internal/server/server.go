@@ -1,31 +1,50 @@
 package server
 
 import (
+	"context"
+
 	"github.com/vlad-marlo/gophermart/pkg/logger"
 	"github.com/vlad-marlo/gophermart/pkg/middlewares"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/vlad-marlo/gophermart/internal/config"
+	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/internal/service"
 	"github.com/vlad-marlo/gophermart/internal/store"
 )
 
-type Server struct {
-	chi.Router
-	store  store.Storage
-	logger logger.Logger
-	// don't sure that config is necessary in Server struct
-	config *config.Config
-}
+type (
+	Server struct {
+		chi.Router
+		logger logger.Logger
+		// don't sure that config is necessary in Server struct
+		config  *config.Config
+		auth    *service.AuthService
+		orders  *service.OrderService
+		balance *service.BalanceService
+		// updater is order service unless it is replaced
+		updater AccrualUpdater
+	}
+	// OrderNotifier is notified about freshly registered orders
+	OrderNotifier = service.OrderNotifier
+	// AccrualUpdater applies state of order pushed by accrual system
+	AccrualUpdater interface {
+		Update(ctx context.Context, order *model.OrderInAccrual) error
+	}
+)
 
 // New ...
 func New(l logger.Logger, store store.Storage, config *config.Config) *Server {
 	s := &Server{
-		store:  store,
-		config: config,
-		Router: chi.NewMux(),
-		logger: l,
+		config:  config,
+		Router:  chi.NewMux(),
+		logger:  l,
+		auth:    service.NewAuthService(store),
+		orders:  service.NewOrderService(l, store),
+		balance: service.NewBalanceService(store, config.TransferDailyLimit),
 	}
+	s.updater = s.orders
 
 	s.configureMiddlewares()
 	s.configureRoutes()
@@ -33,6 +52,16 @@ func New(l logger.Logger, store store.Storage, config *config.Config) *Server {
 	return s
 }
 
+// SetOrderNotifier ...
+func (s *Server) SetOrderNotifier(n OrderNotifier) {
+	s.orders.SetNotifier(n)
+}
+
+// SetAccrualUpdater ...
+func (s *Server) SetAccrualUpdater(u AccrualUpdater) {
+	s.updater = u
+}
+
 // configureMiddlewares ...
 func (s *Server) configureMiddlewares() {
 	s.Use(middleware.RequestID)
@@ -50,11 +79,25 @@ func (s *Server) configureRoutes() {
 		// endpoints for authorized users only
 		r.With(s.CheckAuthMiddleware).Route("/", func(r chi.Router) {
 			r.Post("/orders", s.handleOrdersPost())
+			r.Post("/orders/batch", s.handleOrdersBatchPost())
 			r.Get("/orders", s.handleOrdersGet())
+			r.Get("/orders/{number}", s.handleOrderGet())
+			r.Delete("/orders/{number}", s.handleOrderDelete())
 			r.Get("/balance", s.handleBalanceGet())
 			r.Post("/balance/withdraw", s.handleWithdrawsPost())
+			r.Post("/balance/transfer", s.handleTransferPost())
+			r.Get("/balance/transfers", s.handleGetAllTransfers())
 			r.Get("/balance/withdrawals", s.handleGetAllWithdraws())
 			r.Get("/withdrawals", s.handleGetAllWithdraws())
 		})
 	})
+	s.Route("/api/admin", func(r chi.Router) {
+		r.Use(s.CheckAdminMiddleware)
+		r.Get("/orders/dead-letter", s.handleDeadLetterGet())
+		r.Post("/orders/{number}/retry", s.handleOrderRetry())
+		r.Post("/orders/{number}/resolve", s.handleOrderResolve())
+	})
+	s.Route("/api/internal", func(r chi.Router) {
+		r.Post("/accrual/callback", s.handleAccrualCallback())
+	})
 }
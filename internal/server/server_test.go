@@ -10,29 +10,30 @@ import (
 	"github.com/vlad-marlo/gophermart/pkg/logger"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"testing"
 )
 
 var (
-	conStr               = os.Getenv("TEST_DB_URI")
-	userLogin1           = "server_first"
-	userLogin2           = "server_second"
-	userPassword         = "password"
-	userTableName        = "users"
-	ordersTableName      = "orders"
-	withdrawalsTableName = "withdrawals"
+	userLogin1   = "server_first"
+	userLogin2   = "server_second"
+	userPassword = "password"
 
 	userLoginPath       = "/api/user/login"
 	userBalancePath     = "/api/user/balance"
 	userRegisterPath    = "/api/user/register"
 	userOrdersPath      = "/api/user/orders"
+	userOrdersBatchPath = "/api/user/orders/batch"
 	userWithdrawPath    = "/api/user/balance/withdraw"
 	userWithdrawalsPath = "/api/user/balance/withdrawals"
+	userTransferPath    = "/api/user/balance/transfer"
+	userTransfersPath   = "/api/user/balance/transfers"
+	accrualCallbackPath = "/api/internal/accrual/callback"
+	adminDeadLetterPath = "/api/admin/orders/dead-letter"
+	adminOrdersPath     = "/api/admin/orders"
 
-	validOrderNum1 = 12345678903
-	validOrderNum2 = 4532733309529845
-	validOrderNum3 = 4539088167512356
+	validOrderNum1 = model.OrderNumber("12345678903")
+	validOrderNum2 = model.OrderNumber("4532733309529845")
+	validOrderNum3 = model.OrderNumber("4539088167512356")
 
 	l = logger.GetLoggerByEntry(logrus.NewEntry(logrus.New()))
 )
@@ -54,6 +55,8 @@ func testRequest(t *testing.T, ts *httptest.Server, method, path string, body []
 		resp, err = r.Post(ts.URL + path)
 	case http.MethodGet:
 		resp, err = r.Get(ts.URL + path)
+	case http.MethodDelete:
+		resp, err = r.Delete(ts.URL + path)
 	default:
 		t.Fatalf("got unexpected method: %s", method)
 	}
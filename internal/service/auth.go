@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/internal/store"
+)
+
+// AuthService registers and authenticates users.
+type AuthService struct {
+	store store.Storage
+}
+
+// NewAuthService ...
+func NewAuthService(s store.Storage) *AuthService {
+	return &AuthService{store: s}
+}
+
+// Register creates user u and sets its ID. Returns ErrInvalidArgument if login or password is too short and
+// store.ErrLoginAlreadyInUse if login is taken.
+func (s *AuthService) Register(ctx context.Context, u *model.User) error {
+	if u == nil || !u.Valid() {
+		return ErrInvalidArgument
+	}
+	if err := s.store.User().Create(ctx, u); err != nil {
+		return fmt.Errorf("create user: %w", err)
+	}
+	return nil
+}
+
+// Login returns user with login and password. Returns ErrInvalidArgument if login or password is empty and
+// ErrUnauthorized if they are wrong.
+func (s *AuthService) Login(ctx context.Context, login, password string) (*model.User, error) {
+	if login == "" || password == "" {
+		return nil, ErrInvalidArgument
+	}
+
+	u, err := s.store.User().GetByLogin(ctx, login)
+	if err != nil {
+		if errors.Is(err, store.ErrIncorrectLoginData) {
+			return nil, ErrUnauthorized
+		}
+		return nil, fmt.Errorf("get user by login: %w", err)
+	}
+	if err := u.ComparePassword(password); err != nil {
+		return nil, ErrUnauthorized
+	}
+	return u, nil
+}
+
+// Exists reports whether user with id is registered.
+func (s *AuthService) Exists(ctx context.Context, id int) bool {
+	return s.store.User().ExistsWithID(ctx, id)
+}
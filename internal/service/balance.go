@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/internal/store"
+)
+
+// BalanceService manages balance of users: withdrawals of points and transfers between users.
+type BalanceService struct {
+	store store.Storage
+	// dailyLimit is max sum of transfers sent by user per day; there is no limit if it is not positive
+	dailyLimit float64
+}
+
+// NewBalanceService ...
+func NewBalanceService(s store.Storage, dailyLimit float64) *BalanceService {
+	return &BalanceService{
+		store:      s,
+		dailyLimit: dailyLimit,
+	}
+}
+
+// Get returns balance of user.
+func (s *BalanceService) Get(ctx context.Context, user int) (*model.UserBalance, error) {
+	b, err := s.store.User().GetBalance(ctx, user)
+	if err != nil {
+		return nil, fmt.Errorf("get balance: %w", err)
+	}
+	return b, nil
+}
+
+// Withdraw debits user for order w. Returns ErrBadOrderNumber if number of order doesn't pass luhn check,
+// ErrInvalidArgument if sum is not positive and store.ErrPaymentRequired if balance of user is not enough.
+func (s *BalanceService) Withdraw(ctx context.Context, user int, w *model.Withdraw) error {
+	if !w.Order.Valid() {
+		return ErrBadOrderNumber
+	}
+	if w.Sum <= 0 {
+		return ErrInvalidArgument
+	}
+	if err := s.store.Withdraws().Withdraw(ctx, user, w); err != nil {
+		return fmt.Errorf("withdraw: %w", err)
+	}
+	return nil
+}
+
+// Withdrawals returns withdrawals of user or store.ErrNoContent if there is no one.
+func (s *BalanceService) Withdrawals(ctx context.Context, user int) ([]*model.Withdraw, error) {
+	res, err := s.store.Withdraws().GetAllByUser(ctx, user)
+	if err != nil {
+		return nil, fmt.Errorf("get withdrawals: %w", err)
+	}
+	return res, nil
+}
+
+// Transfer moves t.Sum points from user to user with t.Login within daily limit. Returns ErrInvalidArgument if
+// recipient is empty or sum is not positive; errors of store.TransferRepository are returned as is.
+func (s *BalanceService) Transfer(ctx context.Context, user int, t *model.Transfer) error {
+	if t.Login == "" || t.Sum <= 0 {
+		return ErrInvalidArgument
+	}
+	if _, err := s.store.Transfers().Transfer(ctx, user, t, s.dailyLimit); err != nil {
+		return fmt.Errorf("transfer: %w", err)
+	}
+	return nil
+}
+
+// Transfers returns incoming and outgoing transfers of user or store.ErrNoContent if there is no one.
+func (s *BalanceService) Transfers(ctx context.Context, user int) ([]*model.Transfer, error) {
+	res, err := s.store.Transfers().GetAllByUser(ctx, user)
+	if err != nil {
+		return nil, fmt.Errorf("get transfers: %w", err)
+	}
+	return res, nil
+}
@@ -0,0 +1,14 @@
+package service
+
+import "errors"
+
+var (
+	// ErrInvalidArgument is returned when arguments don't satisfy requirements of operation
+	ErrInvalidArgument = errors.New("invalid argument")
+	// ErrUnauthorized is returned when login or password is wrong
+	ErrUnauthorized = errors.New("wrong login or password")
+	// ErrBadOrderNumber is returned when order number doesn't pass luhn check
+	ErrBadOrderNumber = errors.New("order number did not pass luhn check")
+	// ErrUnknownStatus is returned when accrual system reports status of order which is not known
+	ErrUnknownStatus = errors.New("unknown status of order")
+)
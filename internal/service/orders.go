@@ -0,0 +1,196 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/internal/store"
+	"github.com/vlad-marlo/gophermart/pkg/logger"
+)
+
+type (
+	// OrderService registers orders of users and applies their state got from accrual system.
+	OrderService struct {
+		store  store.Storage
+		logger logger.Logger
+		// notifier could be nil
+		notifier OrderNotifier
+	}
+	// OrderNotifier is notified about freshly registered orders
+	OrderNotifier interface {
+		Notify(o *model.OrderInPoll)
+	}
+)
+
+// NewOrderService ...
+func NewOrderService(l logger.Logger, s store.Storage) *OrderService {
+	return &OrderService{
+		store:  s,
+		logger: l,
+	}
+}
+
+// SetNotifier ...
+func (s *OrderService) SetNotifier(n OrderNotifier) {
+	s.notifier = n
+}
+
+// notify ...
+func (s *OrderService) notify(user int, number model.OrderNumber) {
+	if s.notifier == nil {
+		return
+	}
+	s.notifier.Notify(&model.OrderInPoll{
+		Number: number,
+		Status: model.StatusNew,
+		User:   user,
+	})
+}
+
+// Register registers order of user. Returns ErrBadOrderNumber if number doesn't pass luhn check; errors of
+// store.OrderRepository are returned as is.
+func (s *OrderService) Register(ctx context.Context, user int, number model.OrderNumber) error {
+	if !number.Valid() {
+		return ErrBadOrderNumber
+	}
+	if err := s.store.Order().Register(ctx, user, number); err != nil {
+		return fmt.Errorf("register: %w", err)
+	}
+	s.notify(user, number)
+	return nil
+}
+
+// RegisterBatch registers orders of user and returns results in the same order as numbers. Numbers which don't pass
+// luhn check get model.RegistrationInvalid result and are not registered.
+func (s *OrderService) RegisterBatch(ctx context.Context, user int, numbers []model.OrderNumber) ([]*model.OrderRegistration, error) {
+	res := make([]*model.OrderRegistration, len(numbers))
+	var valid []model.OrderNumber
+	for i, num := range numbers {
+		if _, err := model.ParseOrderNumber(num.String()); err != nil || !num.Valid() {
+			res[i] = &model.OrderRegistration{Number: num, Result: model.RegistrationInvalid}
+			continue
+		}
+		valid = append(valid, num)
+	}
+	if len(valid) == 0 {
+		return res, nil
+	}
+
+	registered, err := s.store.Order().RegisterBatch(ctx, user, valid)
+	if err != nil {
+		return nil, fmt.Errorf("register batch: %w", err)
+	}
+	// registered results are in the same order as valid numbers
+	j := 0
+	for i := range res {
+		if res[i] != nil {
+			continue
+		}
+		res[i] = registered[j]
+		if registered[j].Result == model.RegistrationAccepted {
+			s.notify(user, registered[j].Number)
+		}
+		j++
+	}
+	return res, nil
+}
+
+// GetAll returns orders of user or store.ErrNoContent if there is no one.
+func (s *OrderService) GetAll(ctx context.Context, user int) ([]*model.Order, error) {
+	orders, err := s.store.Order().GetAllByUser(ctx, user)
+	if err != nil {
+		return nil, fmt.Errorf("get all by user: %w", err)
+	}
+	return orders, nil
+}
+
+// Get returns order of user with number or store.ErrNoContent if user has no such order.
+func (s *OrderService) Get(ctx context.Context, user int, number model.OrderNumber) (*model.Order, error) {
+	order, err := s.store.Order().GetByNumber(ctx, user, number)
+	if err != nil {
+		return nil, fmt.Errorf("get by number: %w", err)
+	}
+	return order, nil
+}
+
+// Cancel cancels order of user which is not processed yet.
+func (s *OrderService) Cancel(ctx context.Context, user int, number model.OrderNumber) error {
+	if err := s.store.Order().Cancel(ctx, user, number); err != nil {
+		return fmt.Errorf("cancel: %w", err)
+	}
+	return nil
+}
+
+// DeadLettered returns orders which state could not be resolved from answers of accrual system.
+func (s *OrderService) DeadLettered(ctx context.Context) ([]*model.DeadLetter, error) {
+	orders, err := s.store.Order().GetDeadLettered(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get dead-lettered: %w", err)
+	}
+	return orders, nil
+}
+
+// Retry returns dead-lettered order to polling.
+func (s *OrderService) Retry(ctx context.Context, number model.OrderNumber) error {
+	if err := s.store.Order().RetryDeadLettered(ctx, number); err != nil {
+		return fmt.Errorf("retry dead-lettered: %w", err)
+	}
+	return nil
+}
+
+// Update applies state of order pushed by accrual system. Returns store.ErrNoContent if order is not registered,
+// ErrUnknownStatus if status of order is not known and *model.TransitionError if order can't get pushed status.
+func (s *OrderService) Update(ctx context.Context, order *model.OrderInAccrual) error {
+	o, err := s.store.Order().GetActiveByNumber(ctx, order.Number)
+	if err != nil {
+		return fmt.Errorf("get order: %w", err)
+	}
+
+	if _, err := s.Apply(ctx, o, order); err != nil {
+		return fmt.Errorf("apply: %w", err)
+	}
+	return nil
+}
+
+// Apply updates order o by its state in accrual system and reports whether order got final status.
+func (s *OrderService) Apply(ctx context.Context, o *model.OrderInPoll, order *model.OrderInAccrual) (final bool, err error) {
+	l := s.logger.WithFields(map[string]interface{}{
+		"user":  o.User,
+		"order": o.Number,
+	})
+
+	switch order.Status {
+	case model.StatusProcessing:
+		if err := s.store.Order().ChangeStatus(ctx, o.User, order); err != nil {
+			return false, fmt.Errorf("change status: %w", err)
+		}
+		return false, nil
+	case model.AccrualStatusRegistered:
+		return false, nil
+	case model.StatusInvalid:
+		if err := s.store.Order().ChangeStatus(ctx, o.User, order); err != nil {
+			return false, fmt.Errorf("change status: %w", err)
+		}
+		return true, nil
+	case model.StatusProcessed:
+		if order.Accrual > 0.0 {
+			credited, err := s.store.Order().ChangeStatusAndIncrementUserBalance(ctx, o.User, order)
+			if err != nil {
+				return false, fmt.Errorf("change status and increment user balance: %w", err)
+			}
+			if !credited {
+				l.Debug("order was already processed; user balance is not incremented")
+				return true, nil
+			}
+			l.Trace("successful changed status to processed and incremented user balance")
+			return true, nil
+		} else if err := s.store.Order().ChangeStatus(ctx, o.User, order); err != nil {
+			return false, fmt.Errorf("change status: %w", err)
+		}
+		l.Trace("successful changed user status")
+		return true, nil
+	default:
+		return false, fmt.Errorf("%w: %s", ErrUnknownStatus, order.Status)
+	}
+}
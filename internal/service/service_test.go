@@ -0,0 +1,152 @@
+package service_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/internal/service"
+	"github.com/vlad-marlo/gophermart/internal/store"
+	"github.com/vlad-marlo/gophermart/internal/store/memstore"
+	"github.com/vlad-marlo/gophermart/pkg/logger"
+)
+
+const (
+	userLogin1   = "first"
+	userLogin2   = "second"
+	userPassword = "password"
+
+	validOrderNum1 = model.OrderNumber("79927398713")
+	validOrderNum2 = model.OrderNumber("12345678903")
+	badOrderNum    = model.OrderNumber("79927398710")
+)
+
+type testNotifier struct {
+	orders []*model.OrderInPoll
+}
+
+func (n *testNotifier) Notify(o *model.OrderInPoll) {
+	n.orders = append(n.orders, o)
+}
+
+func testLogger(t *testing.T) logger.Logger {
+	t.Helper()
+
+	log := logrus.New()
+	log.Out = io.Discard
+	return logger.GetLoggerByEntry(logrus.NewEntry(log))
+}
+
+func registerUser(t *testing.T, a *service.AuthService, login string) *model.User {
+	t.Helper()
+
+	u := &model.User{Login: login, Password: userPassword}
+	require.NoError(t, a.Register(context.Background(), u))
+	return u
+}
+
+func TestAuthService(t *testing.T) {
+	ctx := context.Background()
+	a := service.NewAuthService(memstore.New())
+
+	assert.ErrorIs(t, a.Register(ctx, &model.User{Login: "abc", Password: userPassword}), service.ErrInvalidArgument)
+	assert.ErrorIs(t, a.Register(ctx, &model.User{Login: userLogin1, Password: "pass"}), service.ErrInvalidArgument)
+	assert.ErrorIs(t, a.Register(ctx, nil), service.ErrInvalidArgument)
+
+	u := registerUser(t, a, userLogin1)
+	assert.True(t, a.Exists(ctx, u.ID))
+	assert.ErrorIs(t, a.Register(ctx, &model.User{Login: userLogin1, Password: userPassword}), store.ErrLoginAlreadyInUse)
+
+	got, err := a.Login(ctx, userLogin1, userPassword)
+	require.NoError(t, err)
+	assert.Equal(t, u.ID, got.ID)
+
+	_, err = a.Login(ctx, userLogin1, "wrong password")
+	assert.ErrorIs(t, err, service.ErrUnauthorized)
+	_, err = a.Login(ctx, userLogin2, userPassword)
+	assert.ErrorIs(t, err, service.ErrUnauthorized)
+	_, err = a.Login(ctx, "", userPassword)
+	assert.ErrorIs(t, err, service.ErrInvalidArgument)
+}
+
+func TestOrderService_Register(t *testing.T) {
+	ctx := context.Background()
+	s := memstore.New()
+	u := registerUser(t, service.NewAuthService(s), userLogin1)
+
+	n := new(testNotifier)
+	o := service.NewOrderService(testLogger(t), s)
+	o.SetNotifier(n)
+
+	assert.ErrorIs(t, o.Register(ctx, u.ID, badOrderNum), service.ErrBadOrderNumber)
+	require.NoError(t, o.Register(ctx, u.ID, validOrderNum1))
+	assert.ErrorIs(t, o.Register(ctx, u.ID, validOrderNum1), store.ErrAlreadyRegisteredByUser)
+
+	res, err := o.RegisterBatch(ctx, u.ID, []model.OrderNumber{badOrderNum, validOrderNum1, validOrderNum2})
+	require.NoError(t, err)
+	require.Len(t, res, 3)
+	assert.Equal(t, model.RegistrationInvalid, res[0].Result)
+	assert.Equal(t, validOrderNum1, res[1].Number)
+	assert.NotEqual(t, model.RegistrationAccepted, res[1].Result)
+	assert.Equal(t, model.RegistrationAccepted, res[2].Result)
+
+	// only accepted orders are notified
+	require.Len(t, n.orders, 2)
+	assert.Equal(t, validOrderNum1, n.orders[0].Number)
+	assert.Equal(t, validOrderNum2, n.orders[1].Number)
+	assert.Equal(t, model.StatusNew, n.orders[1].Status)
+}
+
+func TestOrderService_Update(t *testing.T) {
+	ctx := context.Background()
+	s := memstore.New()
+	u := registerUser(t, service.NewAuthService(s), userLogin1)
+	o := service.NewOrderService(testLogger(t), s)
+	require.NoError(t, o.Register(ctx, u.ID, validOrderNum1))
+
+	err := o.Update(ctx, &model.OrderInAccrual{Number: validOrderNum1, Status: "DONE"})
+	assert.ErrorIs(t, err, service.ErrUnknownStatus)
+	err = o.Update(ctx, &model.OrderInAccrual{Number: validOrderNum2, Status: model.StatusProcessing})
+	assert.ErrorIs(t, err, store.ErrNoContent)
+
+	require.NoError(t, o.Update(ctx, &model.OrderInAccrual{Number: validOrderNum1, Status: model.StatusProcessing}))
+	require.NoError(t, o.Update(ctx, &model.OrderInAccrual{Number: validOrderNum1, Status: model.StatusProcessed, Accrual: 100}))
+
+	order, err := o.Get(ctx, u.ID, validOrderNum1)
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusProcessed, order.Status)
+
+	b, err := service.NewBalanceService(s, 0).Get(ctx, u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 100.0, b.Current)
+}
+
+func TestBalanceService(t *testing.T) {
+	ctx := context.Background()
+	s := memstore.New()
+	a := service.NewAuthService(s)
+	u1 := registerUser(t, a, userLogin1)
+	registerUser(t, a, userLogin2)
+	require.NoError(t, s.User().IncrementBalance(ctx, u1.ID, 100))
+
+	b := service.NewBalanceService(s, 50)
+
+	assert.ErrorIs(t, b.Withdraw(ctx, u1.ID, model.TestWithdraw(t, badOrderNum, 10)), service.ErrBadOrderNumber)
+	assert.ErrorIs(t, b.Withdraw(ctx, u1.ID, model.TestWithdraw(t, validOrderNum1, 0)), service.ErrInvalidArgument)
+	assert.ErrorIs(t, b.Withdraw(ctx, u1.ID, model.TestWithdraw(t, validOrderNum1, 101)), store.ErrPaymentRequired)
+	require.NoError(t, b.Withdraw(ctx, u1.ID, model.TestWithdraw(t, validOrderNum1, 10)))
+
+	assert.ErrorIs(t, b.Transfer(ctx, u1.ID, &model.Transfer{Sum: 10}), service.ErrInvalidArgument)
+	assert.ErrorIs(t, b.Transfer(ctx, u1.ID, &model.Transfer{Login: userLogin2, Sum: -1}), service.ErrInvalidArgument)
+	require.NoError(t, b.Transfer(ctx, u1.ID, &model.Transfer{Login: userLogin2, Sum: 40}))
+	assert.ErrorIs(t, b.Transfer(ctx, u1.ID, &model.Transfer{Login: userLogin2, Sum: 20}), store.ErrDailyLimitExceeded)
+
+	bal, err := b.Get(ctx, u1.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 50.0, bal.Current)
+	assert.Equal(t, 10.0, bal.Withdrawn)
+}
@@ -0,0 +1,124 @@
+package cachestore
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Cache stores values by keys for limited time. It is implemented by in-process LRU; shared cache like Redis could
+// implement it too, so instances of application see invalidations of each other.
+type Cache interface {
+	// Get returns value stored with key; ok is false if there is no such value or it is expired
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	// Set stores value with key for ttl; zero ttl stores value until it is evicted
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes values stored with keys
+	Delete(ctx context.Context, keys ...string) error
+}
+
+type (
+	// LRU is in-process Cache which evicts least recently used value when it is full.
+	LRU struct {
+		size int
+		now  func() time.Time
+
+		// mu protects entries and order which front is most recently used entry
+		mu      sync.Mutex
+		entries map[string]*list.Element
+		order   *list.List
+	}
+	// lruEntry ...
+	lruEntry struct {
+		key       string
+		value     []byte
+		expiresAt time.Time
+	}
+)
+
+var _ Cache = (*LRU)(nil)
+
+// NewLRU returns LRU which keeps at most size values.
+func NewLRU(size int) *LRU {
+	return &LRU{
+		size:    size,
+		now:     time.Now,
+		entries: make(map[string]*list.Element, size),
+		order:   list.New(),
+	}
+}
+
+// Get ...
+func (c *LRU) Get(_ context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	e := el.Value.(*lruEntry)
+	if !e.expiresAt.IsZero() && !c.now().Before(e.expiresAt) {
+		c.remove(el)
+		return nil, false, nil
+	}
+	c.order.MoveToFront(el)
+	return e.value, true, nil
+}
+
+// Set ...
+func (c *LRU) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = c.now().Add(ttl)
+	}
+
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*lruEntry)
+		e.value = value
+		e.expiresAt = expiresAt
+		c.order.MoveToFront(el)
+		return nil
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry{
+		key:       key,
+		value:     value,
+		expiresAt: expiresAt,
+	})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+	return nil
+}
+
+// Delete ...
+func (c *LRU) Delete(_ context.Context, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		if el, ok := c.entries[key]; ok {
+			c.remove(el)
+		}
+	}
+	return nil
+}
+
+// Len returns count of stored values including expired ones which were not evicted yet.
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+// remove must be called with locked mu.
+func (c *LRU) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*lruEntry).key)
+}
@@ -0,0 +1,47 @@
+package cachestore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRU(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	c := NewLRU(2)
+	c.now = func() time.Time { return now }
+
+	get := func(key string) (string, bool) {
+		v, ok, err := c.Get(ctx, key)
+		require.NoError(t, err)
+		return string(v), ok
+	}
+
+	require.NoError(t, c.Set(ctx, "a", []byte("1"), 0))
+	require.NoError(t, c.Set(ctx, "b", []byte("2"), 0))
+	v, ok := get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "1", v)
+
+	// b is least recently used
+	require.NoError(t, c.Set(ctx, "c", []byte("3"), 0))
+	_, ok = get("b")
+	assert.False(t, ok)
+	assert.Equal(t, 2, c.Len())
+
+	require.NoError(t, c.Set(ctx, "a", []byte("4"), time.Second))
+	v, _ = get("a")
+	assert.Equal(t, "4", v)
+	now = now.Add(time.Second)
+	_, ok = get("a")
+	assert.False(t, ok, "value is expired")
+
+	require.NoError(t, c.Delete(ctx, "c", "unknown"))
+	_, ok = get("c")
+	assert.False(t, ok)
+	assert.Equal(t, 0, c.Len())
+}
@@ -0,0 +1,13 @@
+package cachestore
+
+import "expvar"
+
+// metrics of cache are published with expvar under "cache" key
+var metrics = expvar.NewMap("cache")
+
+const (
+	metricHits          = "hits"
+	metricMisses        = "misses"
+	metricInvalidations = "invalidations"
+	metricErrors        = "errors"
+)
@@ -0,0 +1,115 @@
+package cachestore
+
+import (
+	"context"
+
+	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/internal/store"
+)
+
+type (
+	// userRepository caches balance of users
+	userRepository struct {
+		store.UserRepository
+		s *storage
+	}
+	// orderRepository invalidates balance of user credited for order
+	orderRepository struct {
+		store.OrderRepository
+		s *storage
+	}
+	// withdrawRepository caches withdrawals of users and invalidates them and balance on withdraw
+	withdrawRepository struct {
+		store.WithdrawRepository
+		s *storage
+	}
+	// transferRepository invalidates balances of sender and recipient of transfer
+	transferRepository struct {
+		store.TransferRepository
+		s *storage
+	}
+)
+
+// GetBalance ...
+func (r *userRepository) GetBalance(ctx context.Context, id int) (*model.UserBalance, error) {
+	if !r.s.cached() {
+		return r.UserRepository.GetBalance(ctx, id)
+	}
+
+	key := balanceKey(id)
+	balance := new(model.UserBalance)
+	if r.s.load(ctx, key, balance) {
+		return balance, nil
+	}
+
+	gen := r.s.generation(key)
+	defer r.s.release(key)
+	balance, err := r.UserRepository.GetBalance(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	r.s.save(ctx, key, gen, balance)
+	return balance, nil
+}
+
+// IncrementBalance ...
+func (r *userRepository) IncrementBalance(ctx context.Context, id int, add float64) error {
+	if err := r.UserRepository.IncrementBalance(ctx, id, add); err != nil {
+		return err
+	}
+	r.s.invalidate(ctx, balanceKey(id))
+	return nil
+}
+
+// ChangeStatusAndIncrementUserBalance ...
+func (r *orderRepository) ChangeStatusAndIncrementUserBalance(ctx context.Context, user int, m *model.OrderInAccrual) (bool, error) {
+	credited, err := r.OrderRepository.ChangeStatusAndIncrementUserBalance(ctx, user, m)
+	if err != nil {
+		return false, err
+	}
+	if credited {
+		r.s.invalidate(ctx, balanceKey(user))
+	}
+	return credited, nil
+}
+
+// Withdraw ...
+func (r *withdrawRepository) Withdraw(ctx context.Context, user int, w *model.Withdraw) error {
+	if err := r.WithdrawRepository.Withdraw(ctx, user, w); err != nil {
+		return err
+	}
+	r.s.invalidate(ctx, balanceKey(user), withdrawalsKey(user))
+	return nil
+}
+
+// GetAllByUser ...
+func (r *withdrawRepository) GetAllByUser(ctx context.Context, user int) ([]*model.Withdraw, error) {
+	if !r.s.cached() {
+		return r.WithdrawRepository.GetAllByUser(ctx, user)
+	}
+
+	key := withdrawalsKey(user)
+	var res []*model.Withdraw
+	if r.s.load(ctx, key, &res) {
+		return res, nil
+	}
+
+	gen := r.s.generation(key)
+	defer r.s.release(key)
+	res, err := r.WithdrawRepository.GetAllByUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	r.s.save(ctx, key, gen, res)
+	return res, nil
+}
+
+// Transfer ...
+func (r *transferRepository) Transfer(ctx context.Context, user int, t *model.Transfer, dailyLimit float64) (int, error) {
+	recipient, err := r.TransferRepository.Transfer(ctx, user, t, dailyLimit)
+	if err != nil {
+		return 0, err
+	}
+	r.s.invalidate(ctx, balanceKey(user), balanceKey(recipient))
+	return recipient, nil
+}
@@ -0,0 +1,226 @@
+package cachestore
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vlad-marlo/gophermart/internal/store"
+	"github.com/vlad-marlo/gophermart/pkg/logger"
+)
+
+type (
+	// storage caches balance and withdrawals of users read from underlying storage. Cached values are invalidated by
+	// writes which change them; writes of transaction invalidate values after commit.
+	storage struct {
+		store.Storage
+		cache  Cache
+		ttl    time.Duration
+		logger logger.Logger
+		// versions of keys are shared with storages bound to transactions
+		versions *versions
+		// pending is not nil if storage is bound to transaction; its keys are invalidated after commit
+		pending *[]string
+
+		// repositories
+		user     store.UserRepository
+		order    store.OrderRepository
+		withdraw store.WithdrawRepository
+		transfer store.TransferRepository
+	}
+	// versions contains generations of keys which are read from underlying storage; generation of key is incremented
+	// by its invalidation, so value read before invalidation is not cached. mu makes check of generation and setting of
+	// value atomic relative to invalidation.
+	versions struct {
+		mu   sync.Mutex
+		keys map[string]*version
+	}
+	// version is forgotten when key is not read anymore, so map contains only keys with reads in progress
+	version struct {
+		gen     uint64
+		readers int
+	}
+)
+
+// New returns storage which caches balance and withdrawals of users read from s in c for ttl.
+func New(l logger.Logger, s store.Storage, c Cache, ttl time.Duration) store.Storage {
+	return newStorage(s, c, ttl, l, &versions{keys: make(map[string]*version)})
+}
+
+// newStorage ...
+func newStorage(s store.Storage, c Cache, ttl time.Duration, l logger.Logger, v *versions) *storage {
+	cs := &storage{
+		Storage:  s,
+		cache:    c,
+		ttl:      ttl,
+		logger:   l,
+		versions: v,
+	}
+	cs.user = &userRepository{UserRepository: s.User(), s: cs}
+	cs.order = &orderRepository{OrderRepository: s.Order(), s: cs}
+	cs.withdraw = &withdrawRepository{WithdrawRepository: s.Withdraws(), s: cs}
+	cs.transfer = &transferRepository{TransferRepository: s.Transfers(), s: cs}
+	return cs
+}
+
+// User ...
+func (s *storage) User() store.UserRepository {
+	return s.user
+}
+
+// Order ...
+func (s *storage) Order() store.OrderRepository {
+	return s.order
+}
+
+// Withdraws ...
+func (s *storage) Withdraws() store.WithdrawRepository {
+	return s.withdraw
+}
+
+// Transfers ...
+func (s *storage) Transfers() store.TransferRepository {
+	return s.transfer
+}
+
+// WithTx runs f in transaction of underlying storage. Repositories bound to transaction don't use cache, and values
+// changed by transaction are invalidated after commit.
+func (s *storage) WithTx(ctx context.Context, f func(tx store.Storage) error) error {
+	if s.pending != nil {
+		return s.Storage.WithTx(ctx, func(tx store.Storage) error {
+			txStorage := newStorage(tx, s.cache, s.ttl, s.logger, s.versions)
+			txStorage.pending = s.pending
+			return f(txStorage)
+		})
+	}
+
+	var pending []string
+	err := s.Storage.WithTx(ctx, func(tx store.Storage) error {
+		// transaction could be retried, so keys of failed attempt are dropped
+		pending = nil
+		txStorage := newStorage(tx, s.cache, s.ttl, s.logger, s.versions)
+		txStorage.pending = &pending
+		return f(txStorage)
+	})
+	if err != nil {
+		return err
+	}
+	s.invalidate(ctx, pending...)
+	return nil
+}
+
+// load decodes value cached with key to v and reports whether it was cached.
+func (s *storage) load(ctx context.Context, key string, v interface{}) bool {
+	data, ok, err := s.cache.Get(ctx, key)
+	if err != nil {
+		metrics.Add(metricErrors, 1)
+		s.logger.Warnf("cache: get %s: %v", key, err)
+		return false
+	}
+	if !ok {
+		metrics.Add(metricMisses, 1)
+		return false
+	}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(v); err != nil {
+		metrics.Add(metricErrors, 1)
+		s.logger.Warnf("cache: decode %s: %v", key, err)
+		return false
+	}
+	metrics.Add(metricHits, 1)
+	return true
+}
+
+// generation returns count of invalidations of key; it is passed to save after value is read from underlying storage.
+// Every call must be followed by release.
+func (s *storage) generation(key string) uint64 {
+	s.versions.mu.Lock()
+	defer s.versions.mu.Unlock()
+
+	v, ok := s.versions.keys[key]
+	if !ok {
+		v = new(version)
+		s.versions.keys[key] = v
+	}
+	v.readers++
+	return v.gen
+}
+
+// release finishes read of key started by generation.
+func (s *storage) release(key string) {
+	s.versions.mu.Lock()
+	defer s.versions.mu.Unlock()
+
+	v, ok := s.versions.keys[key]
+	if !ok {
+		return
+	}
+	v.readers--
+	if v.readers <= 0 {
+		delete(s.versions.keys, key)
+	}
+}
+
+// save caches v with key if key was not invalidated since gen.
+func (s *storage) save(ctx context.Context, key string, gen uint64, v interface{}) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		metrics.Add(metricErrors, 1)
+		s.logger.Warnf("cache: encode %s: %v", key, err)
+		return
+	}
+
+	s.versions.mu.Lock()
+	defer s.versions.mu.Unlock()
+
+	if ver, ok := s.versions.keys[key]; !ok || ver.gen != gen {
+		return
+	}
+	if err := s.cache.Set(ctx, key, buf.Bytes(), s.ttl); err != nil {
+		metrics.Add(metricErrors, 1)
+		s.logger.Warnf("cache: set %s: %v", key, err)
+	}
+}
+
+// invalidate removes values cached with keys; keys of transaction are removed after commit.
+func (s *storage) invalidate(ctx context.Context, keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+	if s.pending != nil {
+		*s.pending = append(*s.pending, keys...)
+		return
+	}
+
+	s.versions.mu.Lock()
+	defer s.versions.mu.Unlock()
+
+	// only keys which are being read need new generation
+	for _, key := range keys {
+		if v, ok := s.versions.keys[key]; ok {
+			v.gen++
+		}
+	}
+	metrics.Add(metricInvalidations, int64(len(keys)))
+	if err := s.cache.Delete(ctx, keys...); err != nil {
+		metrics.Add(metricErrors, 1)
+		s.logger.Warnf("cache: delete %v: %v", keys, err)
+	}
+}
+
+// cached reports whether reads of storage could use cache.
+func (s *storage) cached() bool {
+	return s.pending == nil
+}
+
+// balanceKey ...
+func balanceKey(user int) string {
+	return fmt.Sprintf("balance:%d", user)
+}
+
+// withdrawalsKey ...
+func withdrawalsKey(user int) string {
+	return fmt.Sprintf("withdrawals:%d", user)
+}
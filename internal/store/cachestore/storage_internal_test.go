@@ -0,0 +1,43 @@
+package cachestore
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/vlad-marlo/gophermart/internal/store/memstore"
+	"github.com/vlad-marlo/gophermart/pkg/logger"
+)
+
+func TestStorage_Generation(t *testing.T) {
+	ctx := context.Background()
+
+	log := logrus.New()
+	log.Out = io.Discard
+	c := NewLRU(10)
+	s := New(logger.GetLoggerByEntry(logrus.NewEntry(log)), memstore.New(), c, time.Minute).(*storage)
+
+	cached := func(key string) bool {
+		_, ok, _ := c.Get(ctx, key)
+		return ok
+	}
+
+	// invalidation of other key doesn't prevent caching of value
+	gen := s.generation(balanceKey(1))
+	s.invalidate(ctx, balanceKey(2), withdrawalsKey(1))
+	s.save(ctx, balanceKey(1), gen, 1)
+	s.release(balanceKey(1))
+	assert.True(t, cached(balanceKey(1)))
+
+	// value read before invalidation of its key is not cached
+	gen = s.generation(balanceKey(2))
+	s.invalidate(ctx, balanceKey(2))
+	s.save(ctx, balanceKey(2), gen, 2)
+	s.release(balanceKey(2))
+	assert.False(t, cached(balanceKey(2)))
+
+	assert.Empty(t, s.versions.keys, "versions of keys which are not read are kept")
+}
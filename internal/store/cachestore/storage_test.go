@@ -0,0 +1,183 @@
+package cachestore_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/internal/store"
+	"github.com/vlad-marlo/gophermart/internal/store/cachestore"
+	"github.com/vlad-marlo/gophermart/internal/store/memstore"
+	"github.com/vlad-marlo/gophermart/internal/store/storetest"
+	"github.com/vlad-marlo/gophermart/pkg/logger"
+)
+
+const (
+	userLogin1 = "first"
+	userLogin2 = "second"
+	orderNum1  = model.OrderNumber("79927398713")
+)
+
+func testLogger(t *testing.T) logger.Logger {
+	t.Helper()
+
+	log := logrus.New()
+	log.Out = io.Discard
+	return logger.GetLoggerByEntry(logrus.NewEntry(log))
+}
+
+func TestStorage(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) (store.Storage, func()) {
+		s := cachestore.New(testLogger(t), memstore.New(), cachestore.NewLRU(100), time.Minute)
+		return s, s.Close
+	})
+}
+
+// testStorage returns cached storage and underlying storage, which changes are not seen by cache.
+func testStorage(t *testing.T) (cached, underlying store.Storage, users []*model.User) {
+	t.Helper()
+	ctx := context.Background()
+
+	underlying = memstore.New()
+	for _, login := range []string{userLogin1, userLogin2} {
+		u := model.TestUser(t, login)
+		require.NoError(t, underlying.User().Create(ctx, u))
+		require.NoError(t, underlying.User().IncrementBalance(ctx, u.ID, 100))
+		users = append(users, u)
+	}
+	cached = cachestore.New(testLogger(t), underlying, cachestore.NewLRU(100), time.Minute)
+	return cached, underlying, users
+}
+
+func assertBalance(t *testing.T, s store.Storage, user int, want float64) {
+	t.Helper()
+
+	b, err := s.User().GetBalance(context.Background(), user)
+	require.NoError(t, err)
+	assert.Equal(t, want, b.Current)
+}
+
+func TestStorage_CachesBalance(t *testing.T) {
+	ctx := context.Background()
+	cached, underlying, users := testStorage(t)
+	u := users[0]
+
+	assertBalance(t, cached, u.ID, 100)
+	// change which bypasses cache is not seen until value is invalidated
+	require.NoError(t, underlying.User().IncrementBalance(ctx, u.ID, 1))
+	assertBalance(t, cached, u.ID, 100)
+
+	require.NoError(t, cached.User().IncrementBalance(ctx, u.ID, 1))
+	assertBalance(t, cached, u.ID, 102)
+}
+
+func TestStorage_Invalidation(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		write func(t *testing.T, s store.Storage, users []*model.User)
+		want  [2]float64
+	}{
+		{
+			name: "withdraw",
+			write: func(t *testing.T, s store.Storage, users []*model.User) {
+				require.NoError(t, s.Withdraws().Withdraw(ctx, users[0].ID, model.TestWithdraw(t, orderNum1, 10)))
+			},
+			want: [2]float64{90, 100},
+		},
+		{
+			name: "accrual crediting",
+			write: func(t *testing.T, s store.Storage, users []*model.User) {
+				require.NoError(t, s.Order().Register(ctx, users[0].ID, orderNum1))
+				credited, err := s.Order().ChangeStatusAndIncrementUserBalance(ctx, users[0].ID, &model.OrderInAccrual{
+					Number:  orderNum1,
+					Status:  model.StatusProcessed,
+					Accrual: 50,
+				})
+				require.NoError(t, err)
+				require.True(t, credited)
+			},
+			want: [2]float64{150, 100},
+		},
+		{
+			name: "transfer",
+			write: func(t *testing.T, s store.Storage, users []*model.User) {
+				_, err := s.Transfers().Transfer(ctx, users[0].ID, &model.Transfer{Login: userLogin2, Sum: 30}, 0)
+				require.NoError(t, err)
+			},
+			want: [2]float64{70, 130},
+		},
+		{
+			name: "transaction",
+			write: func(t *testing.T, s store.Storage, users []*model.User) {
+				err := s.WithTx(ctx, func(tx store.Storage) error {
+					if err := tx.User().IncrementBalance(ctx, users[0].ID, 5); err != nil {
+						return err
+					}
+					// value is not invalidated before commit
+					assertBalance(t, s, users[0].ID, 100)
+					return tx.User().IncrementBalance(ctx, users[1].ID, 5)
+				})
+				require.NoError(t, err)
+			},
+			want: [2]float64{105, 105},
+		},
+		{
+			name: "rolled back transaction",
+			write: func(t *testing.T, s store.Storage, users []*model.User) {
+				err := s.WithTx(ctx, func(tx store.Storage) error {
+					if err := tx.User().IncrementBalance(ctx, users[0].ID, 5); err != nil {
+						return err
+					}
+					return assert.AnError
+				})
+				require.True(t, errors.Is(err, assert.AnError))
+			},
+			want: [2]float64{100, 100},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cached, _, users := testStorage(t)
+			for _, u := range users {
+				assertBalance(t, cached, u.ID, 100)
+			}
+
+			tt.write(t, cached, users)
+
+			for i, u := range users {
+				assertBalance(t, cached, u.ID, tt.want[i])
+			}
+		})
+	}
+}
+
+func TestStorage_CachesWithdrawals(t *testing.T) {
+	ctx := context.Background()
+	cached, underlying, users := testStorage(t)
+	u := users[0]
+
+	_, err := cached.Withdraws().GetAllByUser(ctx, u.ID)
+	require.ErrorIs(t, err, store.ErrNoContent, "absence of withdrawals is not cached")
+
+	require.NoError(t, cached.Withdraws().Withdraw(ctx, u.ID, model.TestWithdraw(t, orderNum1, 10)))
+	res, err := cached.Withdraws().GetAllByUser(ctx, u.ID)
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+
+	require.NoError(t, underlying.Withdraws().Withdraw(ctx, u.ID, model.TestWithdraw(t, "12345678903", 10)))
+	cachedRes, err := cached.Withdraws().GetAllByUser(ctx, u.ID)
+	require.NoError(t, err)
+	require.Len(t, cachedRes, 1, "withdrawal which bypasses cache is not seen until value is invalidated")
+	assert.Equal(t, orderNum1, cachedRes[0].Order)
+	assert.True(t, res[0].ProcessedAt.Equal(cachedRes[0].ProcessedAt))
+	assert.Equal(t, res[0].ProcessedAtString, cachedRes[0].ProcessedAtString)
+}
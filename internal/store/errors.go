@@ -10,4 +10,9 @@ var (
 	ErrAlreadyRegisteredByAnotherUser = errors.New("registered by another user")
 	ErrNoContent                      = errors.New("no data to return")
 	ErrPaymentRequired                = errors.New("payment required")
+	ErrRecipientNotFound              = errors.New("recipient not found")
+	ErrDailyLimitExceeded             = errors.New("daily limit exceeded")
+	ErrIdempotencyKeyReused           = errors.New("idempotency key is used by other transfer")
+	ErrLeaseLost                      = errors.New("order is not leased by owner")
+	ErrNotCancellable                 = errors.New("order could not be cancelled")
 )
@@ -0,0 +1,445 @@
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/internal/store"
+)
+
+type orderRepository struct {
+	s *storage
+}
+
+// active returns not cancelled order with such number. Caller must hold the lock.
+func (o *orderRepository) active(number model.OrderNumber) *orderRecord {
+	for _, rec := range o.s.orders {
+		if rec.number == number && rec.status != model.StatusCancelled {
+			return rec
+		}
+	}
+	return nil
+}
+
+// polled reports whether order must be checked in accrual system. Caller must hold the lock.
+func (o *orderRepository) polled(rec *orderRecord, now time.Time) bool {
+	return (rec.status == model.StatusNew || rec.status == model.StatusProcessing) &&
+		!rec.nextCheckAt.After(now) &&
+		!rec.needsAttention &&
+		rec.deadLetteredAt.IsZero()
+}
+
+// register inserts new order and notifies listeners about it. Caller must hold the lock.
+func (o *orderRepository) register(user int, number model.OrderNumber) {
+	now := o.s.now()
+	o.s.lastPK++
+	rec := &orderRecord{
+		pk:          o.s.lastPK,
+		number:      number,
+		user:        user,
+		status:      model.StatusNew,
+		createdAt:   now,
+		nextCheckAt: now,
+	}
+	rec.addHistory(now)
+	o.s.orders = append(o.s.orders, rec)
+	o.s.notify(rec.toPoll())
+}
+
+func (o *orderRepository) Register(_ context.Context, user int, number model.OrderNumber) error {
+	o.s.lock()
+	defer o.s.unlock()
+
+	if rec := o.active(number); rec != nil {
+		if rec.user == user {
+			return store.ErrAlreadyRegisteredByUser
+		}
+		return store.ErrAlreadyRegisteredByAnotherUser
+	}
+
+	o.register(user, number)
+	return nil
+}
+
+func (o *orderRepository) RegisterBatch(_ context.Context, user int, numbers []model.OrderNumber) ([]*model.OrderRegistration, error) {
+	o.s.lock()
+	defer o.s.unlock()
+
+	res := make([]*model.OrderRegistration, 0, len(numbers))
+	for _, number := range numbers {
+		r := &model.OrderRegistration{
+			Number: number,
+			Result: model.RegistrationAccepted,
+		}
+
+		if rec := o.active(number); rec != nil {
+			r.Result = model.RegistrationDuplicateOther
+			if rec.user == user {
+				r.Result = model.RegistrationDuplicateOwn
+			}
+		} else {
+			o.register(user, number)
+		}
+
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+func (o *orderRepository) GetAllByUser(_ context.Context, user int) (orders []*model.Order, err error) {
+	o.s.rlock()
+	defer o.s.runlock()
+
+	for _, rec := range o.s.orders {
+		if rec.user != user || rec.status == model.StatusCancelled {
+			continue
+		}
+		orders = append(orders, &model.Order{
+			Number:     rec.number,
+			Status:     rec.status,
+			Accrual:    rec.accrual,
+			UploadedAt: rec.createdAt.Format(time.RFC3339),
+		})
+	}
+
+	if len(orders) == 0 {
+		return nil, store.ErrNoContent
+	}
+	return orders, nil
+}
+
+func (o *orderRepository) ChangeStatus(_ context.Context, user int, m *model.OrderInAccrual) error {
+	o.s.lock()
+	defer o.s.unlock()
+
+	if _, err := o.updateStatus(user, m); err != nil {
+		return fmt.Errorf("update status: %w", err)
+	}
+	return nil
+}
+
+// updateStatus has the same semantics as updateStatus of sqlstore. Caller must hold the lock.
+func (o *orderRepository) updateStatus(user int, m *model.OrderInAccrual) (applied bool, err error) {
+	rec := o.active(m.Number)
+	if rec == nil || rec.user != user {
+		return false, store.ErrNoContent
+	}
+
+	if model.IsFinalStatus(rec.status) && rec.status == m.Status {
+		return false, nil
+	}
+	if err := model.ValidateTransition(rec.status, m.Status); err != nil {
+		return false, err
+	}
+
+	changed := rec.status != m.Status
+	rec.status = m.Status
+	rec.accrual = m.Accrual
+	if changed {
+		rec.addHistory(o.s.now())
+	}
+	return true, nil
+}
+
+func (o *orderRepository) GetActiveByNumber(_ context.Context, number model.OrderNumber) (*model.OrderInPoll, error) {
+	o.s.rlock()
+	defer o.s.runlock()
+
+	rec := o.active(number)
+	if rec == nil {
+		return nil, store.ErrNoContent
+	}
+	return rec.toPoll(), nil
+}
+
+func (o *orderRepository) GetByNumber(_ context.Context, user int, number model.OrderNumber) (*model.Order, error) {
+	o.s.rlock()
+	defer o.s.runlock()
+
+	rec := o.active(number)
+	if rec == nil || rec.user != user {
+		return nil, store.ErrNoContent
+	}
+
+	order := &model.Order{
+		Number:     rec.number,
+		Status:     rec.status,
+		Accrual:    rec.accrual,
+		UploadedAt: rec.createdAt.Format(time.RFC3339),
+	}
+	for _, c := range rec.history {
+		change := *c
+		order.History = append(order.History, &change)
+	}
+	return order, nil
+}
+
+func (o *orderRepository) Cancel(_ context.Context, user int, number model.OrderNumber) error {
+	o.s.lock()
+	defer o.s.unlock()
+
+	rec := o.active(number)
+	if rec == nil || rec.user != user {
+		return store.ErrNoContent
+	}
+
+	if !model.CanTransition(rec.status, model.StatusCancelled) {
+		return store.ErrNotCancellable
+	}
+
+	rec.status = model.StatusCancelled
+	rec.addHistory(o.s.now())
+	return nil
+}
+
+func (o *orderRepository) ListenRegistered(ctx context.Context, orders chan<- *model.OrderInPoll) error {
+	l := make(chan *model.OrderInPoll, 64)
+
+	o.s.lock()
+	o.s.listeners[l] = struct{}{}
+	o.s.unlock()
+
+	defer func() {
+		o.s.lock()
+		delete(o.s.listeners, l)
+		o.s.unlock()
+	}()
+
+	for {
+		select {
+		case order := <-l:
+			select {
+			case orders <- order:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (o *orderRepository) ClaimUnprocessedOrders(
+	_ context.Context,
+	owner string,
+	limit int,
+	lease time.Duration,
+) (res []*model.OrderInPoll, err error) {
+	o.s.lock()
+	defer o.s.unlock()
+
+	now := o.s.now()
+	for _, rec := range o.byNextCheck() {
+		if len(res) >= limit {
+			break
+		}
+		if !o.polled(rec, now) || !rec.claimable(owner, now) {
+			continue
+		}
+		rec.lockedBy = owner
+		rec.lockedUntil = now.Add(lease)
+		res = append(res, rec.toPoll())
+	}
+	return res, nil
+}
+
+func (o *orderRepository) ClaimOrder(
+	_ context.Context,
+	owner string,
+	number model.OrderNumber,
+	lease time.Duration,
+) (bool, error) {
+	o.s.lock()
+	defer o.s.unlock()
+
+	now := o.s.now()
+	rec := o.active(number)
+	if rec == nil ||
+		(rec.status != model.StatusNew && rec.status != model.StatusProcessing) ||
+		rec.needsAttention ||
+		!rec.deadLetteredAt.IsZero() ||
+		!rec.claimable(owner, now) {
+		return false, nil
+	}
+
+	rec.lockedBy = owner
+	rec.lockedUntil = now.Add(lease)
+	return true, nil
+}
+
+func (o *orderRepository) Postpone(_ context.Context, owner string, number model.OrderNumber, delay time.Duration) error {
+	o.s.lock()
+	defer o.s.unlock()
+
+	rec := o.active(number)
+	if rec == nil || rec.lockedBy != owner {
+		return store.ErrLeaseLost
+	}
+	rec.attempts++
+	rec.nextCheckAt = o.s.now().Add(delay)
+	rec.lockedBy = ""
+	rec.lockedUntil = time.Time{}
+	return nil
+}
+
+func (o *orderRepository) Reschedule(_ context.Context, owner string, number model.OrderNumber, delay time.Duration) error {
+	o.s.lock()
+	defer o.s.unlock()
+
+	rec := o.active(number)
+	if rec == nil || rec.lockedBy != owner {
+		return store.ErrLeaseLost
+	}
+	rec.nextCheckAt = o.s.now().Add(delay)
+	rec.lockedBy = ""
+	rec.lockedUntil = time.Time{}
+	return nil
+}
+
+func (o *orderRepository) MarkNeedsAttention(_ context.Context, owner string, number model.OrderNumber) error {
+	o.s.lock()
+	defer o.s.unlock()
+
+	rec := o.active(number)
+	if rec == nil || rec.lockedBy != owner {
+		return store.ErrLeaseLost
+	}
+	rec.needsAttention = true
+	return nil
+}
+
+func (o *orderRepository) RecordFailure(
+	_ context.Context,
+	owner string,
+	number model.OrderNumber,
+	reason, payload string,
+	maxFailures int,
+) (deadLettered bool, err error) {
+	o.s.lock()
+	defer o.s.unlock()
+
+	rec := o.active(number)
+	if rec == nil || (rec.status != model.StatusNew && rec.status != model.StatusProcessing) || rec.lockedBy != owner {
+		return false, store.ErrLeaseLost
+	}
+
+	rec.failures++
+	rec.lastError = reason
+	rec.lastPayload = payload
+	if maxFailures > 0 && rec.failures >= maxFailures && rec.deadLetteredAt.IsZero() {
+		rec.deadLetteredAt = o.s.now()
+	}
+	return !rec.deadLetteredAt.IsZero(), nil
+}
+
+func (o *orderRepository) GetDeadLettered(_ context.Context) (res []*model.DeadLetter, err error) {
+	o.s.rlock()
+	defer o.s.runlock()
+
+	var recs []*orderRecord
+	for _, rec := range o.s.orders {
+		if !rec.deadLetteredAt.IsZero() && (rec.status == model.StatusNew || rec.status == model.StatusProcessing) {
+			recs = append(recs, rec)
+		}
+	}
+	sort.SliceStable(recs, func(i, j int) bool {
+		return recs[i].deadLetteredAt.Before(recs[j].deadLetteredAt)
+	})
+
+	for _, rec := range recs {
+		res = append(res, &model.DeadLetter{
+			Number:         rec.number,
+			User:           rec.user,
+			Status:         rec.status,
+			Failures:       rec.failures,
+			LastError:      rec.lastError,
+			LastPayload:    rec.lastPayload,
+			DeadLetteredAt: rec.deadLetteredAt.Format(time.RFC3339),
+		})
+	}
+
+	if len(res) == 0 {
+		return nil, store.ErrNoContent
+	}
+	return res, nil
+}
+
+func (o *orderRepository) RetryDeadLettered(_ context.Context, number model.OrderNumber) error {
+	o.s.lock()
+	defer o.s.unlock()
+
+	rec := o.active(number)
+	if rec == nil ||
+		(rec.status != model.StatusNew && rec.status != model.StatusProcessing) ||
+		rec.deadLetteredAt.IsZero() {
+		return store.ErrNoContent
+	}
+
+	rec.failures = 0
+	rec.deadLetteredAt = time.Time{}
+	rec.needsAttention = false
+	rec.nextCheckAt = o.s.now()
+	rec.lockedBy = ""
+	rec.lockedUntil = time.Time{}
+	return nil
+}
+
+func (o *orderRepository) ChangeStatusAndIncrementUserBalance(
+	_ context.Context,
+	user int,
+	m *model.OrderInAccrual,
+) (credited bool, err error) {
+	o.s.lock()
+	defer o.s.unlock()
+
+	applied, err := o.updateStatus(user, m)
+	if err != nil {
+		return false, fmt.Errorf("update order: %w", err)
+	}
+	// order was already finalized and credited
+	if !applied {
+		return false, nil
+	}
+
+	if u, ok := o.s.users[user]; ok {
+		u.balance += m.Accrual
+	}
+	return true, nil
+}
+
+// byNextCheck returns orders sorted by time of next check. Caller must hold the lock.
+func (o *orderRepository) byNextCheck() []*orderRecord {
+	recs := make([]*orderRecord, len(o.s.orders))
+	copy(recs, o.s.orders)
+	sort.SliceStable(recs, func(i, j int) bool {
+		return recs[i].nextCheckAt.Before(recs[j].nextCheckAt)
+	})
+	return recs
+}
+
+// addHistory writes record about current status of order.
+func (r *orderRecord) addHistory(at time.Time) {
+	r.history = append(r.history, &model.OrderStatusChange{
+		Status:    r.status,
+		Accrual:   r.accrual,
+		ChangedAt: at.Format(time.RFC3339),
+	})
+}
+
+// claimable reports whether lease of order is expired or is owned by owner.
+func (r *orderRecord) claimable(owner string, now time.Time) bool {
+	return r.lockedUntil.IsZero() || r.lockedUntil.Before(now) || r.lockedBy == owner
+}
+
+func (r *orderRecord) toPoll() *model.OrderInPoll {
+	return &model.OrderInPoll{
+		Number:     r.number,
+		Status:     r.status,
+		User:       r.user,
+		Attempts:   r.attempts,
+		UploadedAt: r.createdAt,
+	}
+}
@@ -0,0 +1,224 @@
+package memstore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/internal/store"
+)
+
+type (
+	// storage keeps all data in memory. It has the same semantics as sqlstore and is used in tests and demos; all
+	// repositories share one lock, so every method is atomic.
+	storage struct {
+		*state
+
+		// tx is true if storage is bound to transaction which holds lock of state
+		tx bool
+		// pending are orders registered in transaction; listeners are notified about them after commit
+		pending *[]*model.OrderInPoll
+
+		// repositories
+		user     store.UserRepository
+		order    store.OrderRepository
+		withdraw store.WithdrawRepository
+		transfer store.TransferRepository
+	}
+	// state is data shared by storage and its transactions
+	state struct {
+		mu sync.RWMutex
+		data
+		listeners map[chan *model.OrderInPoll]struct{}
+
+		// now returns current time; it is replaced in tests
+		now func() time.Time
+	}
+	// data is everything what is restored on rollback of transaction
+	data struct {
+		users      map[int]*userRecord
+		logins     map[string]int
+		orders     []*orderRecord
+		withdraws  []*withdrawRecord
+		transfers  []*transferRecord
+		lastUserID int
+		lastPK     int
+	}
+	userRecord struct {
+		id       int
+		login    string
+		password string
+		balance  float64
+	}
+	orderRecord struct {
+		pk        int
+		number    model.OrderNumber
+		user      int
+		status    string
+		accrual   float64
+		createdAt time.Time
+		history   []*model.OrderStatusChange
+
+		nextCheckAt    time.Time
+		attempts       int
+		needsAttention bool
+		lockedBy       string
+		lockedUntil    time.Time
+
+		failures       int
+		lastError      string
+		lastPayload    string
+		deadLetteredAt time.Time
+	}
+	withdrawRecord struct {
+		user        int
+		order       model.OrderNumber
+		sum         float64
+		processedAt time.Time
+	}
+	transferRecord struct {
+		sender         int
+		recipient      int
+		amount         float64
+		idempotencyKey string
+		createdAt      time.Time
+	}
+)
+
+// New returns empty in-memory storage.
+func New() store.Storage {
+	return newStorage(&state{
+		data: data{
+			users:  make(map[int]*userRecord),
+			logins: make(map[string]int),
+		},
+		listeners: make(map[chan *model.OrderInPoll]struct{}),
+		now:       time.Now,
+	}, false, nil)
+}
+
+// newStorage ...
+func newStorage(st *state, tx bool, pending *[]*model.OrderInPoll) *storage {
+	s := &storage{
+		state:   st,
+		tx:      tx,
+		pending: pending,
+	}
+	s.user = &userRepository{s}
+	s.order = &orderRepository{s}
+	s.withdraw = &withdrawRepository{s}
+	s.transfer = &transferRepository{s}
+	return s
+}
+
+// User ...
+func (s *storage) User() store.UserRepository {
+	return s.user
+}
+
+// Order ...
+func (s *storage) Order() store.OrderRepository {
+	return s.order
+}
+
+// Withdraws ...
+func (s *storage) Withdraws() store.WithdrawRepository {
+	return s.withdraw
+}
+
+// Transfers ...
+func (s *storage) Transfers() store.TransferRepository {
+	return s.transfer
+}
+
+// WithTx holds lock of storage while f is running, so transactions are serialized and never retried. Data is restored
+// from snapshot if f returns error.
+func (s *storage) WithTx(_ context.Context, f func(tx store.Storage) error) error {
+	s.lock()
+	defer s.unlock()
+
+	snapshot := s.data.clone()
+	var pending []*model.OrderInPoll
+	if err := f(newStorage(s.state, true, &pending)); err != nil {
+		s.data = snapshot
+		return err
+	}
+	s.notify(pending...)
+	return nil
+}
+
+// Close ...
+func (s *storage) Close() {}
+
+// lock locks state for writing unless storage is bound to transaction which already holds the lock.
+func (s *storage) lock() {
+	if !s.tx {
+		s.mu.Lock()
+	}
+}
+
+// unlock ...
+func (s *storage) unlock() {
+	if !s.tx {
+		s.mu.Unlock()
+	}
+}
+
+// rlock locks state for reading unless storage is bound to transaction which already holds the lock.
+func (s *storage) rlock() {
+	if !s.tx {
+		s.mu.RLock()
+	}
+}
+
+// runlock ...
+func (s *storage) runlock() {
+	if !s.tx {
+		s.mu.RUnlock()
+	}
+}
+
+// notify sends registered orders to listeners or defers it until commit of transaction. Caller must hold the lock.
+func (s *storage) notify(orders ...*model.OrderInPoll) {
+	if s.pending != nil {
+		*s.pending = append(*s.pending, orders...)
+		return
+	}
+
+	for l := range s.listeners {
+		for _, o := range orders {
+			// listener which is not keeping up loses notification; order is still found by polling
+			select {
+			case l <- o:
+			default:
+			}
+		}
+	}
+}
+
+// clone returns deep copy of mutable data; withdraw and transfer records are never changed, so they are shared.
+func (d data) clone() data {
+	c := data{
+		users:      make(map[int]*userRecord, len(d.users)),
+		logins:     make(map[string]int, len(d.logins)),
+		orders:     make([]*orderRecord, 0, len(d.orders)),
+		withdraws:  append([]*withdrawRecord(nil), d.withdraws...),
+		transfers:  append([]*transferRecord(nil), d.transfers...),
+		lastUserID: d.lastUserID,
+		lastPK:     d.lastPK,
+	}
+	for id, u := range d.users {
+		u := *u
+		c.users[id] = &u
+	}
+	for login, id := range d.logins {
+		c.logins[login] = id
+	}
+	for _, o := range d.orders {
+		o := *o
+		o.history = append([]*model.OrderStatusChange(nil), o.history...)
+		c.orders = append(c.orders, &o)
+	}
+	return c
+}
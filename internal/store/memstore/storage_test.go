@@ -0,0 +1,16 @@
+package memstore_test
+
+import (
+	"testing"
+
+	"github.com/vlad-marlo/gophermart/internal/store"
+	"github.com/vlad-marlo/gophermart/internal/store/memstore"
+	"github.com/vlad-marlo/gophermart/internal/store/storetest"
+)
+
+func TestStorage(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) (store.Storage, func()) {
+		s := memstore.New()
+		return s, s.Close
+	})
+}
@@ -0,0 +1,107 @@
+package memstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/internal/store"
+)
+
+type transferRepository struct {
+	s *storage
+}
+
+func (r *transferRepository) Transfer(_ context.Context, user int, t *model.Transfer, dailyLimit float64) (int, error) {
+	if t.Sum <= 0 {
+		return 0, store.ErrIncorrectData
+	}
+
+	r.s.lock()
+	defer r.s.unlock()
+
+	if t.IdempotencyKey != "" {
+		for _, rec := range r.s.transfers {
+			if rec.sender != user || rec.idempotencyKey != t.IdempotencyKey {
+				continue
+			}
+			if r.s.users[rec.recipient].login != t.Login || rec.amount != t.Sum {
+				return 0, store.ErrIdempotencyKeyReused
+			}
+			t.ProcessedAt = rec.createdAt
+			return rec.recipient, nil
+		}
+	}
+
+	recipient, ok := r.s.logins[t.Login]
+	if !ok {
+		return 0, store.ErrRecipientNotFound
+	}
+	if recipient == user {
+		return 0, store.ErrIncorrectData
+	}
+
+	sender, ok := r.s.users[user]
+	if !ok {
+		return 0, store.ErrNoContent
+	}
+
+	now := r.s.now()
+	if dailyLimit > 0 {
+		dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		var sent float64
+		for _, rec := range r.s.transfers {
+			if rec.sender == user && !rec.createdAt.Before(dayStart) {
+				sent += rec.amount
+			}
+		}
+		if sent+t.Sum > dailyLimit {
+			return 0, store.ErrDailyLimitExceeded
+		}
+	}
+
+	if sender.balance < t.Sum {
+		return 0, store.ErrPaymentRequired
+	}
+
+	sender.balance -= t.Sum
+	r.s.users[recipient].balance += t.Sum
+	r.s.transfers = append(r.s.transfers, &transferRecord{
+		sender:         user,
+		recipient:      recipient,
+		amount:         t.Sum,
+		idempotencyKey: t.IdempotencyKey,
+		createdAt:      now,
+	})
+	t.ProcessedAt = now
+	return recipient, nil
+}
+
+func (r *transferRepository) GetAllByUser(_ context.Context, user int) (res []*model.Transfer, err error) {
+	r.s.rlock()
+	defer r.s.runlock()
+
+	for _, rec := range r.s.transfers {
+		t := &model.Transfer{
+			Sum:         rec.amount,
+			ProcessedAt: rec.createdAt,
+		}
+		switch user {
+		case rec.sender:
+			t.Direction = model.TransferOutgoing
+			t.Login = r.s.users[rec.recipient].login
+		case rec.recipient:
+			t.Direction = model.TransferIncoming
+			t.Login = r.s.users[rec.sender].login
+		default:
+			continue
+		}
+		t.ToRepresentation()
+		res = append(res, t)
+	}
+
+	if len(res) == 0 {
+		return nil, store.ErrNoContent
+	}
+	return res, nil
+}
@@ -0,0 +1,96 @@
+package memstore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/internal/store"
+)
+
+type userRepository struct {
+	s *storage
+}
+
+// Create ...
+func (r *userRepository) Create(_ context.Context, u *model.User) error {
+	if err := u.BeforeCreate(); err != nil {
+		return fmt.Errorf("before create: %w", err)
+	}
+
+	r.s.lock()
+	defer r.s.unlock()
+
+	if _, ok := r.s.logins[u.Login]; ok {
+		return store.ErrLoginAlreadyInUse
+	}
+
+	r.s.lastUserID++
+	u.ID = r.s.lastUserID
+	r.s.users[u.ID] = &userRecord{
+		id:       u.ID,
+		login:    u.Login,
+		password: u.EncryptedPassword,
+	}
+	r.s.logins[u.Login] = u.ID
+	return nil
+}
+
+// GetByLogin ...
+func (r *userRepository) GetByLogin(_ context.Context, login string) (*model.User, error) {
+	r.s.rlock()
+	defer r.s.runlock()
+
+	id, ok := r.s.logins[login]
+	if !ok {
+		return nil, store.ErrIncorrectLoginData
+	}
+	return &model.User{
+		ID:                id,
+		Login:             login,
+		EncryptedPassword: r.s.users[id].password,
+	}, nil
+}
+
+// ExistsWithID ...
+func (r *userRepository) ExistsWithID(_ context.Context, id int) bool {
+	r.s.rlock()
+	defer r.s.runlock()
+
+	_, ok := r.s.users[id]
+	return ok
+}
+
+// GetBalance ...
+func (r *userRepository) GetBalance(_ context.Context, id int) (*model.UserBalance, error) {
+	r.s.rlock()
+	defer r.s.runlock()
+
+	u, ok := r.s.users[id]
+	if !ok {
+		return nil, store.ErrNoContent
+	}
+
+	balance := &model.UserBalance{Current: u.balance}
+	for _, w := range r.s.withdraws {
+		if w.user == id {
+			balance.Withdrawn += w.sum
+		}
+	}
+	return balance, nil
+}
+
+// IncrementBalance ...
+func (r *userRepository) IncrementBalance(_ context.Context, id int, add float64) error {
+	if add <= 0 {
+		return fmt.Errorf("check args: %w", store.ErrIncorrectData)
+	}
+
+	r.s.lock()
+	defer r.s.unlock()
+
+	if u, ok := r.s.users[id]; ok {
+		u.balance += add
+	}
+	return nil
+}
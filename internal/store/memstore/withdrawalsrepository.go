@@ -0,0 +1,57 @@
+package memstore
+
+import (
+	"context"
+
+	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/internal/store"
+)
+
+type withdrawRepository struct {
+	s *storage
+}
+
+func (r *withdrawRepository) Withdraw(_ context.Context, user int, w *model.Withdraw) error {
+	r.s.lock()
+	defer r.s.unlock()
+
+	u, ok := r.s.users[user]
+	if !ok {
+		return store.ErrNoContent
+	}
+	if u.balance < w.Sum {
+		return store.ErrPaymentRequired
+	}
+
+	u.balance -= w.Sum
+	r.s.withdraws = append(r.s.withdraws, &withdrawRecord{
+		user:        user,
+		order:       w.Order,
+		sum:         w.Sum,
+		processedAt: r.s.now(),
+	})
+	return nil
+}
+
+func (r *withdrawRepository) GetAllByUser(_ context.Context, user int) (res []*model.Withdraw, err error) {
+	r.s.rlock()
+	defer r.s.runlock()
+
+	for _, rec := range r.s.withdraws {
+		if rec.user != user {
+			continue
+		}
+		w := &model.Withdraw{
+			Order:       rec.order,
+			Sum:         rec.sum,
+			ProcessedAt: rec.processedAt,
+		}
+		w.ToRepresentation()
+		res = append(res, w)
+	}
+
+	if len(res) == 0 {
+		return nil, store.ErrNoContent
+	}
+	return res, nil
+}
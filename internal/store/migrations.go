@@ -0,0 +1,214 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/vlad-marlo/gophermart/pkg/logger"
+)
+
+var (
+	// ErrNoMigrations is returned when there is no applied migration to revert.
+	ErrNoMigrations = errors.New("no applied migrations")
+
+	// migrationNameRe matches name of migration file: <version>_<name>.<up|down>.sql
+	migrationNameRe = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+)
+
+type (
+	// Migrator applies and reverts versioned migrations of database scheme.
+	Migrator interface {
+		// Up applies all not applied migrations and returns count of applied migrations.
+		Up(ctx context.Context) (int, error)
+		// Down reverts last applied migration and returns it. Returns ErrNoMigrations if there is no applied
+		// migrations.
+		Down(ctx context.Context) (*MigrationState, error)
+		// Status returns states of all known migrations.
+		Status(ctx context.Context) ([]*MigrationState, error)
+		// Close closes connection to database if it was opened by migrator.
+		Close()
+	}
+	// MigrationDialect is part of migrator which depends on database.
+	MigrationDialect interface {
+		// Lock runs f while migrations lock of database is held, so concurrently started instances of application
+		// don't migrate database at the same time. Migrations are listed, applied and reverted through c.
+		Lock(ctx context.Context, f func(c MigrationConn) error) error
+	}
+	// MigrationConn is connection to database which holds migrations lock.
+	MigrationConn interface {
+		// Applied returns versions of applied migrations with time of their applying.
+		Applied(ctx context.Context) (map[int]time.Time, error)
+		// Apply applies migration and marks it as applied.
+		Apply(ctx context.Context, m *Migration) error
+		// Revert reverts migration and marks it as not applied.
+		Revert(ctx context.Context, m *Migration) error
+	}
+	// MigrationRunner implements Up, Down and Status of Migrator with dialect of database.
+	MigrationRunner struct {
+		dialect    MigrationDialect
+		logger     logger.Logger
+		migrations []*Migration
+	}
+	// Migration is numbered change of database scheme
+	Migration struct {
+		Version int
+		Name    string
+		Up      string
+		Down    string
+	}
+	// MigrationState is state of migration in database
+	MigrationState struct {
+		Version int
+		Name    string
+		// AppliedAt is nil if migration is not applied
+		AppliedAt *time.Time
+	}
+)
+
+// LoadMigrations returns migrations from dir of fsys sorted by version. Every migration must have up and down files.
+func LoadMigrations(fsys fs.FS, dir string) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("read dir: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		m := migrationNameRe.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("bad migration file name: %s", e.Name())
+		}
+		version, err := strconv.Atoi(m[1])
+		if err != nil {
+			return nil, fmt.Errorf("parse version of %s: %w", e.Name(), err)
+		}
+
+		data, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("read file: %w", err)
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has different names: %s and %s", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(data)
+		} else {
+			mig.Down = string(data)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have up and down files", m.Version, m.Name)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// NewMigrationRunner returns runner of migrations sorted by version.
+func NewMigrationRunner(d MigrationDialect, l logger.Logger, migrations []*Migration) *MigrationRunner {
+	return &MigrationRunner{
+		dialect:    d,
+		logger:     l,
+		migrations: migrations,
+	}
+}
+
+// Up applies all not applied migrations and returns count of applied migrations.
+func (r *MigrationRunner) Up(ctx context.Context) (int, error) {
+	var applied []*Migration
+	err := r.dialect.Lock(ctx, func(c MigrationConn) error {
+		// migrations could be applied by other instance before lock was taken
+		done, err := c.Applied(ctx)
+		if err != nil {
+			return err
+		}
+
+		applied = applied[:0]
+		for _, mig := range r.migrations {
+			if _, ok := done[mig.Version]; ok {
+				continue
+			}
+			if err := c.Apply(ctx, mig); err != nil {
+				return err
+			}
+			applied = append(applied, mig)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, mig := range applied {
+		r.logger.Infof("applied migration %d_%s", mig.Version, mig.Name)
+	}
+	return len(applied), nil
+}
+
+// Down reverts last applied migration and returns it. Returns ErrNoMigrations if there is no applied migrations.
+func (r *MigrationRunner) Down(ctx context.Context) (*MigrationState, error) {
+	var reverted *Migration
+	err := r.dialect.Lock(ctx, func(c MigrationConn) error {
+		applied, err := c.Applied(ctx)
+		if err != nil {
+			return err
+		}
+
+		for i := len(r.migrations) - 1; i >= 0; i-- {
+			mig := r.migrations[i]
+			if _, ok := applied[mig.Version]; !ok {
+				continue
+			}
+			reverted = mig
+			return c.Revert(ctx, mig)
+		}
+		return ErrNoMigrations
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.logger.Infof("reverted migration %d_%s", reverted.Version, reverted.Name)
+	return &MigrationState{Version: reverted.Version, Name: reverted.Name}, nil
+}
+
+// Status returns states of all known migrations.
+func (r *MigrationRunner) Status(ctx context.Context) (res []*MigrationState, err error) {
+	var applied map[int]time.Time
+	err = r.dialect.Lock(ctx, func(c MigrationConn) (err error) {
+		applied, err = c.Applied(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, mig := range r.migrations {
+		s := &MigrationState{Version: mig.Version, Name: mig.Name}
+		if t, ok := applied[mig.Version]; ok {
+			t := t
+			s.AppliedAt = &t
+		}
+		res = append(res, s)
+	}
+	return res, nil
+}
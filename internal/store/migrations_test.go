@@ -0,0 +1,148 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vlad-marlo/gophermart/internal/store"
+	"github.com/vlad-marlo/gophermart/pkg/logger"
+)
+
+// fakeDialect keeps applied migrations in memory; failing migration is not applied.
+type fakeDialect struct {
+	applied map[int]time.Time
+	failing int
+	locks   int
+}
+
+// Lock ...
+func (d *fakeDialect) Lock(_ context.Context, f func(c store.MigrationConn) error) error {
+	d.locks++
+	return f(d)
+}
+
+// Applied ...
+func (d *fakeDialect) Applied(context.Context) (map[int]time.Time, error) {
+	res := make(map[int]time.Time, len(d.applied))
+	for v, t := range d.applied {
+		res[v] = t
+	}
+	return res, nil
+}
+
+// Apply ...
+func (d *fakeDialect) Apply(_ context.Context, m *store.Migration) error {
+	if m.Version == d.failing {
+		return errors.New("failed")
+	}
+	d.applied[m.Version] = time.Now()
+	return nil
+}
+
+// Revert ...
+func (d *fakeDialect) Revert(_ context.Context, m *store.Migration) error {
+	delete(d.applied, m.Version)
+	return nil
+}
+
+func TestLoadMigrations(t *testing.T) {
+	file := &fstest.MapFile{Data: []byte("SELECT 1;")}
+
+	tests := []struct {
+		name    string
+		fs      fstest.MapFS
+		want    []int
+		wantErr bool
+	}{
+		{
+			name: "sorted by version",
+			fs: fstest.MapFS{
+				"m/0010_second.up.sql":   file,
+				"m/0010_second.down.sql": file,
+				"m/0002_first.up.sql":    file,
+				"m/0002_first.down.sql":  file,
+			},
+			want: []int{2, 10},
+		},
+		{
+			name: "without down",
+			fs: fstest.MapFS{
+				"m/0001_first.up.sql": file,
+			},
+			wantErr: true,
+		},
+		{
+			name: "bad name",
+			fs: fstest.MapFS{
+				"m/first.up.sql": file,
+			},
+			wantErr: true,
+		},
+		{
+			name: "different names of one version",
+			fs: fstest.MapFS{
+				"m/0001_first.up.sql":     file,
+				"m/0001_another.down.sql": file,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := store.LoadMigrations(tt.fs, "m")
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			var versions []int
+			for _, m := range migrations {
+				versions = append(versions, m.Version)
+			}
+			assert.Equal(t, tt.want, versions)
+		})
+	}
+}
+
+func TestMigrationRunner(t *testing.T) {
+	defer logger.DeleteLogFolderAndFile(t)
+	ctx := context.Background()
+
+	d := &fakeDialect{applied: map[int]time.Time{}, failing: 3}
+	r := store.NewMigrationRunner(d, logger.GetLogger(), []*store.Migration{
+		{Version: 1, Name: "first"},
+		{Version: 2, Name: "second"},
+		{Version: 3, Name: "third"},
+	})
+
+	_, err := r.Up(ctx)
+	assert.Error(t, err)
+	d.failing = 0
+
+	n, err := r.Up(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n, "migrations applied before failure were applied again")
+
+	states, err := r.Status(ctx)
+	require.NoError(t, err)
+	require.Len(t, states, 3)
+	for _, s := range states {
+		assert.NotNil(t, s.AppliedAt)
+	}
+
+	for _, want := range []int{3, 2, 1} {
+		reverted, err := r.Down(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, want, reverted.Version)
+	}
+	_, err = r.Down(ctx)
+	assert.ErrorIs(t, err, store.ErrNoMigrations)
+	assert.Equal(t, 7, d.locks, "every call must take migrations lock")
+}
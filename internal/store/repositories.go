@@ -2,6 +2,7 @@ package store
 
 import (
 	"context"
+	"time"
 
 	"github.com/vlad-marlo/gophermart/internal/model"
 )
@@ -15,13 +16,18 @@ type (
 		Order() OrderRepository
 		// Withdraws ...
 		Withdraws() WithdrawRepository
+		// Transfers ...
+		Transfers() TransferRepository
+		// WithTx runs f with storage which repositories are bound to single transaction. Transaction is committed if f
+		// returns nil and rolled back otherwise. Transaction failed on serialization failure or deadlock is retried
+		// with f called again, so f must not have side effects besides calls of tx. Nested WithTx is rolled back
+		// without rolling back outer transaction.
+		WithTx(ctx context.Context, f func(tx Storage) error) error
 		// Close ...
 		Close()
 	}
 	// UserRepository ...
 	UserRepository interface {
-		// Migrate database to current scheme
-		Migrate(ctx context.Context) error
 		// Create record about user u to storage; could return error if user already exists or other internal error
 		Create(ctx context.Context, u *model.User) error
 		// GetByLogin search record about user with login and return it if record exits
@@ -34,26 +40,67 @@ type (
 		IncrementBalance(ctx context.Context, id int, add float64) error
 	}
 	OrderRepository interface {
-		// Migrate database to current scheme
-		Migrate(ctx context.Context) error
-		// Register create record about order with unique id which is number
-		Register(ctx context.Context, user, number int) error
+		// Register create record about order with id which is number unique among not cancelled orders
+		Register(ctx context.Context, user int, number model.OrderNumber) error
+		// RegisterBatch registers all numbers in one transaction and returns result of registration for each of them
+		RegisterBatch(ctx context.Context, user int, numbers []model.OrderNumber) ([]*model.OrderRegistration, error)
 		// GetAllByUser returns all orders which was registered by user
 		GetAllByUser(ctx context.Context, user int) (res []*model.Order, err error)
-		// ChangeStatus is changing status of order with id m.Number to status m.Status
+		// GetByNumber returns order registered by user with history of its status changes
+		GetByNumber(ctx context.Context, user int, number model.OrderNumber) (*model.Order, error)
+		// GetActiveByNumber returns not cancelled order with number registered by any user
+		GetActiveByNumber(ctx context.Context, number model.OrderNumber) (*model.OrderInPoll, error)
+		// ChangeStatus is changing status of order with id m.Number to status m.Status and records transition to order
+		// status history. Returns *model.TransitionError if order can't get status m.Status
 		ChangeStatus(ctx context.Context, user int, m *model.OrderInAccrual) error
-		// GetUnprocessedOrders return all orders which status is not final('NEW', 'PROCESSING')
-		GetUnprocessedOrders(ctx context.Context) ([]*model.OrderInPoll, error)
+		// Cancel cancels order registered by user if it was not processed yet('NEW'); number of cancelled order could be
+		// registered again
+		Cancel(ctx context.Context, user int, number model.OrderNumber) error
+		// ListenRegistered sends orders registered by any instance of application to orders until ctx is done or
+		// error occurred
+		ListenRegistered(ctx context.Context, orders chan<- *model.OrderInPoll) error
+		// ClaimUnprocessedOrders leases at most limit due unprocessed orders to owner for lease duration and returns
+		// them. Orders leased by other owners are skipped until lease is expired
+		ClaimUnprocessedOrders(ctx context.Context, owner string, limit int, lease time.Duration) ([]*model.OrderInPoll, error)
+		// ClaimOrder leases unprocessed order to owner or extends owner's lease. Returns false if order is leased by
+		// another owner or doesn't need to be checked
+		ClaimOrder(ctx context.Context, owner string, number model.OrderNumber, lease time.Duration) (bool, error)
+		// Postpone increments count of check attempts of order, releases its lease and schedules next check after delay.
+		// Updates of leased order made by Postpone, MarkNeedsAttention and RecordFailure return ErrLeaseLost if order
+		// is not leased by owner anymore
+		Postpone(ctx context.Context, owner string, number model.OrderNumber, delay time.Duration) error
+		// Reschedule releases lease of order and schedules next check after delay; count of check attempts is not
+		// changed, so it is used when order was not checked because accrual system rejected request in advance
+		Reschedule(ctx context.Context, owner string, number model.OrderNumber, delay time.Duration) error
+		// MarkNeedsAttention excludes order leased by owner from polling until it is checked manually
+		MarkNeedsAttention(ctx context.Context, owner string, number model.OrderNumber) error
+		// RecordFailure saves reason and payload of answer of accrual system from which state of order leased by owner
+		// could not be resolved. Order is dead-lettered and excluded from polling after maxFailures failures; zero
+		// maxFailures disables dead-lettering
+		RecordFailure(ctx context.Context, owner string, number model.OrderNumber, reason, payload string, maxFailures int) (deadLettered bool, err error)
+		// GetDeadLettered returns not processed dead-lettered orders
+		GetDeadLettered(ctx context.Context) ([]*model.DeadLetter, error)
+		// RetryDeadLettered returns dead-lettered order to polling
+		RetryDeadLettered(ctx context.Context, number model.OrderNumber) error
 		// ChangeStatusAndIncrementUserBalance is changing status of order with id m.Number to status m.Status and
-		// adding m.Accrual to user balance
-		ChangeStatusAndIncrementUserBalance(ctx context.Context, user int, m *model.OrderInAccrual) error
+		// adding m.Accrual to user balance. Order which already has status m.Status is not changed and user is not
+		// credited again; credited reports whether balance was incremented. Returns *model.TransitionError if order
+		// can't get status m.Status
+		ChangeStatusAndIncrementUserBalance(ctx context.Context, user int, m *model.OrderInAccrual) (credited bool, err error)
 	}
 	WithdrawRepository interface {
-		// Migrate database to current scheme
-		Migrate(ctx context.Context) error
 		// Withdraw create record about withdraw and writes-down user balance
 		Withdraw(ctx context.Context, user int, w *model.Withdraw) error
 		// GetAllByUser return all withdraw records which was created by user
 		GetAllByUser(ctx context.Context, user int) (w []*model.Withdraw, err error)
 	}
+	TransferRepository interface {
+		// Transfer moves t.Sum from user balance to balance of user with login t.Login and returns id of recipient.
+		// Repeated transfer with the same non-empty t.IdempotencyKey is not applied twice; ErrIdempotencyKeyReused is
+		// returned if key was used by transfer with other recipient or sum. dailyLimit restricts sum of user's
+		// outgoing transfers during current day; zero means no limit
+		Transfer(ctx context.Context, user int, t *model.Transfer, dailyLimit float64) (recipient int, err error)
+		// GetAllByUser return all incoming and outgoing transfers of user
+		GetAllByUser(ctx context.Context, user int) ([]*model.Transfer, error)
+	}
 )
@@ -0,0 +1,39 @@
+package sqlitestore
+
+import (
+	"errors"
+	"strings"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// isUniqueViolation checks err is violation of unique constraint.
+func isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+}
+
+// isBusy checks err is returned because database is locked by other connection.
+func isBusy(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	// extended result codes keep primary code in the lowest byte
+	code := sqliteErr.Code() & 0xff
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
+
+// debugQuery ...
+func debugQuery(q string) string {
+	q = strings.ReplaceAll(q, "\t", "")
+	q = strings.ReplaceAll(q, "\n", " ")
+	// this need if anywhere in query used spaces instead of \t
+	q = strings.ReplaceAll(q, "    ", "")
+	q = strings.ReplaceAll(q, "; ", ";")
+	return q
+}
@@ -0,0 +1,170 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"time"
+
+	"github.com/vlad-marlo/gophermart/internal/config"
+	"github.com/vlad-marlo/gophermart/internal/store"
+	"github.com/vlad-marlo/gophermart/pkg/logger"
+)
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+type (
+	// Migrator applies and reverts versioned migrations of database scheme. Migrations are checked and applied in one
+	// immediate transaction, so they are serialized with other writers by SQLite itself.
+	Migrator struct {
+		*store.MigrationRunner
+		db     *sql.DB
+		logger logger.Logger
+		// owned is true if db must be closed by migrator
+		owned bool
+	}
+	// migrationDialect takes migrations lock of SQLite with immediate transaction.
+	migrationDialect struct {
+		db     *sql.DB
+		logger logger.Logger
+	}
+	// migrationConn applies migrations in transaction of lock.
+	migrationConn struct {
+		q querier
+	}
+)
+
+// NewMigrator opens database from config.
+func NewMigrator(ctx context.Context, l logger.Logger, c *config.Config) (*Migrator, error) {
+	db, err := open(ctx, c.DBURI)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := newMigrator(db, l)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	m.owned = true
+	return m, nil
+}
+
+// newMigrator returns migrator of db with embedded migrations.
+func newMigrator(db *sql.DB, l logger.Logger) (*Migrator, error) {
+	migrations, err := store.LoadMigrations(migrationsFS, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("load migrations: %w", err)
+	}
+	return &Migrator{
+		MigrationRunner: store.NewMigrationRunner(&migrationDialect{db: db, logger: l}, l, migrations),
+		db:              db,
+		logger:          l,
+	}, nil
+}
+
+// Close closes database if it was opened by migrator.
+func (m *Migrator) Close() {
+	if m.owned {
+		if err := m.db.Close(); err != nil {
+			m.logger.Errorf("migrations: close db: %v", err)
+		}
+	}
+}
+
+// Lock runs f in transaction started by BEGIN IMMEDIATE, which takes write lock of database at once, so processes
+// sharing database file check and apply migrations one after another.
+func (d *migrationDialect) Lock(ctx context.Context, f func(c store.MigrationConn) error) error {
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("get connection: %w", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			d.logger.Errorf("migrations: release connection: %v", err)
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE;"); err != nil {
+		return fmt.Errorf("start transaction: %w", err)
+	}
+	committed := false
+	defer func() {
+		if committed {
+			return
+		}
+		if _, err := conn.ExecContext(context.Background(), "ROLLBACK;"); err != nil {
+			d.logger.Errorf("migrations: unable to rollback: %v", err)
+		}
+	}()
+
+	qCreate := debugQuery(`
+		CREATE TABLE IF NOT EXISTS schema_migrations(
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at INTEGER NOT NULL
+		);
+	`)
+	if _, err := conn.ExecContext(ctx, qCreate); err != nil {
+		return fmt.Errorf("create migrations table: %w", err)
+	}
+
+	if err := f(&migrationConn{q: conn}); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, "COMMIT;"); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	committed = true
+	return nil
+}
+
+// Applied ...
+func (c *migrationConn) Applied(ctx context.Context) (map[int]time.Time, error) {
+	rows, err := c.q.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations;")
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	defer rows.Close()
+
+	res := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var t int64
+		if err := rows.Scan(&version, &t); err != nil {
+			return nil, fmt.Errorf("rows scan: %w", err)
+		}
+		res[version] = fromUnixNano(t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows err: %w", err)
+	}
+	return res, nil
+}
+
+// Apply ...
+func (c *migrationConn) Apply(ctx context.Context, mig *store.Migration) error {
+	if _, err := c.q.ExecContext(ctx, mig.Up); err != nil {
+		return fmt.Errorf("migration %d_%s: up: %w", mig.Version, mig.Name, err)
+	}
+	qInsert := "INSERT INTO schema_migrations(version, name, applied_at) VALUES (?1, ?2, ?3);"
+	if _, err := c.q.ExecContext(ctx, qInsert, mig.Version, mig.Name, unixNano(time.Now())); err != nil {
+		return fmt.Errorf("insert migration: %w", err)
+	}
+	return nil
+}
+
+// Revert ...
+func (c *migrationConn) Revert(ctx context.Context, mig *store.Migration) error {
+	if _, err := c.q.ExecContext(ctx, mig.Down); err != nil {
+		return fmt.Errorf("migration %d_%s: down: %w", mig.Version, mig.Name, err)
+	}
+	if _, err := c.q.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?1;", mig.Version); err != nil {
+		return fmt.Errorf("delete migration: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS users(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	login TEXT UNIQUE NOT NULL,
+	password TEXT NOT NULL,
+	balance REAL NOT NULL DEFAULT 0
+);
@@ -0,0 +1,45 @@
+-- time is stored as count of nanoseconds since unix epoch
+CREATE TABLE IF NOT EXISTS orders(
+	pk INTEGER PRIMARY KEY AUTOINCREMENT,
+	id TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	status TEXT NOT NULL DEFAULT 'NEW',
+	created_at INTEGER NOT NULL,
+	accrual REAL NOT NULL DEFAULT 0,
+	-- scheduling of checks in accrual system
+	next_check_at INTEGER NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	needs_attention INTEGER NOT NULL DEFAULT 0,
+	-- lease of order by instance of application which is checking it in accrual system
+	locked_by TEXT,
+	locked_until INTEGER,
+	-- orders which state could not be resolved from answers of accrual system
+	failures INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	last_payload TEXT,
+	dead_lettered_at INTEGER,
+	FOREIGN KEY (user_id) REFERENCES users(id),
+	CONSTRAINT correct_status CHECK ( status IN('NEW', 'PROCESSING', 'INVALID', 'PROCESSED', 'CANCELLED') )
+);
+-- number is unique only among not cancelled orders, so cancelled number could be registered again
+CREATE UNIQUE INDEX IF NOT EXISTS
+	index_orders_active_number
+ON orders(id) WHERE status <> 'CANCELLED';
+CREATE INDEX IF NOT EXISTS
+	index_user_id_orders
+ON orders(user_id);
+CREATE INDEX IF NOT EXISTS
+	index_orders_next_check
+ON orders(next_check_at) WHERE status IN('NEW', 'PROCESSING');
+
+CREATE TABLE IF NOT EXISTS order_status_history(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	order_pk INTEGER NOT NULL,
+	status TEXT NOT NULL,
+	accrual REAL NOT NULL DEFAULT 0,
+	changed_at INTEGER NOT NULL,
+	FOREIGN KEY (order_pk) REFERENCES orders(pk) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS
+	index_order_status_history_order
+ON order_status_history(order_pk);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS withdrawals(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	processed_at INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	order_id TEXT NOT NULL,
+	order_sum REAL NOT NULL DEFAULT 0,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+CREATE INDEX IF NOT EXISTS
+	index_withdrawals_user
+ON withdrawals(user_id);
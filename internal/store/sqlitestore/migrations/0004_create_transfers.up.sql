@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS transfers(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	sender_id INTEGER NOT NULL,
+	recipient_id INTEGER NOT NULL,
+	amount REAL NOT NULL,
+	idempotency_key TEXT,
+	created_at INTEGER NOT NULL,
+	FOREIGN KEY (sender_id) REFERENCES users(id),
+	FOREIGN KEY (recipient_id) REFERENCES users(id),
+	CONSTRAINT unique_sender_idempotency_key UNIQUE (sender_id, idempotency_key),
+	CONSTRAINT positive_amount CHECK ( amount > 0 )
+);
+CREATE INDEX IF NOT EXISTS
+	index_transfers_sender
+ON transfers(sender_id, created_at);
+CREATE INDEX IF NOT EXISTS
+	index_transfers_recipient
+ON transfers(recipient_id);
@@ -0,0 +1,108 @@
+package sqlitestore_test
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vlad-marlo/gophermart/internal/config"
+	"github.com/vlad-marlo/gophermart/internal/store"
+	"github.com/vlad-marlo/gophermart/internal/store/sqlitestore"
+	"github.com/vlad-marlo/gophermart/pkg/logger"
+)
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	defer logger.DeleteLogFolderAndFile(t)
+
+	cfg := config.TestConfig(t)
+	cfg.DBURI = "sqlite://" + filepath.Join(t.TempDir(), "gophermart.db")
+
+	m, err := sqlitestore.NewMigrator(ctx, logger.GetLogger(), cfg)
+	require.NoError(t, err)
+	defer m.Close()
+
+	n, err := m.Up(ctx)
+	require.NoError(t, err)
+	assert.Positive(t, n)
+
+	states, err := m.Status(ctx)
+	require.NoError(t, err)
+	require.Len(t, states, n)
+	for _, s := range states {
+		assert.NotNilf(t, s.AppliedAt, "migration %d_%s is not applied", s.Version, s.Name)
+	}
+
+	// applied migrations are not applied again
+	n, err = m.Up(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	for i := len(states) - 1; i >= 0; i-- {
+		reverted, err := m.Down(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, states[i].Version, reverted.Version)
+	}
+	_, err = m.Down(ctx)
+	assert.ErrorIs(t, err, store.ErrNoMigrations)
+
+	n, err = m.Up(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, len(states), n)
+}
+
+func TestMigrator_ConcurrentUp(t *testing.T) {
+	ctx := context.Background()
+	defer logger.DeleteLogFolderAndFile(t)
+
+	cfg := config.TestConfig(t)
+	cfg.DBURI = "sqlite://" + filepath.Join(t.TempDir(), "gophermart.db")
+
+	// migrators have own connections like separate processes sharing database file
+	var (
+		wg      sync.WaitGroup
+		applied [2]int
+		errs    [2]error
+	)
+	for i := range applied {
+		m, err := sqlitestore.NewMigrator(ctx, logger.GetLogger(), cfg)
+		require.NoError(t, err)
+		defer m.Close()
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			applied[i], errs[i] = m.Up(ctx)
+		}(i)
+	}
+	wg.Wait()
+
+	require.NoError(t, errs[0])
+	require.NoError(t, errs[1])
+
+	m, err := sqlitestore.NewMigrator(ctx, logger.GetLogger(), cfg)
+	require.NoError(t, err)
+	defer m.Close()
+	states, err := m.Status(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, len(states), applied[0]+applied[1], "migrations were applied twice")
+}
+
+func TestIsURI(t *testing.T) {
+	tests := []struct {
+		uri  string
+		want bool
+	}{
+		{uri: "sqlite:gophermart.db", want: true},
+		{uri: "sqlite:///var/lib/gophermart.db", want: true},
+		{uri: "sqlite3::memory:", want: true},
+		{uri: "postgres://localhost:5432/gophermart", want: false},
+		{uri: "host=localhost dbname=gophermart", want: false},
+	}
+	for _, tt := range tests {
+		assert.Equalf(t, tt.want, sqlitestore.IsURI(tt.uri), "%s", tt.uri)
+	}
+}
@@ -0,0 +1,763 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/internal/store"
+)
+
+// pollSelector is condition of orders which must be checked in accrual system; ?1 is current time
+const pollSelector = `
+	status IN('NEW', 'PROCESSING')
+	AND next_check_at <= ?1
+	AND NOT needs_attention
+	AND dead_lettered_at IS NULL
+`
+
+type orderRepository struct {
+	s *storage
+}
+
+func (o *orderRepository) Register(ctx context.Context, user int, number model.OrderNumber) error {
+	tx, err := o.s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("start transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			o.s.logger.Errorf("register: unable to rollback: %v", err)
+		}
+	}()
+
+	order, err := o.register(ctx, tx, user, number)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	o.s.notify(order)
+	return nil
+}
+
+// register inserts new order with its history record in transaction. Returns store.ErrAlreadyRegisteredByUser or
+// store.ErrAlreadyRegisteredByAnotherUser if there is active order with such number.
+func (o *orderRepository) register(
+	ctx context.Context,
+	tx querier,
+	user int,
+	number model.OrderNumber,
+) (*model.OrderInPoll, error) {
+	qGetOwner := debugQuery(`
+		SELECT
+			user_id
+		FROM
+			orders
+		WHERE
+			id = ?1 AND status <> 'CANCELLED';
+	`)
+	qRegister := debugQuery(`
+		INSERT INTO
+			orders(id, user_id, created_at, next_check_at)
+		VALUES
+			(?1, ?2, ?3, ?3)
+		RETURNING pk, status;
+	`)
+	qInsertHistory := debugQuery(`
+		INSERT INTO
+			order_status_history(order_pk, status, changed_at)
+		VALUES
+			(?1, ?2, ?3);
+	`)
+
+	var owner int
+	err := tx.QueryRowContext(ctx, qGetOwner, number).Scan(&owner)
+	switch {
+	case err == nil && owner == user:
+		return nil, store.ErrAlreadyRegisteredByUser
+	case err == nil:
+		return nil, store.ErrAlreadyRegisteredByAnotherUser
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, fmt.Errorf("get owner: %w", err)
+	}
+
+	now := o.s.now()
+	order := &model.OrderInPoll{
+		Number:     number,
+		User:       user,
+		UploadedAt: now,
+	}
+	var pk int
+	if err := tx.QueryRowContext(ctx, qRegister, number, user, unixNano(now)).Scan(&pk, &order.Status); err != nil {
+		return nil, fmt.Errorf("register: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, qInsertHistory, pk, order.Status, unixNano(now)); err != nil {
+		return nil, fmt.Errorf("insert history: %w", err)
+	}
+	return order, nil
+}
+
+func (o *orderRepository) RegisterBatch(ctx context.Context, user int, numbers []model.OrderNumber) ([]*model.OrderRegistration, error) {
+	tx, err := o.s.begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("start transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			o.s.logger.Errorf("register batch: unable to rollback: %v", err)
+		}
+	}()
+
+	res := make([]*model.OrderRegistration, 0, len(numbers))
+	var registered []*model.OrderInPoll
+	for _, number := range numbers {
+		r := &model.OrderRegistration{
+			Number: number,
+			Result: model.RegistrationAccepted,
+		}
+
+		order, err := o.register(ctx, tx, user, number)
+		switch {
+		case errors.Is(err, store.ErrAlreadyRegisteredByUser):
+			r.Result = model.RegistrationDuplicateOwn
+		case errors.Is(err, store.ErrAlreadyRegisteredByAnotherUser):
+			r.Result = model.RegistrationDuplicateOther
+		case err != nil:
+			return nil, err
+		default:
+			registered = append(registered, order)
+		}
+
+		res = append(res, r)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	o.s.notify(registered...)
+	return res, nil
+}
+
+func (o *orderRepository) GetAllByUser(ctx context.Context, user int) (orders []*model.Order, err error) {
+	q := debugQuery(`
+		SELECT
+			x.id, x.status, x.accrual, x.created_at
+		FROM
+			orders x
+		WHERE
+			x.user_id = ?1 AND x.status <> 'CANCELLED'
+		ORDER BY
+			x.created_at, x.pk;
+	`)
+
+	rows, err := o.s.db.QueryContext(ctx, q, user)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var t int64
+		o := new(model.Order)
+
+		if err := rows.Scan(&o.Number, &o.Status, &o.Accrual, &t); err != nil {
+			return nil, fmt.Errorf("scan rows: %w", err)
+		}
+		o.UploadedAt = fromUnixNano(t).Format(time.RFC3339)
+		orders = append(orders, o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows err: %w", err)
+	}
+
+	if len(orders) == 0 {
+		return nil, store.ErrNoContent
+	}
+	return orders, nil
+}
+
+func (o *orderRepository) ChangeStatus(ctx context.Context, user int, m *model.OrderInAccrual) error {
+	tx, err := o.s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("start transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			o.s.logger.Errorf("change status: unable to rollback: %v", err)
+		}
+	}()
+
+	if _, err := o.updateStatus(ctx, tx, user, m); err != nil {
+		return fmt.Errorf("update status: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// updateStatus changes status of order in transaction and writes history record if status was changed. Repeated
+// update of order to its final status is not applied and applied is false. Returns store.ErrNoContent if there is no
+// active order with such number registered by user and *model.TransitionError if order can't get status m.Status.
+func (o *orderRepository) updateStatus(
+	ctx context.Context,
+	tx querier,
+	user int,
+	m *model.OrderInAccrual,
+) (applied bool, err error) {
+	qGetStatus := debugQuery(`
+		SELECT
+			pk, status
+		FROM
+			orders
+		WHERE
+			id = ?1 AND user_id = ?2 AND status <> 'CANCELLED';
+	`)
+	qUpdateStatus := debugQuery(`
+		UPDATE
+			orders
+		SET
+			status = ?1,
+			accrual = ?2
+		WHERE
+			pk = ?3;
+	`)
+	qInsertHistory := debugQuery(`
+		INSERT INTO
+			order_status_history(order_pk, status, accrual, changed_at)
+		VALUES
+			(?1, ?2, ?3, ?4);
+	`)
+
+	var (
+		pk     int
+		status string
+	)
+	if err := tx.QueryRowContext(ctx, qGetStatus, m.Number, user).Scan(&pk, &status); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, store.ErrNoContent
+		}
+		return false, fmt.Errorf("get status: %w", err)
+	}
+
+	if model.IsFinalStatus(status) && status == m.Status {
+		return false, nil
+	}
+	if err := model.ValidateTransition(status, m.Status); err != nil {
+		return false, err
+	}
+
+	if _, err := tx.ExecContext(ctx, qUpdateStatus, m.Status, m.Accrual, pk); err != nil {
+		return false, fmt.Errorf("update order: %w", err)
+	}
+
+	if status == m.Status {
+		return true, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, qInsertHistory, pk, m.Status, m.Accrual, unixNano(o.s.now())); err != nil {
+		return false, fmt.Errorf("insert history: %w", err)
+	}
+	return true, nil
+}
+
+func (o *orderRepository) GetActiveByNumber(ctx context.Context, number model.OrderNumber) (*model.OrderInPoll, error) {
+	q := debugQuery(`
+		SELECT
+			x.id, x.status, x.user_id, x.attempts, x.created_at
+		FROM
+			orders x
+		WHERE
+			x.id = ?1 AND x.status <> 'CANCELLED';
+	`)
+
+	order, err := scanOrderInPoll(o.s.db.QueryRowContext(ctx, q, number))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.ErrNoContent
+		}
+		return nil, fmt.Errorf("query row: %w", err)
+	}
+	return order, nil
+}
+
+func (o *orderRepository) GetByNumber(ctx context.Context, user int, number model.OrderNumber) (*model.Order, error) {
+	qGetOrder := debugQuery(`
+		SELECT
+			x.pk, x.id, x.status, x.accrual, x.created_at
+		FROM
+			orders x
+		WHERE
+			x.id = ?1 AND x.user_id = ?2 AND x.status <> 'CANCELLED';
+	`)
+	qGetHistory := debugQuery(`
+		SELECT
+			h.status, h.accrual, h.changed_at
+		FROM
+			order_status_history h
+		WHERE
+			h.order_pk = ?1
+		ORDER BY
+			h.changed_at, h.id;
+	`)
+
+	var (
+		pk         int
+		uploadedAt int64
+	)
+	order := new(model.Order)
+
+	if err := o.s.db.QueryRowContext(ctx, qGetOrder, number, user).Scan(
+		&pk,
+		&order.Number,
+		&order.Status,
+		&order.Accrual,
+		&uploadedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.ErrNoContent
+		}
+		return nil, fmt.Errorf("get order: %w", err)
+	}
+	order.UploadedAt = fromUnixNano(uploadedAt).Format(time.RFC3339)
+
+	rows, err := o.s.db.QueryContext(ctx, qGetHistory, pk)
+	if err != nil {
+		return nil, fmt.Errorf("get history: %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var t int64
+		c := new(model.OrderStatusChange)
+
+		if err := rows.Scan(&c.Status, &c.Accrual, &t); err != nil {
+			return nil, fmt.Errorf("rows scan: %w", err)
+		}
+		c.ChangedAt = fromUnixNano(t).Format(time.RFC3339)
+		order.History = append(order.History, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows err: %w", err)
+	}
+
+	return order, nil
+}
+
+func (o *orderRepository) Cancel(ctx context.Context, user int, number model.OrderNumber) error {
+	qGetStatus := debugQuery(`
+		SELECT
+			pk, status
+		FROM
+			orders
+		WHERE
+			id = ?1 AND user_id = ?2 AND status <> 'CANCELLED';
+	`)
+	qCancel := debugQuery(`
+		UPDATE
+			orders
+		SET
+			status = 'CANCELLED'
+		WHERE
+			pk = ?1;
+	`)
+	qInsertHistory := debugQuery(`
+		INSERT INTO
+			order_status_history(order_pk, status, changed_at)
+		VALUES
+			(?1, 'CANCELLED', ?2);
+	`)
+
+	tx, err := o.s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("start transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			o.s.logger.Errorf("cancel: unable to rollback: %v", err)
+		}
+	}()
+
+	var (
+		pk     int
+		status string
+	)
+	if err := tx.QueryRowContext(ctx, qGetStatus, number, user).Scan(&pk, &status); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.ErrNoContent
+		}
+		return fmt.Errorf("get status: %w", err)
+	}
+
+	if !model.CanTransition(status, model.StatusCancelled) {
+		return store.ErrNotCancellable
+	}
+
+	if _, err := tx.ExecContext(ctx, qCancel, pk); err != nil {
+		return fmt.Errorf("cancel: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, qInsertHistory, pk, unixNano(o.s.now())); err != nil {
+		return fmt.Errorf("insert history: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+func (o *orderRepository) ListenRegistered(ctx context.Context, orders chan<- *model.OrderInPoll) error {
+	l := make(chan *model.OrderInPoll, 64)
+
+	o.s.mu.Lock()
+	o.s.listeners[l] = struct{}{}
+	o.s.mu.Unlock()
+
+	defer func() {
+		o.s.mu.Lock()
+		delete(o.s.listeners, l)
+		o.s.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case order := <-l:
+			select {
+			case orders <- order:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (o *orderRepository) ClaimUnprocessedOrders(
+	ctx context.Context,
+	owner string,
+	limit int,
+	lease time.Duration,
+) (res []*model.OrderInPoll, err error) {
+	// all queries run on single connection, so instances of application in the same process never claim the same
+	// order; instances in other processes wait for lock of database
+	q := debugQuery(`
+		UPDATE
+			orders
+		SET
+			locked_by = ?2,
+			locked_until = ?4
+		WHERE
+			pk IN (
+				SELECT
+					pk
+				FROM
+					orders
+				WHERE` + pollSelector + `
+					AND (locked_until IS NULL OR locked_until < ?1 OR locked_by = ?2)
+				ORDER BY
+					next_check_at
+				LIMIT ?3
+			)
+		RETURNING
+			id, status, user_id, attempts, created_at;
+	`)
+
+	now := o.s.now()
+	rows, err := o.s.db.QueryContext(ctx, q, unixNano(now), owner, limit, unixNano(now.Add(lease)))
+	if err != nil {
+		return nil, fmt.Errorf("db query: %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		order, err := scanOrderInPoll(rows)
+		if err != nil {
+			return nil, fmt.Errorf("rows scan: %w", err)
+		}
+		res = append(res, order)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows err: %w", err)
+	}
+
+	return res, nil
+}
+
+func (o *orderRepository) ClaimOrder(
+	ctx context.Context,
+	owner string,
+	number model.OrderNumber,
+	lease time.Duration,
+) (bool, error) {
+	q := debugQuery(`
+		UPDATE
+			orders
+		SET
+			locked_by = ?1,
+			locked_until = ?4
+		WHERE
+			id = ?2
+			AND status IN('NEW', 'PROCESSING')
+			AND NOT needs_attention
+			AND dead_lettered_at IS NULL
+			AND (locked_until IS NULL OR locked_until < ?3 OR locked_by = ?1);
+	`)
+
+	now := o.s.now()
+	res, err := o.s.db.ExecContext(ctx, q, owner, number, unixNano(now), unixNano(now.Add(lease)))
+	if err != nil {
+		return false, fmt.Errorf("exec: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("rows affected: %w", err)
+	}
+	return n > 0, nil
+}
+
+func (o *orderRepository) Postpone(ctx context.Context, owner string, number model.OrderNumber, delay time.Duration) error {
+	q := debugQuery(`
+		UPDATE
+			orders
+		SET
+			attempts = attempts + 1,
+			next_check_at = ?2,
+			locked_by = NULL,
+			locked_until = NULL
+		WHERE
+			id = ?1 AND status <> 'CANCELLED' AND locked_by = ?3;
+	`)
+
+	res, err := o.s.db.ExecContext(ctx, q, number, unixNano(o.s.now().Add(delay)), owner)
+	if err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+	return leaseUpdated(res)
+}
+
+func (o *orderRepository) Reschedule(ctx context.Context, owner string, number model.OrderNumber, delay time.Duration) error {
+	q := debugQuery(`
+		UPDATE
+			orders
+		SET
+			next_check_at = ?2,
+			locked_by = NULL,
+			locked_until = NULL
+		WHERE
+			id = ?1 AND status <> 'CANCELLED' AND locked_by = ?3;
+	`)
+
+	res, err := o.s.db.ExecContext(ctx, q, number, unixNano(o.s.now().Add(delay)), owner)
+	if err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+	return leaseUpdated(res)
+}
+
+func (o *orderRepository) MarkNeedsAttention(ctx context.Context, owner string, number model.OrderNumber) error {
+	q := debugQuery(`
+		UPDATE
+			orders
+		SET
+			needs_attention = TRUE
+		WHERE
+			id = ?1 AND status <> 'CANCELLED' AND locked_by = ?2;
+	`)
+
+	res, err := o.s.db.ExecContext(ctx, q, number, owner)
+	if err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+	return leaseUpdated(res)
+}
+
+// leaseUpdated returns store.ErrLeaseLost if update of leased order changed nothing.
+func leaseUpdated(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if n == 0 {
+		return store.ErrLeaseLost
+	}
+	return nil
+}
+
+func (o *orderRepository) RecordFailure(
+	ctx context.Context,
+	owner string,
+	number model.OrderNumber,
+	reason, payload string,
+	maxFailures int,
+) (deadLettered bool, err error) {
+	q := debugQuery(`
+		UPDATE
+			orders
+		SET
+			failures = failures + 1,
+			last_error = ?2,
+			last_payload = NULLIF(?3, ''),
+			dead_lettered_at = CASE
+				WHEN ?4 > 0 AND failures + 1 >= ?4 AND dead_lettered_at IS NULL THEN ?5
+				ELSE dead_lettered_at
+			END
+		WHERE
+			id = ?1 AND status IN('NEW', 'PROCESSING') AND locked_by = ?6
+		RETURNING
+			dead_lettered_at IS NOT NULL;
+	`)
+
+	err = o.s.db.QueryRowContext(ctx, q, number, reason, payload, maxFailures, unixNano(o.s.now()), owner).Scan(&deadLettered)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, store.ErrLeaseLost
+		}
+		return false, fmt.Errorf("query row: %w", err)
+	}
+	return deadLettered, nil
+}
+
+func (o *orderRepository) GetDeadLettered(ctx context.Context) (res []*model.DeadLetter, err error) {
+	q := debugQuery(`
+		SELECT
+			x.id, x.user_id, x.status, x.failures, COALESCE(x.last_error, ''), COALESCE(x.last_payload, ''),
+			x.dead_lettered_at
+		FROM
+			orders x
+		WHERE
+			x.dead_lettered_at IS NOT NULL AND x.status IN('NEW', 'PROCESSING')
+		ORDER BY
+			x.dead_lettered_at;
+	`)
+
+	rows, err := o.s.db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var t int64
+		d := new(model.DeadLetter)
+
+		if err := rows.Scan(&d.Number, &d.User, &d.Status, &d.Failures, &d.LastError, &d.LastPayload, &t); err != nil {
+			return nil, fmt.Errorf("rows scan: %w", err)
+		}
+		d.DeadLetteredAt = fromUnixNano(t).Format(time.RFC3339)
+		res = append(res, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows err: %w", err)
+	}
+
+	if len(res) == 0 {
+		return nil, store.ErrNoContent
+	}
+	return res, nil
+}
+
+func (o *orderRepository) RetryDeadLettered(ctx context.Context, number model.OrderNumber) error {
+	q := debugQuery(`
+		UPDATE
+			orders
+		SET
+			failures = 0,
+			dead_lettered_at = NULL,
+			needs_attention = FALSE,
+			next_check_at = ?2,
+			locked_by = NULL,
+			locked_until = NULL
+		WHERE
+			id = ?1 AND status IN('NEW', 'PROCESSING') AND dead_lettered_at IS NOT NULL;
+	`)
+
+	res, err := o.s.db.ExecContext(ctx, q, number, unixNano(o.s.now()))
+	if err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if n == 0 {
+		return store.ErrNoContent
+	}
+	return nil
+}
+
+func (o *orderRepository) ChangeStatusAndIncrementUserBalance(
+	ctx context.Context,
+	user int,
+	m *model.OrderInAccrual,
+) (credited bool, err error) {
+	qIncrementBalance := debugQuery(`
+		UPDATE
+			users
+		SET
+			balance = balance + ?1
+		WHERE
+			id = ?2;
+	`)
+
+	tx, err := o.s.begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("start transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			o.s.logger.Errorf("change status and increment balance: unable to rollback: %v", err)
+		}
+	}()
+
+	applied, err := o.updateStatus(ctx, tx, user, m)
+	if err != nil {
+		return false, fmt.Errorf("update order: %w", err)
+	}
+	// order was already finalized and credited
+	if !applied {
+		return false, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, qIncrementBalance, m.Accrual, user); err != nil {
+		return false, fmt.Errorf("increment user balance: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit: %w", err)
+	}
+	return true, nil
+}
+
+// scanOrderInPoll scans id, status, user_id, attempts and created_at of order.
+func scanOrderInPoll(row interface{ Scan(...interface{}) error }) (*model.OrderInPoll, error) {
+	var t int64
+	order := new(model.OrderInPoll)
+	if err := row.Scan(&order.Number, &order.Status, &order.User, &order.Attempts, &t); err != nil {
+		return nil, err
+	}
+	order.UploadedAt = fromUnixNano(t)
+	return order, nil
+}
@@ -0,0 +1,346 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	// registers "sqlite" driver
+	_ "modernc.org/sqlite"
+
+	"github.com/vlad-marlo/gophermart/internal/config"
+	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/internal/store"
+	"github.com/vlad-marlo/gophermart/pkg/logger"
+)
+
+// schemes of DATABASE_URI which select SQLite storage
+var schemes = []string{"sqlite:", "sqlite3:"}
+
+// maxTxAttempts is max count of runs of transaction which fails because database is locked by other process
+const maxTxAttempts = 5
+
+// savepointSeq is sequence of names of savepoints
+var savepointSeq uint64
+
+type (
+	// querier is implemented by database and by transaction, so the same repositories run queries in transaction if
+	// storage is bound to it
+	querier interface {
+		ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+		QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+		QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	}
+	// txer is transaction or savepoint of transaction
+	txer interface {
+		querier
+		Commit() error
+		Rollback() error
+	}
+	// savepoint is nested transaction; Commit and Rollback return sql.ErrTxDone if savepoint is already released
+	savepoint struct {
+		*sql.Tx
+		ctx  context.Context
+		name string
+		done bool
+	}
+	storage struct {
+		db     querier
+		pool   *sql.DB
+		logger logger.Logger
+		// tx is transaction which storage is bound to
+		tx *sql.Tx
+
+		// now returns current time; time is stored as count of nanoseconds since unix epoch
+		now func() time.Time
+
+		// SQLite has no notifications, so listeners receive only orders registered by this process; orders registered
+		// by other processes are found by polling
+		mu        *sync.Mutex
+		listeners map[chan *model.OrderInPoll]struct{}
+		// pending are orders registered in transaction; listeners are notified about them after commit
+		pending *[]*model.OrderInPoll
+
+		// repositories
+		user     store.UserRepository
+		order    store.OrderRepository
+		withdraw store.WithdrawRepository
+		transfer store.TransferRepository
+	}
+)
+
+// IsURI reports whether database URI selects SQLite storage: sqlite:<path>, sqlite://<path> or sqlite::memory:.
+func IsURI(uri string) bool {
+	for _, s := range schemes {
+		if strings.HasPrefix(uri, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// New ...
+func New(ctx context.Context, l logger.Logger, c *config.Config) (store.Storage, error) {
+	db, err := open(ctx, c.DBURI)
+	if err != nil {
+		return nil, err
+	}
+
+	s := newStorage(db, l)
+
+	if c.DBMigrate {
+		m, err := newMigrator(db, l)
+		if err != nil {
+			return nil, fmt.Errorf("new migrator: %w", err)
+		}
+		if _, err := m.Up(ctx); err != nil {
+			return nil, fmt.Errorf("migrate: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+// newStorage ...
+func newStorage(db *sql.DB, l logger.Logger) *storage {
+	s := &storage{
+		db:        db,
+		pool:      db,
+		logger:    l,
+		now:       time.Now,
+		mu:        new(sync.Mutex),
+		listeners: make(map[chan *model.OrderInPoll]struct{}),
+	}
+	s.initRepositories()
+	return s
+}
+
+// bind returns storage which repositories run queries in tx; orders registered in tx are added to pending.
+func (s *storage) bind(tx *sql.Tx, pending *[]*model.OrderInPoll) *storage {
+	b := &storage{
+		db:        tx,
+		pool:      s.pool,
+		logger:    s.logger,
+		tx:        tx,
+		now:       s.now,
+		mu:        s.mu,
+		listeners: s.listeners,
+		pending:   pending,
+	}
+	b.initRepositories()
+	return b
+}
+
+// initRepositories ...
+func (s *storage) initRepositories() {
+	s.user = &userRepository{s}
+	s.order = &orderRepository{s}
+	s.withdraw = &withdrawRepository{s}
+	s.transfer = &transferRepository{s}
+}
+
+// open opens database from uri.
+func open(ctx context.Context, uri string) (*sql.DB, error) {
+	dsn, err := dataSource(uri)
+	if err != nil {
+		return nil, fmt.Errorf("parse uri: %w", err)
+	}
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("sql open: %w", err)
+	}
+	// SQLite allows only one writer, so all queries are serialized on single connection; it also keeps in-memory
+	// database alive
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxLifetime(0)
+
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("ping db: %w", err)
+	}
+	return db, nil
+}
+
+// dataSource converts database URI to data source name of SQLite driver.
+func dataSource(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if !IsURI(u.Scheme + ":") {
+		return "", fmt.Errorf("unknown scheme %q", u.Scheme)
+	}
+
+	// sqlite:gophermart.db and sqlite::memory: are opaque, sqlite://gophermart.db has host and
+	// sqlite:///var/lib/gophermart.db has path
+	path := u.Opaque
+	if path == "" {
+		path = u.Host + u.Path
+	}
+	if path == "" {
+		return "", fmt.Errorf("path to database is empty")
+	}
+
+	q := u.Query()
+	q.Add("_pragma", "foreign_keys(1)")
+	q.Add("_pragma", "busy_timeout(5000)")
+	return "file:" + path + "?" + q.Encode(), nil
+}
+
+// notify sends registered orders to listeners or defers it until commit of transaction. It must be called after orders
+// are committed.
+func (s *storage) notify(orders ...*model.OrderInPoll) {
+	if s.pending != nil {
+		*s.pending = append(*s.pending, orders...)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for l := range s.listeners {
+		for _, o := range orders {
+			// listener which is not keeping up loses notification; order is still found by polling
+			select {
+			case l <- o:
+			default:
+			}
+		}
+	}
+}
+
+// User ...
+func (s *storage) User() store.UserRepository {
+	return s.user
+}
+
+// Order ...
+func (s *storage) Order() store.OrderRepository {
+	return s.order
+}
+
+// Withdraws ...
+func (s *storage) Withdraws() store.WithdrawRepository {
+	return s.withdraw
+}
+
+// Transfers ...
+func (s *storage) Transfers() store.TransferRepository {
+	return s.transfer
+}
+
+// WithTx runs f in transaction; it is retried if database is locked by other process. All queries of process run on
+// single connection, so transactions of process are serialized. Nested transaction is savepoint, which is retried only
+// as part of outer transaction.
+func (s *storage) WithTx(ctx context.Context, f func(tx store.Storage) error) error {
+	if s.tx != nil {
+		return s.runTx(ctx, f)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := s.runTx(ctx, f)
+		if !isBusy(err) || attempt == maxTxAttempts || ctx.Err() != nil {
+			return err
+		}
+		s.logger.Debugf("with tx: attempt %d: %v", attempt, err)
+	}
+}
+
+// runTx runs f in transaction once.
+func (s *storage) runTx(ctx context.Context, f func(tx store.Storage) error) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("start transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			s.logger.Errorf("with tx: unable to rollback: %v", err)
+		}
+	}()
+
+	bound := s.tx
+	if bound == nil {
+		bound = tx.(*sql.Tx)
+	}
+	var pending []*model.OrderInPoll
+	if err := f(s.bind(bound, &pending)); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	s.notify(pending...)
+	return nil
+}
+
+// begin starts transaction or savepoint if storage is bound to transaction.
+func (s *storage) begin(ctx context.Context) (txer, error) {
+	if s.tx == nil {
+		tx, err := s.pool.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		return tx, nil
+	}
+
+	name := fmt.Sprintf("sp_%d", atomic.AddUint64(&savepointSeq, 1))
+	if _, err := s.tx.ExecContext(ctx, "SAVEPOINT "+name+";"); err != nil {
+		return nil, err
+	}
+	return &savepoint{Tx: s.tx, ctx: ctx, name: name}, nil
+}
+
+// Commit releases savepoint.
+func (sp *savepoint) Commit() error {
+	if sp.done {
+		return sql.ErrTxDone
+	}
+	sp.done = true
+
+	_, err := sp.Tx.ExecContext(sp.ctx, "RELEASE "+sp.name+";")
+	return err
+}
+
+// Rollback rolls back changes made after savepoint and releases it.
+func (sp *savepoint) Rollback() error {
+	if sp.done {
+		return sql.ErrTxDone
+	}
+	sp.done = true
+
+	if _, err := sp.Tx.ExecContext(sp.ctx, "ROLLBACK TO "+sp.name+";"); err != nil {
+		return err
+	}
+	_, err := sp.Tx.ExecContext(sp.ctx, "RELEASE "+sp.name+";")
+	return err
+}
+
+// Close closes database; storage bound to transaction doesn't own database, so it is not closed.
+func (s *storage) Close() {
+	if s.tx != nil {
+		return
+	}
+	if err := s.pool.Close(); err != nil {
+		s.logger.Errorf("close db: %v", err)
+	}
+}
+
+// unixNano converts t to stored representation of time.
+func unixNano(t time.Time) int64 {
+	return t.UnixNano()
+}
+
+// fromUnixNano converts stored representation of time to time.Time.
+func fromUnixNano(n int64) time.Time {
+	return time.Unix(0, n)
+}
@@ -0,0 +1,15 @@
+package sqlitestore_test
+
+import (
+	"testing"
+
+	"github.com/vlad-marlo/gophermart/internal/store"
+	"github.com/vlad-marlo/gophermart/internal/store/sqlitestore"
+	"github.com/vlad-marlo/gophermart/internal/store/storetest"
+)
+
+func TestStorage_Conformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) (store.Storage, func()) {
+		return sqlitestore.TestStore(t)
+	})
+}
@@ -0,0 +1,35 @@
+package sqlitestore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/vlad-marlo/gophermart/internal/store"
+	"github.com/vlad-marlo/gophermart/pkg/logger"
+)
+
+// TestStore returns migrated storage with in-memory database which is dropped by teardown.
+func TestStore(t *testing.T) (store.Storage, func()) {
+	t.Helper()
+
+	l := logger.GetLogger()
+
+	db, err := open(context.Background(), "sqlite::memory:")
+	if err != nil {
+		t.Fatalf("test store: %v", err)
+	}
+
+	m, err := newMigrator(db, l)
+	if err != nil {
+		t.Fatalf("new migrator: %v", err)
+	}
+	if _, err := m.Up(context.Background()); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	s := newStorage(db, l)
+	return s, func() {
+		s.Close()
+		logger.DeleteLogFolderAndFile(t)
+	}
+}
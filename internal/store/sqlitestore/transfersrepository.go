@@ -0,0 +1,218 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/internal/store"
+)
+
+type transferRepository struct {
+	s *storage
+}
+
+func (r *transferRepository) Transfer(ctx context.Context, user int, t *model.Transfer, dailyLimit float64) (int, error) {
+	if t.Sum <= 0 {
+		return 0, store.ErrIncorrectData
+	}
+
+	qGetRecipient := debugQuery(`
+	SELECT
+		id
+	FROM
+		users
+	WHERE
+		login = ?1;
+	`)
+	qGetBalance := debugQuery(`
+	SELECT
+		balance
+	FROM
+		users
+	WHERE
+		id = ?1;
+	`)
+	qSentToday := debugQuery(`
+	SELECT
+		COALESCE(SUM(amount), 0.0)
+	FROM
+		transfers
+	WHERE
+		sender_id = ?1 AND created_at >= ?2;
+	`)
+	qChangeBalance := debugQuery(`
+	UPDATE
+		users
+	SET
+		balance = balance + ?1
+	WHERE
+		id = ?2;
+	`)
+	qInsertTransfer := debugQuery(`
+	INSERT INTO
+		transfers(
+			sender_id,
+			recipient_id,
+			amount,
+			idempotency_key,
+			created_at
+		)
+	VALUES (?1, ?2, ?3, NULLIF(?4, ''), ?5);
+	`)
+
+	// all queries run on single connection, so transaction sees no concurrent changes
+	tx, err := r.s.begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("tx begin: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			r.s.logger.WithFields(map[string]interface{}{
+				"request_id": middleware.GetReqID(ctx),
+			}).Errorf("transfer: unable to rollback: %v", err)
+		}
+	}()
+
+	if t.IdempotencyKey != "" {
+		recipient, ok, err := r.replay(ctx, tx, user, t)
+		if err != nil || ok {
+			return recipient, err
+		}
+	}
+
+	var recipient int
+	if err := tx.QueryRowContext(ctx, qGetRecipient, t.Login).Scan(&recipient); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, store.ErrRecipientNotFound
+		}
+		return 0, fmt.Errorf("get recipient: %w", err)
+	}
+	if recipient == user {
+		return 0, store.ErrIncorrectData
+	}
+
+	var bal float64
+	if err := tx.QueryRowContext(ctx, qGetBalance, user).Scan(&bal); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, store.ErrNoContent
+		}
+		return 0, fmt.Errorf("get balance: %w", err)
+	}
+
+	now := r.s.now()
+	if dailyLimit > 0 {
+		dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		var sent float64
+		if err := tx.QueryRowContext(ctx, qSentToday, user, unixNano(dayStart)).Scan(&sent); err != nil {
+			return 0, fmt.Errorf("get sent today: %w", err)
+		}
+		if sent+t.Sum > dailyLimit {
+			return 0, store.ErrDailyLimitExceeded
+		}
+	}
+
+	if bal < t.Sum {
+		return 0, store.ErrPaymentRequired
+	}
+
+	if _, err := tx.ExecContext(ctx, qChangeBalance, -t.Sum, user); err != nil {
+		return 0, fmt.Errorf("debit sender: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, qChangeBalance, t.Sum, recipient); err != nil {
+		return 0, fmt.Errorf("credit recipient: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, qInsertTransfer, user, recipient, t.Sum, t.IdempotencyKey, unixNano(now)); err != nil {
+		return 0, fmt.Errorf("insert transfer: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit: %w", err)
+	}
+	t.ProcessedAt = now
+	return recipient, nil
+}
+
+// replay returns recipient of transfer committed by user with idempotency key of t and reports whether it exists.
+// ErrIdempotencyKeyReused is returned if recipient or sum of committed transfer differ from t.
+func (r *transferRepository) replay(ctx context.Context, db querier, user int, t *model.Transfer) (int, bool, error) {
+	q := debugQuery(`
+	SELECT
+		t.recipient_id, u.login, t.amount, t.created_at
+	FROM
+		transfers t
+	JOIN
+		users u ON u.id = t.recipient_id
+	WHERE
+		t.sender_id = ?1 AND t.idempotency_key = ?2;
+	`)
+
+	var (
+		recipient int
+		login     string
+		sum       float64
+		at        int64
+	)
+	if err := db.QueryRowContext(ctx, q, user, t.IdempotencyKey).Scan(&recipient, &login, &sum, &at); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("get transfer by idempotency key: %w", err)
+	}
+	if login != t.Login || sum != t.Sum {
+		return 0, true, store.ErrIdempotencyKeyReused
+	}
+	t.ProcessedAt = fromUnixNano(at)
+	return recipient, true, nil
+}
+
+func (r *transferRepository) GetAllByUser(ctx context.Context, user int) (res []*model.Transfer, err error) {
+	q := debugQuery(`
+	SELECT
+		CASE WHEN t.sender_id = ?1 THEN 'outgoing' ELSE 'incoming' END,
+		u.login, t.amount, t.created_at
+	FROM
+		transfers t
+	JOIN
+		users u ON u.id = CASE WHEN t.sender_id = ?1 THEN t.recipient_id ELSE t.sender_id END
+	WHERE
+		t.sender_id = ?1 OR t.recipient_id = ?1
+	ORDER BY t.created_at, t.id;
+	`)
+
+	rows, err := r.s.db.QueryContext(ctx, q, user)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var at int64
+		t := new(model.Transfer)
+
+		if err := rows.Scan(&t.Direction, &t.Login, &t.Sum, &at); err != nil {
+			return nil, fmt.Errorf("rows scan: %w", err)
+		}
+		t.ProcessedAt = fromUnixNano(at)
+		t.ToRepresentation()
+		res = append(res, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows err: %w", err)
+	}
+
+	if len(res) == 0 {
+		return nil, store.ErrNoContent
+	}
+
+	return res, nil
+}
@@ -0,0 +1,132 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/internal/store"
+)
+
+type userRepository struct {
+	s *storage
+}
+
+// Create ...
+func (r *userRepository) Create(ctx context.Context, u *model.User) error {
+	q := debugQuery(`
+		INSERT INTO
+			users(login, password)
+		VALUES
+			(?1, ?2)
+		RETURNING id;
+	`)
+
+	if err := u.BeforeCreate(); err != nil {
+		return fmt.Errorf("before create: %w", err)
+	}
+
+	if err := r.s.db.QueryRowContext(ctx, q, u.Login, u.EncryptedPassword).Scan(&u.ID); err != nil {
+		if isUniqueViolation(err) {
+			return store.ErrLoginAlreadyInUse
+		}
+		return fmt.Errorf("scan: %w", err)
+	}
+	return nil
+}
+
+// GetByLogin ...
+func (r *userRepository) GetByLogin(ctx context.Context, login string) (*model.User, error) {
+	q := debugQuery(`
+		SELECT
+			x.id, x.password
+		FROM users AS x
+		WHERE x.login = ?1;
+	`)
+	u := &model.User{Login: login}
+
+	if err := r.s.db.QueryRowContext(ctx, q, login).Scan(&u.ID, &u.EncryptedPassword); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.ErrIncorrectLoginData
+		}
+		return nil, fmt.Errorf("query row: %w", err)
+	}
+	return u, nil
+}
+
+// ExistsWithID ...
+func (r *userRepository) ExistsWithID(ctx context.Context, id int) bool {
+	q := debugQuery(`
+		SELECT EXISTS(
+			SELECT
+				*
+			FROM
+				users
+			WHERE
+				id = ?1
+		);
+	`)
+
+	var res bool
+	if err := r.s.db.QueryRowContext(ctx, q, id).Scan(&res); err != nil {
+		r.s.logger.WithFields(map[string]interface{}{
+			"request_id": middleware.GetReqID(ctx),
+			"sql":        q,
+		}).Errorf("exists with id: scan: %v", err)
+		return false
+	}
+	return res
+}
+
+// GetBalance ...
+func (r *userRepository) GetBalance(ctx context.Context, id int) (*model.UserBalance, error) {
+	q := debugQuery(`
+		SELECT
+			balance,
+			COALESCE((
+				SELECT
+					SUM(order_sum)
+				FROM
+					withdrawals
+				WHERE
+					user_id = ?1
+			), 0.0)
+		FROM
+			users
+		WHERE
+			id = ?1;
+	`)
+
+	balance := new(model.UserBalance)
+	if err := r.s.db.QueryRowContext(ctx, q, id).Scan(&balance.Current, &balance.Withdrawn); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.ErrNoContent
+		}
+		return nil, fmt.Errorf("query row: %w", err)
+	}
+	return balance, nil
+}
+
+// IncrementBalance ...
+func (r *userRepository) IncrementBalance(ctx context.Context, id int, add float64) error {
+	q := debugQuery(`
+		UPDATE
+			users
+		SET
+			balance = balance + ?1
+		WHERE
+			id = ?2;
+	`)
+
+	if add <= 0 {
+		return fmt.Errorf("check args: %w", store.ErrIncorrectData)
+	}
+
+	if _, err := r.s.db.ExecContext(ctx, q, add, id); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+	return nil
+}
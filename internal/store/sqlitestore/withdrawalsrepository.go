@@ -0,0 +1,115 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/internal/store"
+)
+
+type withdrawRepository struct {
+	s *storage
+}
+
+func (r *withdrawRepository) Withdraw(ctx context.Context, user int, w *model.Withdraw) error {
+	qGetBalance := debugQuery(`
+		SELECT
+			balance
+		FROM
+			users
+		WHERE
+			id = ?1;
+	`)
+	qDebit := debugQuery(`
+		UPDATE
+			users
+		SET
+			balance = balance - ?1
+		WHERE
+			id = ?2;
+	`)
+	qInsert := debugQuery(`
+		INSERT INTO
+			withdrawals(user_id, order_id, order_sum, processed_at)
+		VALUES
+			(?1, ?2, ?3, ?4);
+	`)
+
+	tx, err := r.s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("tx begin: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			r.s.logger.Errorf("withdraw: unable to rollback: %v", err)
+		}
+	}()
+
+	var bal float64
+	if err := tx.QueryRowContext(ctx, qGetBalance, user).Scan(&bal); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.ErrNoContent
+		}
+		return fmt.Errorf("get balance: %w", err)
+	}
+	if bal < w.Sum {
+		return store.ErrPaymentRequired
+	}
+
+	if _, err := tx.ExecContext(ctx, qDebit, w.Sum, user); err != nil {
+		return fmt.Errorf("debit user: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, qInsert, user, w.Order, w.Sum, unixNano(r.s.now())); err != nil {
+		return fmt.Errorf("insert withdraw: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+func (r *withdrawRepository) GetAllByUser(ctx context.Context, user int) (res []*model.Withdraw, err error) {
+	q := debugQuery(`
+		SELECT
+			order_id, order_sum, processed_at
+		FROM
+			withdrawals
+		WHERE
+			user_id = ?1
+		ORDER BY
+			processed_at, id;
+	`)
+
+	rows, err := r.s.db.QueryContext(ctx, q, user)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var t int64
+		w := new(model.Withdraw)
+
+		if err := rows.Scan(&w.Order, &w.Sum, &t); err != nil {
+			return nil, fmt.Errorf("rows scan: %w", err)
+		}
+		w.ProcessedAt = fromUnixNano(t)
+		w.ToRepresentation()
+		res = append(res, w)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows err: %w", err)
+	}
+
+	if len(res) == 0 {
+		return nil, store.ErrNoContent
+	}
+	return res, nil
+}
@@ -0,0 +1,22 @@
+package sqlstore_test
+
+import (
+	"testing"
+
+	"github.com/vlad-marlo/gophermart/internal/store"
+	"github.com/vlad-marlo/gophermart/internal/store/sqlstore"
+	"github.com/vlad-marlo/gophermart/internal/store/storetest"
+)
+
+func TestStorage_Conformance(t *testing.T) {
+	if conStr == "" {
+		t.Skip("connect string was not provided")
+	}
+
+	storetest.Run(t, func(t *testing.T) (store.Storage, func()) {
+		s, teardown := sqlstore.TestStore(t, conStr)
+		return s, func() {
+			teardown(userTableName, ordersTableName, withdrawalsTableName, transfersTableName)
+		}
+	})
+}
@@ -6,13 +6,28 @@ import (
 	"strings"
 )
 
+// sqlError is postgres error with detailed message; it unwraps to *pgconn.PgError, so code of error could be checked
+type sqlError struct {
+	*pgconn.PgError
+}
+
+// Error ...
+func (e *sqlError) Error() string {
+	return fmt.Sprintf(
+		"SQL error: %s, Detail: %s, Where: %s, State: %s, Code: %s",
+		e.Message, e.Detail, e.Where, e.SQLState(), e.Code,
+	)
+}
+
+// Unwrap ...
+func (e *sqlError) Unwrap() error {
+	return e.PgError
+}
+
 // pgError checks err implements postgres error or not. If implements then returns error with postgres format or returns error
 func pgError(format string, err error) error {
 	if pgErr, ok := err.(*pgconn.PgError); ok {
-		err = fmt.Errorf(
-			"SQL error: %s, Detail: %s, Where: %s, State: %s, Code: %s",
-			pgErr.Message, pgErr.Detail, pgErr.Where, pgErr.SQLState(), pgErr.Code,
-		)
+		err = &sqlError{pgErr}
 	}
 	return fmt.Errorf(format, err)
 }
@@ -0,0 +1,70 @@
+package sqlstore
+
+import (
+	"expvar"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// metrics of storage are published with expvar under "sqlstore" key
+var metrics = expvar.NewMap("sqlstore")
+
+const (
+	// metricPool is stats of pool of primary
+	metricPool = "pool"
+	// metricReplicaPools is stats of pools of replicas by their hosts
+	metricReplicaPools  = "replica_pools"
+	metricQueryTimeouts = "query_timeouts"
+)
+
+// poolStats is snapshot of pgxpool.Stat.
+type poolStats struct {
+	MaxConns                int32   `json:"max_conns"`
+	TotalConns              int32   `json:"total_conns"`
+	AcquiredConns           int32   `json:"acquired_conns"`
+	IdleConns               int32   `json:"idle_conns"`
+	ConstructingConns       int32   `json:"constructing_conns"`
+	AcquireCount            int64   `json:"acquire_count"`
+	AcquireDurationSeconds  float64 `json:"acquire_duration_seconds"`
+	CanceledAcquireCount    int64   `json:"canceled_acquire_count"`
+	EmptyAcquireCount       int64   `json:"empty_acquire_count"`
+	NewConnsCount           int64   `json:"new_conns_count"`
+	MaxLifetimeDestroyCount int64   `json:"max_lifetime_destroy_count"`
+	MaxIdleDestroyCount     int64   `json:"max_idle_destroy_count"`
+}
+
+// statsOf ...
+func statsOf(pool *pgxpool.Pool) *poolStats {
+	s := pool.Stat()
+	return &poolStats{
+		MaxConns:                s.MaxConns(),
+		TotalConns:              s.TotalConns(),
+		AcquiredConns:           s.AcquiredConns(),
+		IdleConns:               s.IdleConns(),
+		ConstructingConns:       s.ConstructingConns(),
+		AcquireCount:            s.AcquireCount(),
+		AcquireDurationSeconds:  s.AcquireDuration().Seconds(),
+		CanceledAcquireCount:    s.CanceledAcquireCount(),
+		EmptyAcquireCount:       s.EmptyAcquireCount(),
+		NewConnsCount:           s.NewConnsCount(),
+		MaxLifetimeDestroyCount: s.MaxLifetimeDestroyCount(),
+		MaxIdleDestroyCount:     s.MaxIdleDestroyCount(),
+	}
+}
+
+// publishStats publishes stats of pools of storage s; stats of storage created later replace them.
+func publishStats(s *storage) {
+	metrics.Set(metricPool, expvar.Func(func() interface{} {
+		return statsOf(s.pool)
+	}))
+	if s.replicas == nil {
+		return
+	}
+	metrics.Set(metricReplicaPools, expvar.Func(func() interface{} {
+		res := make(map[string]*poolStats, len(s.replicas.replicas))
+		for _, r := range s.replicas.replicas {
+			res[r.host] = statsOf(r.pool)
+		}
+		return res
+	}))
+}
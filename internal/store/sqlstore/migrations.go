@@ -0,0 +1,193 @@
+package sqlstore
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/vlad-marlo/gophermart/internal/config"
+	"github.com/vlad-marlo/gophermart/internal/store"
+	"github.com/vlad-marlo/gophermart/pkg/logger"
+)
+
+// migrationsLockKey is key of advisory lock which is held while migrations are applied, so concurrently started
+// instances of application don't migrate database at the same time
+const migrationsLockKey int64 = 7_412_305_119
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+type (
+	// Migrator applies and reverts versioned migrations of database scheme.
+	Migrator struct {
+		*store.MigrationRunner
+		db *pgxpool.Pool
+		// owned is true if db must be closed by migrator
+		owned bool
+	}
+	// migrationDialect takes migrations lock of PostgreSQL with advisory lock.
+	migrationDialect struct {
+		db     *pgxpool.Pool
+		logger logger.Logger
+	}
+	// migrationConn applies every migration in its own transaction.
+	migrationConn struct {
+		conn   *pgxpool.Conn
+		logger logger.Logger
+	}
+)
+
+// NewMigrator connects to database from config.
+func NewMigrator(ctx context.Context, l logger.Logger, c *config.Config) (*Migrator, error) {
+	db, err := pgxpool.Connect(ctx, c.DBURI)
+	if err != nil {
+		return nil, pgError("sql open: %v", err)
+	}
+
+	m, err := newMigrator(db, l)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	m.owned = true
+	return m, nil
+}
+
+// newMigrator returns migrator of db with embedded migrations.
+func newMigrator(db *pgxpool.Pool, l logger.Logger) (*Migrator, error) {
+	migrations, err := store.LoadMigrations(migrationsFS, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("load migrations: %w", err)
+	}
+	return &Migrator{
+		MigrationRunner: store.NewMigrationRunner(&migrationDialect{db: db, logger: l}, l, migrations),
+		db:              db,
+	}, nil
+}
+
+// Close closes connection to database if it was opened by migrator.
+func (m *Migrator) Close() {
+	if m.owned {
+		m.db.Close()
+	}
+}
+
+// Lock runs f on connection which holds migrations lock.
+func (d *migrationDialect) Lock(ctx context.Context, f func(c store.MigrationConn) error) error {
+	conn, err := d.db.Acquire(ctx)
+	if err != nil {
+		return pgError("acquire connection: %w", err)
+	}
+	defer conn.Release()
+
+	// waiting for lock and migrations could take longer than statement timeout of pool
+	if _, err := conn.Exec(ctx, "SET statement_timeout = 0;"); err != nil {
+		return pgError("disable statement timeout: %w", err)
+	}
+	defer func() {
+		if _, err := conn.Exec(context.Background(), "RESET statement_timeout;"); err != nil {
+			d.logger.Errorf("migrations: reset statement timeout: %v", err)
+		}
+	}()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1);", migrationsLockKey); err != nil {
+		return pgError("advisory lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1);", migrationsLockKey); err != nil {
+			d.logger.Errorf("migrations: advisory unlock: %v", err)
+		}
+	}()
+
+	q := debugQuery(`
+		CREATE TABLE IF NOT EXISTS schema_migrations(
+			version BIGINT PRIMARY KEY,
+			name VARCHAR NOT NULL,
+			applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+	`)
+	if _, err := conn.Exec(ctx, q); err != nil {
+		return pgError("create migrations table: %w", err)
+	}
+
+	return f(&migrationConn{conn: conn, logger: d.logger})
+}
+
+// Applied ...
+func (c *migrationConn) Applied(ctx context.Context) (map[int]time.Time, error) {
+	rows, err := c.conn.Query(ctx, "SELECT version, applied_at FROM schema_migrations;")
+	if err != nil {
+		return nil, pgError("query: %w", err)
+	}
+
+	defer rows.Close()
+
+	res := make(map[int]time.Time)
+	for rows.Next() {
+		var (
+			version int
+			t       time.Time
+		)
+		if err := rows.Scan(&version, &t); err != nil {
+			return nil, pgError("rows scan: %w", err)
+		}
+		res[version] = t
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, pgError("rows err: %w", err)
+	}
+	return res, nil
+}
+
+// Apply ...
+func (c *migrationConn) Apply(ctx context.Context, mig *store.Migration) error {
+	return c.inTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, mig.Up); err != nil {
+			return pgError(fmt.Sprintf("migration %d_%s: up: %%w", mig.Version, mig.Name), err)
+		}
+		if _, err := tx.Exec(ctx, "INSERT INTO schema_migrations(version, name) VALUES ($1, $2);", mig.Version, mig.Name); err != nil {
+			return pgError("insert migration: %w", err)
+		}
+		return nil
+	})
+}
+
+// Revert ...
+func (c *migrationConn) Revert(ctx context.Context, mig *store.Migration) error {
+	return c.inTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, mig.Down); err != nil {
+			return pgError(fmt.Sprintf("migration %d_%s: down: %%w", mig.Version, mig.Name), err)
+		}
+		if _, err := tx.Exec(ctx, "DELETE FROM schema_migrations WHERE version = $1;", mig.Version); err != nil {
+			return pgError("delete migration: %w", err)
+		}
+		return nil
+	})
+}
+
+// inTx runs f in transaction which is committed if f succeeded.
+func (c *migrationConn) inTx(ctx context.Context, f func(tx pgx.Tx) error) error {
+	tx, err := c.conn.Begin(ctx)
+	if err != nil {
+		return pgError("start transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			c.logger.Errorf("migrations: unable to rollback: %v", err)
+		}
+	}()
+
+	if err := f(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return pgError("commit: %w", err)
+	}
+	return nil
+}
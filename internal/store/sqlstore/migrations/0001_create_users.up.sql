@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS users(
+	id BIGSERIAL UNIQUE PRIMARY KEY NOT NULL,
+	login VARCHAR UNIQUE NOT NULL,
+	password VARCHAR NOT NULL,
+	balance DOUBLE PRECISION DEFAULT 0::DOUBLE PRECISION
+);
@@ -0,0 +1,38 @@
+CREATE TABLE IF NOT EXISTS orders(
+	pk BIGSERIAL PRIMARY KEY,
+	id VARCHAR,
+	user_id BIGINT,
+	status VARCHAR(50) DEFAULT 'NEW',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	accrual DOUBLE PRECISION DEFAULT 0::DOUBLE PRECISION,
+	FOREIGN KEY (user_id) REFERENCES users(id),
+	CONSTRAINT correct_status CHECK ( status IN('NEW', 'PROCESSING', 'INVALID', 'PROCESSED', 'CANCELLED') )
+);
+-- databases created before versioned migrations could have previous scheme of orders
+ALTER TABLE orders ALTER COLUMN id TYPE VARCHAR USING id::VARCHAR;
+-- number is unique only among not cancelled orders, so cancelled number could be registered again
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_id_key;
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS correct_status;
+ALTER TABLE orders ADD CONSTRAINT
+	correct_status CHECK ( status IN('NEW', 'PROCESSING', 'INVALID', 'PROCESSED', 'CANCELLED') );
+CREATE UNIQUE INDEX IF NOT EXISTS
+	index_orders_active_number
+ON orders(id) WHERE status <> 'CANCELLED';
+CREATE INDEX IF NOT EXISTS
+	index_user_id_orders
+ON orders(user_id);
+CREATE INDEX IF NOT EXISTS
+	index_orders_number
+ON orders(id);
+
+CREATE TABLE IF NOT EXISTS order_status_history(
+	id BIGSERIAL PRIMARY KEY,
+	order_pk BIGINT NOT NULL,
+	status VARCHAR(50) NOT NULL,
+	accrual DOUBLE PRECISION DEFAULT 0::DOUBLE PRECISION,
+	changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (order_pk) REFERENCES orders(pk) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS
+	index_order_status_history_order
+ON order_status_history(order_pk);
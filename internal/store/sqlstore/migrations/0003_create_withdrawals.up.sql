@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS withdrawals(
+	id BIGSERIAL UNIQUE PRIMARY KEY,
+	processed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	user_id BIGINT,
+	order_id VARCHAR,
+	order_sum DOUBLE PRECISION DEFAULT 0::DOUBLE PRECISION,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+-- databases created before versioned migrations could store numbers as BIGINT
+ALTER TABLE withdrawals ALTER COLUMN order_id TYPE VARCHAR USING order_id::VARCHAR;
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS transfers(
+	id BIGSERIAL PRIMARY KEY,
+	sender_id BIGINT NOT NULL,
+	recipient_id BIGINT NOT NULL,
+	amount DOUBLE PRECISION NOT NULL,
+	idempotency_key VARCHAR,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (sender_id) REFERENCES users(id),
+	FOREIGN KEY (recipient_id) REFERENCES users(id),
+	CONSTRAINT unique_sender_idempotency_key UNIQUE (sender_id, idempotency_key),
+	CONSTRAINT positive_amount CHECK ( amount > 0 )
+);
+CREATE INDEX IF NOT EXISTS
+	index_transfers_sender
+ON transfers(sender_id, created_at);
+CREATE INDEX IF NOT EXISTS
+	index_transfers_recipient
+ON transfers(recipient_id);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS index_orders_next_check;
+ALTER TABLE orders
+	DROP COLUMN IF EXISTS next_check_at,
+	DROP COLUMN IF EXISTS attempts,
+	DROP COLUMN IF EXISTS needs_attention,
+	DROP COLUMN IF EXISTS locked_by,
+	DROP COLUMN IF EXISTS locked_until;
@@ -0,0 +1,11 @@
+-- scheduling of checks in accrual system
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS next_check_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS attempts INT DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS needs_attention BOOLEAN DEFAULT FALSE;
+CREATE INDEX IF NOT EXISTS
+	index_orders_next_check
+ON orders(next_check_at) WHERE status IN('NEW', 'PROCESSING');
+
+-- lease of order by instance of application which is checking it in accrual system
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS locked_by VARCHAR;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP;
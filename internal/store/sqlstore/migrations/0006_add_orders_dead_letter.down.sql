@@ -0,0 +1,5 @@
+ALTER TABLE orders
+	DROP COLUMN IF EXISTS failures,
+	DROP COLUMN IF EXISTS last_error,
+	DROP COLUMN IF EXISTS last_payload,
+	DROP COLUMN IF EXISTS dead_lettered_at;
@@ -0,0 +1,5 @@
+-- orders which state could not be resolved from answers of accrual system
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS failures INT DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS last_error TEXT;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS last_payload TEXT;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS dead_lettered_at TIMESTAMP;
@@ -0,0 +1,22 @@
+package sqlstore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vlad-marlo/gophermart/internal/store"
+)
+
+func TestLoadMigrations_Embedded(t *testing.T) {
+	migrations, err := store.LoadMigrations(migrationsFS, "migrations")
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	// versions are sequential, so gaps made by merge conflicts are noticed
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version, "migration %s", m.Name)
+		assert.NotEmpty(t, m.Up)
+		assert.NotEmpty(t, m.Down)
+	}
+}
@@ -0,0 +1,56 @@
+package sqlstore_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vlad-marlo/gophermart/internal/config"
+	"github.com/vlad-marlo/gophermart/internal/store/sqlstore"
+	"github.com/vlad-marlo/gophermart/pkg/logger"
+)
+
+func TestMigrator(t *testing.T) {
+	if conStr == "" {
+		t.Skip("connect string is not provided")
+	}
+
+	ctx := context.Background()
+	defer logger.DeleteLogFolderAndFile(t)
+
+	cfg := config.TestConfig(t)
+	cfg.DBURI = conStr
+
+	m, err := sqlstore.NewMigrator(ctx, logger.GetLogger(), cfg)
+	require.NoError(t, err)
+	defer m.Close()
+
+	_, err = m.Up(ctx)
+	require.NoError(t, err)
+
+	states, err := m.Status(ctx)
+	require.NoError(t, err)
+	require.NotEmpty(t, states)
+	for _, s := range states {
+		assert.NotNilf(t, s.AppliedAt, "migration %d_%s is not applied", s.Version, s.Name)
+	}
+
+	// applied migrations are not applied again
+	n, err := m.Up(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	last := states[len(states)-1]
+	reverted, err := m.Down(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, last.Version, reverted.Version)
+
+	states, err = m.Status(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, states[len(states)-1].AppliedAt)
+
+	n, err = m.Up(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
@@ -2,6 +2,7 @@ package sqlstore
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/jackc/pgconn"
@@ -13,67 +14,160 @@ import (
 	"github.com/vlad-marlo/gophermart/internal/store"
 )
 
+// registeredOrdersChannel is name of channel which receives notifications about registered orders
+const registeredOrdersChannel = "registered_orders"
+
 type orderRepository struct {
 	s *storage
 }
 
-func (o *orderRepository) Migrate(ctx context.Context) error {
+func (o *orderRepository) Register(ctx context.Context, user int, number model.OrderNumber) error {
 	q := debugQuery(`
-		CREATE TABLE IF NOT EXISTS orders(
-			pk BIGSERIAL PRIMARY KEY,
-			id BIGINT UNIQUE,
-			user_id BIGINT,
-			status VARCHAR(50) DEFAULT 'NEW',
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			accrual DOUBLE PRECISION DEFAULT 0::DOUBLE PRECISION,
-			FOREIGN KEY (user_id) REFERENCES users(id),
-			CONSTRAINT correct_status CHECK ( status IN('NEW', 'PROCESSING', 'INVALID', 'PROCESSED') )
-		);
-		CREATE INDEX IF NOT EXISTS
-			index_user_id_orders
-		ON orders(user_id);
-		CREATE INDEX IF NOT EXISTS
-			index_orders_number
-		ON orders(id);
-	`)
-
-	if _, err := o.s.db.Exec(ctx, q); err != nil {
-		return pgError("exec query: %w", err)
-	}
+	WITH o AS (
+		INSERT INTO
+			orders(id, user_id)
+		VALUES
+			($1, $2)
+		RETURNING pk, id, user_id, status
+	), h AS (
+		INSERT INTO
+			order_status_history(order_pk, status)
+		SELECT
+			pk, status
+		FROM o
+	)
+	SELECT
+		pg_notify($3, json_build_object('number', id, 'user', user_id, 'status', status)::TEXT)
+	FROM o;
+	`)
 
-	return nil
-}
+	// order is inserted in its own transaction, which is savepoint if storage is bound to transaction, so violation of
+	// unique constraint doesn't abort outer transaction
+	tx, err := o.s.db.Begin(ctx)
+	if err != nil {
+		return pgError("start transaction: %w", err)
+	}
 
-func (o *orderRepository) Register(ctx context.Context, user, number int) error {
-	q := debugQuery(`
-	INSERT INTO
-		orders(id, user_id)
-	VALUES
-		($1, $2);
-	`)
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			o.s.logger.Errorf("register: unable to rollback: %v", err)
+		}
+	}()
 
-	if _, err := o.s.db.Exec(ctx, q, number, user); err != nil {
+	if _, err := tx.Exec(ctx, q, number, user, registeredOrdersChannel); err != nil {
 		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == pgerrcode.UniqueViolation {
+			if err := tx.Rollback(ctx); err != nil {
+				return pgError("rollback: %w", err)
+			}
 			return o.getErrByNum(ctx, user, number)
 		}
 		return pgError("exec: %w", err)
 	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return pgError("commit: %w", err)
+	}
+	o.s.wrote(user)
 	return nil
 }
 
+func (o *orderRepository) RegisterBatch(ctx context.Context, user int, numbers []model.OrderNumber) ([]*model.OrderRegistration, error) {
+	// notifications are delivered to listeners after commit
+	qRegister := debugQuery(`
+	WITH o AS (
+		INSERT INTO
+			orders(id, user_id)
+		VALUES
+			($1, $2)
+		ON CONFLICT (id) WHERE status <> 'CANCELLED' DO NOTHING
+		RETURNING pk, id, user_id, status
+	), h AS (
+		INSERT INTO
+			order_status_history(order_pk, status)
+		SELECT
+			pk, status
+		FROM o
+	)
+	SELECT
+		pk, pg_notify($3, json_build_object('number', id, 'user', user_id, 'status', status)::TEXT)
+	FROM o;
+	`)
+	qGetOwner := debugQuery(`
+	SELECT
+		user_id
+	FROM
+		orders
+	WHERE
+		id = $1 AND status <> 'CANCELLED';
+	`)
+
+	tx, err := o.s.db.Begin(ctx)
+	if err != nil {
+		return nil, pgError("start transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			o.s.logger.Errorf("register batch: unable to rollback: %v", err)
+		}
+	}()
+
+	res := make([]*model.OrderRegistration, 0, len(numbers))
+	for _, number := range numbers {
+		r := &model.OrderRegistration{
+			Number: number,
+			Result: model.RegistrationAccepted,
+		}
+
+		var pk int
+		err := tx.QueryRow(ctx, qRegister, number, user, registeredOrdersChannel).Scan(&pk, nil)
+		if err != nil {
+			if !errors.Is(err, pgx.ErrNoRows) {
+				return nil, pgError("register: %w", err)
+			}
+
+			var owner int
+			if err := tx.QueryRow(ctx, qGetOwner, number).Scan(&owner); err != nil {
+				return nil, pgError("get owner: %w", err)
+			}
+
+			r.Result = model.RegistrationDuplicateOther
+			if owner == user {
+				r.Result = model.RegistrationDuplicateOwn
+			}
+		}
+
+		res = append(res, r)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, pgError("commit: %w", err)
+	}
+	o.s.wrote(user)
+	return res, nil
+}
+
 func (o *orderRepository) GetAllByUser(ctx context.Context, user int) (orders []*model.Order, err error) {
+	err = o.s.read(ctx, user, func(db querier) (err error) {
+		orders, err = o.getAllByUser(ctx, db, user)
+		return err
+	})
+	return orders, err
+}
+
+func (o *orderRepository) getAllByUser(ctx context.Context, db querier, user int) (orders []*model.Order, err error) {
 	q := debugQuery(`
 		SELECT 
 			x.id, x.status, x.accrual::FLOAT8, x.created_at
 		FROM
 		    orders x
 		WHERE
-		    x.user_id = $1
+		    x.user_id = $1 AND x.status <> 'CANCELLED'
 		ORDER BY
 		    x.created_at;
 	`)
 
-	rows, err := o.s.db.Query(ctx, q, user)
+	rows, err := db.Query(ctx, q, user)
 	if err != nil {
 		return nil, pgError("query: %w", err)
 	}
@@ -102,7 +196,7 @@ func (o *orderRepository) GetAllByUser(ctx context.Context, user int) (orders []
 	return orders, nil
 }
 
-func (o *orderRepository) getErrByNum(ctx context.Context, user, number int) error {
+func (o *orderRepository) getErrByNum(ctx context.Context, user int, number model.OrderNumber) error {
 	q := debugQuery(`
 	SELECT EXISTS(
 		SELECT
@@ -110,14 +204,14 @@ func (o *orderRepository) getErrByNum(ctx context.Context, user, number int) err
 		FROM
 			orders
 		WHERE
-			id = $1 AND user_id = $2
+			id = $1 AND user_id = $2 AND status <> 'CANCELLED'
 	), EXISTS(
 	    SELECT
 	        *
 	    FROM
 	        orders
 	    WHERE
-	        id = $1
+	        id = $1 AND status <> 'CANCELLED'
 	);`)
 
 	var statusByUser, statusByNum bool
@@ -132,67 +226,542 @@ func (o *orderRepository) getErrByNum(ctx context.Context, user, number int) err
 }
 
 func (o *orderRepository) ChangeStatus(ctx context.Context, user int, m *model.OrderInAccrual) error {
-	q := debugQuery(`
+	tx, err := o.s.db.Begin(ctx)
+	if err != nil {
+		return pgError("start transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			o.s.logger.Errorf("change status: unable to rollback: %v", err)
+		}
+	}()
+
+	if _, err := o.updateStatus(ctx, tx, user, m); err != nil {
+		return fmt.Errorf("update status: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return pgError("commit: %w", err)
+	}
+	o.s.wrote(user)
+	return nil
+}
+
+// updateStatus changes status of order in transaction and writes history record if status was changed. Repeated
+// update of order to its final status is not applied and applied is false. Returns store.ErrNoContent if there is no
+// active order with such number registered by user and *model.TransitionError if order can't get status m.Status.
+func (o *orderRepository) updateStatus(
+	ctx context.Context,
+	tx pgx.Tx,
+	user int,
+	m *model.OrderInAccrual,
+) (applied bool, err error) {
+	qGetStatus := debugQuery(`
+		SELECT
+			pk, status
+		FROM
+			orders
+		WHERE
+			id = $1 AND user_id = $2 AND status <> 'CANCELLED'
+		FOR UPDATE;
+	`)
+	qUpdateStatus := debugQuery(`
 		UPDATE
 			orders
 		SET
 			status = $1,
 			accrual = $2::DOUBLE PRECISION
 		WHERE
-			id = $3 AND user_id = $4;
+			pk = $3;
 	`)
+	qInsertHistory := debugQuery(`
+		INSERT INTO
+			order_status_history(order_pk, status, accrual)
+		VALUES
+			($1, $2, $3::DOUBLE PRECISION);
+	`)
+
+	var (
+		pk     int
+		status string
+	)
+	if err := tx.QueryRow(ctx, qGetStatus, m.Number, user).Scan(&pk, &status); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, store.ErrNoContent
+		}
+		return false, pgError("get status: %w", err)
+	}
 
-	if _, err := o.s.db.Exec(ctx, q, m.Status, m.Accrual, m.Number, user); err != nil {
+	// row is locked, so concurrent updates of the same order see final status set by the first of them
+	if model.IsFinalStatus(status) && status == m.Status {
+		return false, nil
+	}
+	if err := model.ValidateTransition(status, m.Status); err != nil {
+		return false, err
+	}
+
+	if _, err := tx.Exec(ctx, qUpdateStatus, m.Status, m.Accrual, pk); err != nil {
+		return false, pgError("update order: %w", err)
+	}
+
+	if status == m.Status {
+		return true, nil
+	}
+
+	if _, err := tx.Exec(ctx, qInsertHistory, pk, m.Status, m.Accrual); err != nil {
+		return false, pgError("insert history: %w", err)
+	}
+	return true, nil
+}
+
+func (o *orderRepository) GetActiveByNumber(ctx context.Context, number model.OrderNumber) (*model.OrderInPoll, error) {
+	q := debugQuery(`
+		SELECT
+			x.id, x.status, x.user_id, x.attempts, x.created_at
+		FROM
+			orders x
+		WHERE
+			x.id = $1 AND x.status <> 'CANCELLED';
+	`)
+
+	order := new(model.OrderInPoll)
+	if err := o.s.db.QueryRow(ctx, q, number).Scan(
+		&order.Number,
+		&order.Status,
+		&order.User,
+		&order.Attempts,
+		&order.UploadedAt,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, store.ErrNoContent
+		}
+		return nil, pgError("query row: %w", err)
+	}
+	return order, nil
+}
+
+func (o *orderRepository) GetByNumber(ctx context.Context, user int, number model.OrderNumber) (*model.Order, error) {
+	qGetOrder := debugQuery(`
+		SELECT
+			x.pk, x.id, x.status, x.accrual::FLOAT8, x.created_at
+		FROM
+			orders x
+		WHERE
+			x.id = $1 AND x.user_id = $2 AND x.status <> 'CANCELLED';
+	`)
+	qGetHistory := debugQuery(`
+		SELECT
+			h.status, h.accrual::FLOAT8, h.changed_at
+		FROM
+			order_status_history h
+		WHERE
+			h.order_pk = $1
+		ORDER BY
+			h.changed_at, h.id;
+	`)
+
+	var (
+		pk         int
+		uploadedAt time.Time
+	)
+	order := new(model.Order)
+
+	if err := o.s.db.QueryRow(ctx, qGetOrder, number, user).Scan(
+		&pk,
+		&order.Number,
+		&order.Status,
+		&order.Accrual,
+		&uploadedAt,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, store.ErrNoContent
+		}
+		return nil, pgError("get order: %w", err)
+	}
+	order.UploadedAt = uploadedAt.Format(time.RFC3339)
+
+	rows, err := o.s.db.Query(ctx, qGetHistory, pk)
+	if err != nil {
+		return nil, pgError("get history: %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var t time.Time
+		c := new(model.OrderStatusChange)
+
+		if err := rows.Scan(&c.Status, &c.Accrual, &t); err != nil {
+			return nil, pgError("rows scan: %w", err)
+		}
+		c.ChangedAt = t.Format(time.RFC3339)
+		order.History = append(order.History, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, pgError("rows err: %w", err)
+	}
+
+	return order, nil
+}
+
+func (o *orderRepository) Cancel(ctx context.Context, user int, number model.OrderNumber) error {
+	qGetStatus := debugQuery(`
+		SELECT
+			pk, status
+		FROM
+			orders
+		WHERE
+			id = $1 AND user_id = $2 AND status <> 'CANCELLED'
+		FOR UPDATE;
+	`)
+	qCancel := debugQuery(`
+		UPDATE
+			orders
+		SET
+			status = 'CANCELLED'
+		WHERE
+			pk = $1;
+	`)
+	qInsertHistory := debugQuery(`
+		INSERT INTO
+			order_status_history(order_pk, status)
+		VALUES
+			($1, 'CANCELLED');
+	`)
+
+	tx, err := o.s.db.Begin(ctx)
+	if err != nil {
+		return pgError("start transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			o.s.logger.Errorf("cancel: unable to rollback: %v", err)
+		}
+	}()
+
+	var (
+		pk     int
+		status string
+	)
+	if err := tx.QueryRow(ctx, qGetStatus, number, user).Scan(&pk, &status); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return store.ErrNoContent
+		}
+		return pgError("get status: %w", err)
+	}
+
+	if !model.CanTransition(status, model.StatusCancelled) {
+		return store.ErrNotCancellable
+	}
+
+	if _, err := tx.Exec(ctx, qCancel, pk); err != nil {
+		return pgError("cancel: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, qInsertHistory, pk); err != nil {
+		return pgError("insert history: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return pgError("commit: %w", err)
+	}
+	o.s.wrote(user)
+	return nil
+}
+
+func (o *orderRepository) ListenRegistered(ctx context.Context, orders chan<- *model.OrderInPoll) error {
+	c, err := o.s.pool.Acquire(ctx)
+	if err != nil {
+		return pgError("acquire connection: %w", err)
+	}
+	// connection is removed from pool because it stays subscribed to channel
+	conn := c.Hijack()
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			o.s.logger.Warnf("listen registered: close connection: %v", err)
+		}
+	}()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+registeredOrdersChannel); err != nil {
+		return pgError("listen: %w", err)
+	}
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return pgError("wait for notification: %w", err)
+		}
+
+		order := new(model.OrderInPoll)
+		if err := json.Unmarshal([]byte(n.Payload), order); err != nil {
+			o.s.logger.Warnf("listen registered: json unmarshal: %v", err)
+			continue
+		}
+
+		select {
+		case orders <- order:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (o *orderRepository) ClaimUnprocessedOrders(
+	ctx context.Context,
+	owner string,
+	limit int,
+	lease time.Duration,
+) (res []*model.OrderInPoll, err error) {
+	// rows locked by concurrent claim are skipped, so instances never claim the same order
+	q := debugQuery(`
+		UPDATE
+			orders o
+		SET
+			locked_by = $1,
+			locked_until = CURRENT_TIMESTAMP + $3::DOUBLE PRECISION * INTERVAL '1 second'
+		FROM (
+			SELECT
+				x.pk
+			FROM
+				orders x
+			WHERE
+				x.status IN('NEW', 'PROCESSING')
+				AND x.next_check_at <= CURRENT_TIMESTAMP
+				AND NOT x.needs_attention
+				AND x.dead_lettered_at IS NULL
+				AND (x.locked_until IS NULL OR x.locked_until < CURRENT_TIMESTAMP OR x.locked_by = $1)
+			ORDER BY
+				x.next_check_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		) c
+		WHERE
+			o.pk = c.pk
+		RETURNING
+			o.id, o.status, o.user_id, o.attempts, o.created_at;
+	`)
+
+	rows, err := o.s.db.Query(ctx, q, owner, limit, lease.Seconds())
+	if err != nil {
+		return nil, pgError("db query: %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		o := new(model.OrderInPoll)
+		if err := rows.Scan(&o.Number, &o.Status, &o.User, &o.Attempts, &o.UploadedAt); err != nil {
+			return nil, pgError("rows scan: %w", err)
+		}
+		res = append(res, o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, pgError("rows err: %w", err)
+	}
+
+	return res, nil
+}
+
+func (o *orderRepository) ClaimOrder(
+	ctx context.Context,
+	owner string,
+	number model.OrderNumber,
+	lease time.Duration,
+) (bool, error) {
+	q := debugQuery(`
+		UPDATE
+			orders
+		SET
+			locked_by = $1,
+			locked_until = CURRENT_TIMESTAMP + $3::DOUBLE PRECISION * INTERVAL '1 second'
+		WHERE
+			id = $2
+			AND status IN('NEW', 'PROCESSING')
+			AND NOT needs_attention
+			AND dead_lettered_at IS NULL
+			AND (locked_until IS NULL OR locked_until < CURRENT_TIMESTAMP OR locked_by = $1);
+	`)
+
+	tag, err := o.s.db.Exec(ctx, q, owner, number, lease.Seconds())
+	if err != nil {
+		return false, pgError("exec: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (o *orderRepository) Postpone(ctx context.Context, owner string, number model.OrderNumber, delay time.Duration) error {
+	q := debugQuery(`
+		UPDATE
+			orders
+		SET
+			attempts = attempts + 1,
+			next_check_at = CURRENT_TIMESTAMP + $2::DOUBLE PRECISION * INTERVAL '1 second',
+			locked_by = NULL,
+			locked_until = NULL
+		WHERE
+			id = $1 AND status <> 'CANCELLED' AND locked_by = $3;
+	`)
+
+	tag, err := o.s.db.Exec(ctx, q, number, delay.Seconds(), owner)
+	if err != nil {
 		return pgError("exec: %w", err)
 	}
+	if tag.RowsAffected() == 0 {
+		return store.ErrLeaseLost
+	}
 	return nil
 }
 
-func (o *orderRepository) GetUnprocessedOrders(ctx context.Context) (res []*model.OrderInPoll, err error) {
-	// hardcoded; IDK is it ok
+func (o *orderRepository) Reschedule(ctx context.Context, owner string, number model.OrderNumber, delay time.Duration) error {
+	q := debugQuery(`
+		UPDATE
+			orders
+		SET
+			next_check_at = CURRENT_TIMESTAMP + $2::DOUBLE PRECISION * INTERVAL '1 second',
+			locked_by = NULL,
+			locked_until = NULL
+		WHERE
+			id = $1 AND status <> 'CANCELLED' AND locked_by = $3;
+	`)
+
+	tag, err := o.s.db.Exec(ctx, q, number, delay.Seconds(), owner)
+	if err != nil {
+		return pgError("exec: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return store.ErrLeaseLost
+	}
+	return nil
+}
+
+func (o *orderRepository) MarkNeedsAttention(ctx context.Context, owner string, number model.OrderNumber) error {
+	q := debugQuery(`
+		UPDATE
+			orders
+		SET
+			needs_attention = TRUE
+		WHERE
+			id = $1 AND status <> 'CANCELLED' AND locked_by = $2;
+	`)
+
+	tag, err := o.s.db.Exec(ctx, q, number, owner)
+	if err != nil {
+		return pgError("exec: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return store.ErrLeaseLost
+	}
+	return nil
+}
+
+func (o *orderRepository) RecordFailure(
+	ctx context.Context,
+	owner string,
+	number model.OrderNumber,
+	reason, payload string,
+	maxFailures int,
+) (deadLettered bool, err error) {
+	q := debugQuery(`
+		UPDATE
+			orders
+		SET
+			failures = failures + 1,
+			last_error = $2,
+			last_payload = NULLIF($3, ''),
+			dead_lettered_at = CASE
+				WHEN $4 > 0 AND failures + 1 >= $4 THEN CURRENT_TIMESTAMP
+				ELSE dead_lettered_at
+			END
+		WHERE
+			id = $1 AND status IN('NEW', 'PROCESSING') AND locked_by = $5
+		RETURNING
+			dead_lettered_at IS NOT NULL;
+	`)
+
+	if err := o.s.db.QueryRow(ctx, q, number, reason, payload, maxFailures, owner).Scan(&deadLettered); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, store.ErrLeaseLost
+		}
+		return false, pgError("query row: %w", err)
+	}
+	return deadLettered, nil
+}
+
+func (o *orderRepository) GetDeadLettered(ctx context.Context) (res []*model.DeadLetter, err error) {
 	q := debugQuery(`
 		SELECT
-		    x.id, x.status, x.user_id
+			x.id, x.user_id, x.status, x.failures, COALESCE(x.last_error, ''), COALESCE(x.last_payload, ''),
+			x.dead_lettered_at
 		FROM
-		    orders x
+			orders x
 		WHERE
-		    x.status != 'PROCESSED'
-			AND x.status != 'INVALID';
+			x.dead_lettered_at IS NOT NULL AND x.status IN('NEW', 'PROCESSING')
+		ORDER BY
+			x.dead_lettered_at;
 	`)
-	q = debugQuery(q)
 
 	rows, err := o.s.db.Query(ctx, q)
 	if err != nil {
-		return nil, pgError("db query: %s", err)
+		return nil, pgError("query: %w", err)
 	}
 
 	defer rows.Close()
 
 	for rows.Next() {
-		o := new(model.OrderInPoll)
-		if err := rows.Scan(&o.Number, &o.Status, &o.User); err != nil {
-			return nil, pgError("rows scan: %s", err)
+		var t time.Time
+		d := new(model.DeadLetter)
+
+		if err := rows.Scan(&d.Number, &d.User, &d.Status, &d.Failures, &d.LastError, &d.LastPayload, &t); err != nil {
+			return nil, pgError("rows scan: %w", err)
 		}
-		res = append(res, o)
+		d.DeadLetteredAt = t.Format(time.RFC3339)
+		res = append(res, d)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, pgError("rows err: %s", err)
+		return nil, pgError("rows err: %w", err)
 	}
 
+	if len(res) == 0 {
+		return nil, store.ErrNoContent
+	}
 	return res, nil
 }
 
-func (o *orderRepository) ChangeStatusAndIncrementUserBalance(ctx context.Context, user int, m *model.OrderInAccrual) error {
-	qUpdateStatus := debugQuery(`
+func (o *orderRepository) RetryDeadLettered(ctx context.Context, number model.OrderNumber) error {
+	q := debugQuery(`
 		UPDATE
 			orders
 		SET
-			status = $1,
-			accrual = $2::DOUBLE PRECISION
+			failures = 0,
+			dead_lettered_at = NULL,
+			needs_attention = FALSE,
+			next_check_at = CURRENT_TIMESTAMP,
+			locked_by = NULL,
+			locked_until = NULL
 		WHERE
-			id = $3 AND user_id = $4;
+			id = $1 AND status IN('NEW', 'PROCESSING') AND dead_lettered_at IS NOT NULL;
 	`)
+
+	tag, err := o.s.db.Exec(ctx, q, number)
+	if err != nil {
+		return pgError("exec: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return store.ErrNoContent
+	}
+	return nil
+}
+
+func (o *orderRepository) ChangeStatusAndIncrementUserBalance(
+	ctx context.Context,
+	user int,
+	m *model.OrderInAccrual,
+) (credited bool, err error) {
 	qIncrementBalance := debugQuery(`
 		UPDATE
 			users
@@ -204,7 +773,7 @@ func (o *orderRepository) ChangeStatusAndIncrementUserBalance(ctx context.Contex
 
 	tx, err := o.s.db.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("start transaction: %w", err)
+		return false, fmt.Errorf("start transaction: %w", err)
 	}
 
 	defer func() {
@@ -213,17 +782,22 @@ func (o *orderRepository) ChangeStatusAndIncrementUserBalance(ctx context.Contex
 		}
 	}()
 
-	if _, err := tx.Exec(ctx, qUpdateStatus, m.Status, m.Accrual, m.Number, user); err != nil {
-		return fmt.Errorf("update order: %w", err)
+	applied, err := o.updateStatus(ctx, tx, user, m)
+	if err != nil {
+		return false, fmt.Errorf("update order: %w", err)
+	}
+	// order was already finalized and credited
+	if !applied {
+		return false, nil
 	}
 
 	if _, err := tx.Exec(ctx, qIncrementBalance, m.Accrual, user); err != nil {
-		return fmt.Errorf("increment user balance: %w", err)
+		return false, fmt.Errorf("increment user balance: %w", err)
 	}
 
 	if err := tx.Commit(ctx); err != nil {
-		return fmt.Errorf("update drivers: unable to commmit: %w", err)
+		return false, fmt.Errorf("update drivers: unable to commmit: %w", err)
 	}
-
-	return nil
+	o.s.wrote(user)
+	return true, nil
 }
@@ -9,7 +9,10 @@ import (
 	"github.com/vlad-marlo/gophermart/internal/store"
 	"github.com/vlad-marlo/gophermart/internal/store/sqlstore"
 	"github.com/vlad-marlo/gophermart/pkg/logger"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestOrderRepository_ChangeStatus(t *testing.T) {
@@ -109,7 +112,7 @@ func TestOrderRepository_Register(t *testing.T) {
 
 	tests := []struct {
 		name     string
-		w        int
+		w        model.OrderNumber
 		user     *model.User
 		anotherU *model.User
 		wantErr  error
@@ -300,8 +303,9 @@ func TestOrderRepository_ChangeStatusAndIncrementBalance(t *testing.T) {
 			err = s.Order().Register(ctx, u.ID, tt.m.Number)
 			require.NoError(t, err, "register order: %v", err)
 
-			err = s.Order().ChangeStatusAndIncrementUserBalance(ctx, u.ID, tt.m)
+			credited, err := s.Order().ChangeStatusAndIncrementUserBalance(ctx, u.ID, tt.m)
 			assert.NoError(t, err)
+			assert.True(t, credited)
 
 			orders, err := s.Order().GetAllByUser(ctx, u.ID)
 			require.NoError(t, err)
@@ -324,80 +328,493 @@ func TestOrderRepository_ChangeStatusAndIncrementBalance(t *testing.T) {
 	}
 }
 
-func TestOrderRepository_GetUnprocessedOrders(t *testing.T) {
+func TestOrderRepository_GetByNumber(t *testing.T) {
 	if conStr == "" {
-		t.Skip("connect string wasn't provided")
+		t.Skip("connect string is not provided")
 	}
+
 	ctx := context.Background()
 
-	u := model.TestUser(t, userLogin1)
 	s, teardown := sqlstore.TestStore(t, conStr)
-	defer func() {
-		teardown(userTableName, ordersTableName)
-		logger.DeleteLogFolderAndFile(t)
-	}()
+	defer teardown(userTableName, ordersTableName)
+
+	u1 := model.TestUser(t, userLogin1)
+	u2 := model.TestUser(t, userLogin2)
+	for _, u := range []*model.User{u1, u2} {
+		err := s.User().Create(ctx, u)
+		require.NoErrorf(t, err, "create user: %v", err)
+	}
+
+	err := s.Order().Register(ctx, u1.ID, orderNum1)
+	require.NoErrorf(t, err, "register order: %v", err)
+
+	for _, m := range []*model.OrderInAccrual{
+		{Number: orderNum1, Status: model.StatusProcessing},
+		{Number: orderNum1, Status: model.StatusProcessing},
+		{Number: orderNum1, Status: model.StatusProcessed, Accrual: 500},
+	} {
+		err = s.Order().ChangeStatus(ctx, u1.ID, m)
+		require.NoErrorf(t, err, "change status: %v", err)
+	}
 
+	o, err := s.Order().GetByNumber(ctx, u1.ID, orderNum1)
+	require.NoError(t, err)
+	assert.Equal(t, orderNum1, o.Number)
+	assert.Equal(t, model.StatusProcessed, o.Status)
+	assert.Equal(t, 500.0, o.Accrual)
+
+	var statuses []string
+	for _, c := range o.History {
+		statuses = append(statuses, c.Status)
+	}
+	assert.Equal(t, []string{model.StatusNew, model.StatusProcessing, model.StatusProcessed}, statuses)
+	assert.Equal(t, 500.0, o.History[len(o.History)-1].Accrual)
+
+	_, err = s.Order().GetByNumber(ctx, u2.ID, orderNum1)
+	assert.ErrorIs(t, err, store.ErrNoContent)
+
+	_, err = s.Order().GetByNumber(ctx, u1.ID, orderNum2)
+	assert.ErrorIs(t, err, store.ErrNoContent)
+}
+
+func TestOrderRepository_RegisterBatch(t *testing.T) {
+	if conStr == "" {
+		t.Skip("connect string is not provided")
+	}
+
+	ctx := context.Background()
+
+	s, teardown := sqlstore.TestStore(t, conStr)
+	defer teardown(userTableName, ordersTableName)
+
+	u1 := model.TestUser(t, userLogin1)
+	u2 := model.TestUser(t, userLogin2)
+	for _, u := range []*model.User{u1, u2} {
+		err := s.User().Create(ctx, u)
+		require.NoErrorf(t, err, "create user: %v", err)
+	}
+
+	err := s.Order().Register(ctx, u1.ID, orderNum1)
+	require.NoErrorf(t, err, "register order: %v", err)
+	err = s.Order().Register(ctx, u2.ID, orderNum2)
+	require.NoErrorf(t, err, "register order: %v", err)
+
+	res, err := s.Order().RegisterBatch(ctx, u1.ID, []model.OrderNumber{orderNum1, orderNum2, orderNum3, orderNum3})
+	require.NoError(t, err)
+
+	assert.Equal(t, []*model.OrderRegistration{
+		{Number: orderNum1, Result: model.RegistrationDuplicateOwn},
+		{Number: orderNum2, Result: model.RegistrationDuplicateOther},
+		{Number: orderNum3, Result: model.RegistrationAccepted},
+		{Number: orderNum3, Result: model.RegistrationDuplicateOwn},
+	}, res)
+
+	o, err := s.Order().GetByNumber(ctx, u1.ID, orderNum3)
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusNew, o.Status)
+	assert.Len(t, o.History, 1)
+}
+
+func TestOrderRepository_Cancel(t *testing.T) {
+	if conStr == "" {
+		t.Skip("connect string is not provided")
+	}
+
+	ctx := context.Background()
+
+	s, teardown := sqlstore.TestStore(t, conStr)
+	defer teardown(userTableName, ordersTableName)
+
+	u1 := model.TestUser(t, userLogin1)
+	u2 := model.TestUser(t, userLogin2)
+	for _, u := range []*model.User{u1, u2} {
+		err := s.User().Create(ctx, u)
+		require.NoErrorf(t, err, "create user: %v", err)
+	}
+
+	for _, num := range []model.OrderNumber{orderNum1, orderNum2} {
+		err := s.Order().Register(ctx, u1.ID, num)
+		require.NoErrorf(t, err, "register order: %v", err)
+	}
+	err := s.Order().ChangeStatus(ctx, u1.ID, &model.OrderInAccrual{Number: orderNum2, Status: model.StatusProcessing})
+	require.NoErrorf(t, err, "change status: %v", err)
+
+	assert.ErrorIs(t, s.Order().Cancel(ctx, u2.ID, orderNum1), store.ErrNoContent)
+	assert.ErrorIs(t, s.Order().Cancel(ctx, u1.ID, orderNum2), store.ErrNotCancellable)
+	assert.ErrorIs(t, s.Order().Cancel(ctx, u1.ID, orderNum3), store.ErrNoContent)
+
+	require.NoError(t, s.Order().Cancel(ctx, u1.ID, orderNum1))
+	assert.ErrorIs(t, s.Order().Cancel(ctx, u1.ID, orderNum1), store.ErrNoContent)
+
+	// cancelled order is hidden from user and poller
+	_, err = s.Order().GetByNumber(ctx, u1.ID, orderNum1)
+	assert.ErrorIs(t, err, store.ErrNoContent)
+
+	ok, err := s.Order().ClaimOrder(ctx, "instance", orderNum1, time.Minute)
+	require.NoError(t, err)
+	assert.False(t, ok, "cancelled order is claimed")
+
+	// cancelled order is not credited
+	_, err = s.Order().ChangeStatusAndIncrementUserBalance(ctx, u1.ID, &model.OrderInAccrual{
+		Number:  orderNum1,
+		Status:  model.StatusProcessed,
+		Accrual: 100,
+	})
+	assert.ErrorIs(t, err, store.ErrNoContent)
+	bal, err := s.User().GetBalance(ctx, u1.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, bal.Current)
+
+	// number is free for registration
+	require.NoError(t, s.Order().Register(ctx, u2.ID, orderNum1))
+	o, err := s.Order().GetByNumber(ctx, u2.ID, orderNum1)
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusNew, o.Status)
+}
+
+func TestOrderRepository_ListenRegistered(t *testing.T) {
+	if conStr == "" {
+		t.Skip("connect string is not provided")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s, teardown := sqlstore.TestStore(t, conStr)
+	defer teardown(userTableName, ordersTableName)
+
+	u := model.TestUser(t, userLogin1)
 	err := s.User().Create(ctx, u)
-	require.NoError(t, err, "create user: %v", err)
+	require.NoErrorf(t, err, "create user: %v", err)
 
-	tt := []struct {
-		name      string
-		num       int
-		status    string
-		wantInGet bool
-	}{
-		{
-			name:      "positive case #1",
-			num:       orderNum1,
-			status:    model.StatusInvalid,
-			wantInGet: false,
-		},
-		{
-			name:      "positive case #2",
-			num:       orderNum2,
-			status:    model.StatusProcessing,
-			wantInGet: true,
-		},
-		{
-			name:      "positive case #3",
-			num:       orderNum3,
-			status:    model.StatusProcessed,
-			wantInGet: false,
-		},
-		{
-			name:      "positive case #4",
-			num:       orderNum4,
-			status:    model.StatusNew,
-			wantInGet: true,
-		},
+	orders := make(chan *model.OrderInPoll)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.Order().ListenRegistered(ctx, orders)
+	}()
+
+	// wait until listener is subscribed
+	time.Sleep(100 * time.Millisecond)
+
+	require.NoError(t, s.Order().Register(ctx, u.ID, orderNum1))
+	_, err = s.Order().RegisterBatch(ctx, u.ID, []model.OrderNumber{orderNum1, orderNum2})
+	require.NoError(t, err)
+
+	for _, want := range []model.OrderNumber{orderNum1, orderNum2} {
+		select {
+		case o := <-orders:
+			assert.Equal(t, want, o.Number)
+			assert.Equal(t, u.ID, o.User)
+			assert.Equal(t, model.StatusNew, o.Status)
+		case err := <-errs:
+			t.Fatalf("listen registered: %v", err)
+		case <-ctx.Done():
+			t.Fatal("notification was not received")
+		}
+	}
+}
+
+func TestOrderRepository_Postpone(t *testing.T) {
+	if conStr == "" {
+		t.Skip("connect string is not provided")
+	}
+
+	ctx := context.Background()
+
+	s, teardown := sqlstore.TestStore(t, conStr)
+	defer teardown(userTableName, ordersTableName)
+
+	u := model.TestUser(t, userLogin1)
+	require.NoError(t, s.User().Create(ctx, u))
+	for _, num := range []model.OrderNumber{orderNum1, orderNum2, orderNum3} {
+		require.NoError(t, s.Order().Register(ctx, u.ID, num))
 	}
 
-	for _, tc := range tt {
-		t.Run(tc.name, func(t *testing.T) {
-			err = s.Order().Register(ctx, u.ID, tc.num)
-			require.NoError(t, err, "orders: register: %v", err)
+	for _, num := range []model.OrderNumber{orderNum1, orderNum2} {
+		ok, err := s.Order().ClaimOrder(ctx, "first", num, time.Minute)
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+	require.NoError(t, s.Order().Postpone(ctx, "first", orderNum1, time.Hour))
+	require.NoError(t, s.Order().MarkNeedsAttention(ctx, "first", orderNum2))
+
+	orders, err := s.Order().ClaimUnprocessedOrders(ctx, "first", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	assert.Equal(t, orderNum3, orders[0].Number)
+	assert.Equal(t, 0, orders[0].Attempts)
+	assert.False(t, orders[0].UploadedAt.IsZero())
+
+	// zero delay makes order due again with incremented attempts
+	ok, err := s.Order().ClaimOrder(ctx, "first", orderNum1, time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.NoError(t, s.Order().Postpone(ctx, "first", orderNum1, 0))
+	orders, err = s.Order().ClaimUnprocessedOrders(ctx, "first", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, orders, 2)
+	for _, o := range orders {
+		if o.Number == orderNum1 {
+			assert.Equal(t, 1, o.Attempts)
+		}
+	}
+}
+
+func TestOrderRepository_ClaimUnprocessedOrders(t *testing.T) {
+	if conStr == "" {
+		t.Skip("connect string is not provided")
+	}
 
-			err = s.Order().ChangeStatus(ctx, u.ID, &model.OrderInAccrual{
-				Number:  tc.num,
-				Status:  tc.status,
-				Accrual: 0,
-			})
+	ctx := context.Background()
 
-			orders, err := s.Order().GetUnprocessedOrders(ctx)
-			require.NoError(t, err, "get unprocessed orders: %v", err)
+	s, teardown := sqlstore.TestStore(t, conStr)
+	defer teardown(userTableName, ordersTableName)
 
-			if len(orders) >= 1 {
-				lastOrder := orders[len(orders)-1]
+	u := model.TestUser(t, userLogin1)
+	require.NoError(t, s.User().Create(ctx, u))
+	for _, num := range []model.OrderNumber{orderNum1, orderNum2, orderNum3} {
+		require.NoError(t, s.Order().Register(ctx, u.ID, num))
+	}
 
-				if tc.wantInGet {
-					require.Equal(t, lastOrder.Number, tc.num, "doesn't exist in unprocessed orders")
-				} else {
-					require.NotEqualf(t, lastOrder.Number, tc.num, "exist in unprocessed orders")
-				}
+	const (
+		owner1 = "instance-1"
+		owner2 = "instance-2"
+	)
+
+	claimed1, err := s.Order().ClaimUnprocessedOrders(ctx, owner1, 2, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed1, 2)
+
+	// another instance gets only order which was not claimed
+	claimed2, err := s.Order().ClaimUnprocessedOrders(ctx, owner2, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed2, 1)
+	for _, o := range claimed1 {
+		assert.NotEqual(t, claimed2[0].Number, o.Number, "order was claimed twice")
+	}
 
-			} else {
-				require.False(t, tc.wantInGet, "doesn't exist in unprocessed orders")
+	ok, err := s.Order().ClaimOrder(ctx, owner2, claimed1[0].Number, time.Minute)
+	require.NoError(t, err)
+	assert.False(t, ok, "order leased by another instance was claimed")
+
+	ok, err = s.Order().ClaimOrder(ctx, owner1, claimed1[0].Number, time.Minute)
+	require.NoError(t, err)
+	assert.True(t, ok, "owner was not able to renew lease")
+
+	// expired lease could be claimed by another instance
+	ok, err = s.Order().ClaimOrder(ctx, owner1, claimed1[1].Number, -time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = s.Order().ClaimOrder(ctx, owner2, claimed1[1].Number, time.Minute)
+	require.NoError(t, err)
+	assert.True(t, ok, "expired lease was not claimed")
+
+	// postponed order is released but is not due
+	assert.ErrorIs(t, s.Order().Postpone(ctx, owner1, claimed2[0].Number, time.Hour), store.ErrLeaseLost)
+	require.NoError(t, s.Order().Postpone(ctx, owner2, claimed2[0].Number, time.Hour))
+	claimed, err := s.Order().ClaimUnprocessedOrders(ctx, owner1, 10, time.Minute)
+	require.NoError(t, err)
+	for _, o := range claimed {
+		assert.NotEqual(t, claimed2[0].Number, o.Number, "postponed order was claimed")
+	}
+}
+
+func TestOrderRepository_ChangeStatusAndIncrementBalance_Once(t *testing.T) {
+	if conStr == "" {
+		t.Skip("connect string is not provided")
+	}
+
+	ctx := context.Background()
+
+	s, teardown := sqlstore.TestStore(t, conStr)
+	defer teardown(userTableName, ordersTableName)
+
+	u := model.TestUser(t, userLogin1)
+	require.NoError(t, s.User().Create(ctx, u))
+	require.NoError(t, s.Order().Register(ctx, u.ID, orderNum1))
+
+	m := &model.OrderInAccrual{
+		Number:  orderNum1,
+		Status:  model.StatusProcessed,
+		Accrual: 100,
+	}
+
+	// accrual system could return final status several times, e.g. to concurrent pollers
+	const attempts = 5
+	var (
+		wg       sync.WaitGroup
+		credited int32
+	)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := s.Order().ChangeStatusAndIncrementUserBalance(ctx, u.ID, m)
+			assert.NoError(t, err)
+			if ok {
+				atomic.AddInt32(&credited, 1)
 			}
-		})
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), credited)
+
+	ok, err := s.Order().ChangeStatusAndIncrementUserBalance(ctx, u.ID, m)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	bal, err := s.User().GetBalance(ctx, u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 100.0, bal.Current)
+
+	// final order is not moved back
+	err = s.Order().ChangeStatus(ctx, u.ID, &model.OrderInAccrual{Number: orderNum1, Status: model.StatusProcessing})
+	var te *model.TransitionError
+	require.ErrorAs(t, err, &te)
+	assert.Equal(t, model.StatusProcessed, te.From)
+	assert.Equal(t, model.StatusProcessing, te.To)
+	o, err := s.Order().GetByNumber(ctx, u.ID, orderNum1)
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusProcessed, o.Status)
+}
+
+func TestOrderRepository_GetActiveByNumber(t *testing.T) {
+	if conStr == "" {
+		t.Skip("connect string is not provided")
+	}
+
+	ctx := context.Background()
+
+	s, teardown := sqlstore.TestStore(t, conStr)
+	defer teardown(userTableName, ordersTableName)
+
+	u := model.TestUser(t, userLogin1)
+	require.NoError(t, s.User().Create(ctx, u))
+	require.NoError(t, s.Order().Register(ctx, u.ID, orderNum1))
+	require.NoError(t, s.Order().Register(ctx, u.ID, orderNum2))
+	require.NoError(t, s.Order().Cancel(ctx, u.ID, orderNum2))
+
+	o, err := s.Order().GetActiveByNumber(ctx, orderNum1)
+	require.NoError(t, err)
+	assert.Equal(t, orderNum1, o.Number)
+	assert.Equal(t, u.ID, o.User)
+	assert.Equal(t, model.StatusNew, o.Status)
+
+	_, err = s.Order().GetActiveByNumber(ctx, orderNum2)
+	assert.ErrorIs(t, err, store.ErrNoContent)
+	_, err = s.Order().GetActiveByNumber(ctx, orderNum3)
+	assert.ErrorIs(t, err, store.ErrNoContent)
+}
+
+func TestOrderRepository_ChangeStatus_IllegalTransition(t *testing.T) {
+	if conStr == "" {
+		t.Skip("connect string is not provided")
+	}
+
+	ctx := context.Background()
+
+	s, teardown := sqlstore.TestStore(t, conStr)
+	defer teardown(userTableName, ordersTableName)
+
+	u := model.TestUser(t, userLogin1)
+	require.NoError(t, s.User().Create(ctx, u))
+	require.NoError(t, s.Order().Register(ctx, u.ID, orderNum1))
+
+	steps := []struct {
+		status string
+		ok     bool
+	}{
+		{model.StatusProcessing, true},
+		{model.StatusProcessing, true},
+		{model.StatusNew, false},
+		{model.StatusInvalid, true},
+		{model.StatusProcessed, false},
+		{model.StatusCancelled, false},
+	}
+
+	for _, st := range steps {
+		err := s.Order().ChangeStatus(ctx, u.ID, &model.OrderInAccrual{Number: orderNum1, Status: st.status})
+		if st.ok {
+			require.NoErrorf(t, err, "status %s", st.status)
+			continue
+		}
+		var te *model.TransitionError
+		assert.ErrorAsf(t, err, &te, "status %s", st.status)
+	}
+
+	o, err := s.Order().GetByNumber(ctx, u.ID, orderNum1)
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusInvalid, o.Status)
+	// history contains only applied transitions
+	require.Len(t, o.History, 3)
+	assert.Equal(t, model.StatusProcessing, o.History[1].Status)
+	assert.Equal(t, model.StatusInvalid, o.History[2].Status)
+}
+
+func TestOrderRepository_DeadLetter(t *testing.T) {
+	if conStr == "" {
+		t.Skip("connect string is not provided")
+	}
+
+	ctx := context.Background()
+
+	s, teardown := sqlstore.TestStore(t, conStr)
+	defer teardown(userTableName, ordersTableName)
+
+	u := model.TestUser(t, userLogin1)
+	require.NoError(t, s.User().Create(ctx, u))
+	require.NoError(t, s.Order().Register(ctx, u.ID, orderNum1))
+	require.NoError(t, s.Order().Register(ctx, u.ID, orderNum2))
+
+	_, err := s.Order().GetDeadLettered(ctx)
+	assert.ErrorIs(t, err, store.ErrNoContent)
+
+	for _, num := range []model.OrderNumber{orderNum1, orderNum2} {
+		ok, err := s.Order().ClaimOrder(ctx, "test", num, time.Minute)
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+
+	dead, err := s.Order().RecordFailure(ctx, "test", orderNum1, "unexpected status", "", 2)
+	require.NoError(t, err)
+	assert.False(t, dead)
+	dead, err = s.Order().RecordFailure(ctx, "test", orderNum1, "json unmarshal", `{"order":`, 2)
+	require.NoError(t, err)
+	assert.True(t, dead)
+
+	// zero max failures disables dead-lettering
+	dead, err = s.Order().RecordFailure(ctx, "test", orderNum2, "unexpected status", "", 0)
+	require.NoError(t, err)
+	assert.False(t, dead)
+
+	_, err = s.Order().RecordFailure(ctx, "test", orderNum3, "unexpected status", "", 2)
+	assert.ErrorIs(t, err, store.ErrLeaseLost)
+
+	letters, err := s.Order().GetDeadLettered(ctx)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Equal(t, orderNum1, letters[0].Number)
+	assert.Equal(t, u.ID, letters[0].User)
+	assert.Equal(t, 2, letters[0].Failures)
+	assert.Equal(t, "json unmarshal", letters[0].LastError)
+	assert.Equal(t, `{"order":`, letters[0].LastPayload)
+
+	// dead-lettered order is not polled
+	orders, err := s.Order().ClaimUnprocessedOrders(ctx, "other", 10, time.Minute)
+	require.NoError(t, err)
+	for _, o := range orders {
+		assert.NotEqual(t, orderNum1, o.Number)
 	}
+	ok, err := s.Order().ClaimOrder(ctx, "instance", orderNum1, time.Minute)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.ErrorIs(t, s.Order().RetryDeadLettered(ctx, orderNum2), store.ErrNoContent)
+	require.NoError(t, s.Order().RetryDeadLettered(ctx, orderNum1))
+	ok, err = s.Order().ClaimOrder(ctx, "instance", orderNum1, time.Minute)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	_, err = s.Order().GetDeadLettered(ctx)
+	assert.ErrorIs(t, err, store.ErrNoContent)
 }
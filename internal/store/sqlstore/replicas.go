@@ -0,0 +1,291 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/vlad-marlo/gophermart/internal/config"
+	"github.com/vlad-marlo/gophermart/internal/store"
+	"github.com/vlad-marlo/gophermart/pkg/logger"
+)
+
+const (
+	// replicaCheckTimeout is max time of check of replica lag
+	replicaCheckTimeout = time.Second
+	// instanceLockKey is key of advisory lock which is held by every instance using database during its lifetime.
+	// Instance with replicas holds it exclusively, so it doesn't start while database is used by other instances and
+	// other instances don't start while it is running.
+	instanceLockKey int64 = 7_412_305_120
+)
+
+// ErrReplicasMultiInstance is returned when instance with replicas is started for database used by other instances or
+// when other instance is started while instance with replicas is running.
+var ErrReplicasMultiInstance = errors.New("database replicas are supported by single instance only")
+
+type (
+	// replica is read-only copy of database
+	replica struct {
+		pool *pgxpool.Pool
+		host string
+
+		// mu protects state of last check
+		mu        sync.Mutex
+		healthy   bool
+		checkedAt time.Time
+		// checking is true while lag of replica is checked
+		checking bool
+	}
+	// replicaSet routes reads of users to replicas which lag is not greater than maxLag. Reads of user are routed to
+	// primary during maxLag after write of user, so user sees own writes. Writes are known only to instance which made
+	// them, so instance with replica set holds instance lock of database exclusively.
+	replicaSet struct {
+		replicas []*replica
+		// next is index of replica which is tried first by next read
+		next          uint32
+		maxLag        time.Duration
+		checkInterval time.Duration
+		logger        logger.Logger
+		now           func() time.Time
+
+		// mu protects writes which contains time of last write of users
+		mu     sync.Mutex
+		writes map[int]time.Time
+	}
+)
+
+// newReplicaSet connects to replicas of c; pools of replicas have the same settings as pool of primary.
+func newReplicaSet(ctx context.Context, l logger.Logger, c *config.Config) (*replicaSet, error) {
+	rs := &replicaSet{
+		maxLag:        c.DBReplicaMaxLag,
+		checkInterval: c.DBReplicaCheckInterval,
+		logger:        l,
+		now:           time.Now,
+		writes:        make(map[int]time.Time),
+	}
+	for _, uri := range c.DBReplicaURIs {
+		cfg, err := poolConfig(uri, c)
+		if err != nil {
+			rs.close()
+			return nil, fmt.Errorf("replica pool config: %w", err)
+		}
+
+		// replica is connected lazily, so unavailable replica doesn't prevent start
+		cfg.LazyConnect = true
+		pool, err := pgxpool.ConnectConfig(ctx, cfg)
+		if err != nil {
+			rs.close()
+			return nil, pgError("connect replica: %v", err)
+		}
+		rs.replicas = append(rs.replicas, &replica{
+			pool: pool,
+			host: fmt.Sprintf("%s:%d", cfg.ConnConfig.Host, cfg.ConnConfig.Port),
+		})
+	}
+	return rs, nil
+}
+
+// reader returns replica which could serve reads of user or nil if reads must be served by primary.
+func (rs *replicaSet) reader(ctx context.Context, user int) *replica {
+	if rs.recentlyWrote(user) {
+		return nil
+	}
+
+	start := atomic.AddUint32(&rs.next, 1)
+	for i := range rs.replicas {
+		r := rs.replicas[(int(start)+i)%len(rs.replicas)]
+		if rs.available(ctx, r) {
+			return r
+		}
+	}
+	return nil
+}
+
+// available reports whether replica r answered last check and its lag was not greater than maxLag. Replica is
+// checked again if last check is older than checkInterval; reads which come during check get result of last check.
+func (rs *replicaSet) available(ctx context.Context, r *replica) bool {
+	r.mu.Lock()
+	if r.checking || !r.checkedAt.IsZero() && rs.now().Sub(r.checkedAt) < rs.checkInterval {
+		healthy := r.healthy
+		r.mu.Unlock()
+		return healthy
+	}
+	r.checking = true
+	r.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, replicaCheckTimeout)
+	defer cancel()
+
+	lag, err := replicaLag(ctx, r.pool)
+	healthy := err == nil && lag <= rs.maxLag
+	if err != nil {
+		rs.logger.Warnf("replica %s: check lag: %v", r.host, err)
+	} else if !healthy {
+		rs.logger.Warnf("replica %s: lag %s is greater than %s", r.host, lag, rs.maxLag)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.checking = false
+	r.checkedAt = rs.now()
+	r.healthy = healthy
+	return healthy
+}
+
+// fail marks replica r unavailable until next check.
+func (rs *replicaSet) fail(r *replica, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.healthy = false
+	r.checkedAt = rs.now()
+	rs.logger.Warnf("replica %s: read: %v", r.host, err)
+}
+
+// replicaStatus is replication state reported by replica.
+type replicaStatus struct {
+	// recovery is false for primary
+	recovery bool
+	// streaming is true if WAL receiver of replica is connected to primary
+	streaming bool
+	// caughtUp is true if replica replayed all received changes; it is nil if replica received nothing
+	caughtUp *bool
+	// replayAge is time in seconds since last transaction which was replayed by replica; it is nil if nothing was
+	// replayed
+	replayAge *float64
+}
+
+// lag returns lag of replica. Replica which replayed all changes received by streaming WAL receiver has no lag;
+// primary isn't in recovery, so it has no lag too. Replica without streaming WAL receiver doesn't receive new
+// changes, so its lag is unknown and error is returned.
+func (s replicaStatus) lag() (time.Duration, error) {
+	switch {
+	case !s.recovery:
+		return 0, nil
+	case !s.streaming:
+		return 0, errors.New("WAL receiver is not streaming")
+	case s.caughtUp != nil && *s.caughtUp:
+		return 0, nil
+	case s.replayAge == nil:
+		return 0, errors.New("lag is unknown")
+	}
+	return time.Duration(*s.replayAge * float64(time.Second)), nil
+}
+
+// replicaLag returns lag of replica; error is returned if lag is unknown.
+func replicaLag(ctx context.Context, pool *pgxpool.Pool) (time.Duration, error) {
+	q := debugQuery(`
+		SELECT
+			pg_is_in_recovery(),
+			EXISTS (SELECT 1 FROM pg_stat_wal_receiver WHERE status = 'streaming'),
+			pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn(),
+			EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())::FLOAT8;
+	`)
+
+	var s replicaStatus
+	if err := pool.QueryRow(ctx, q).Scan(&s.recovery, &s.streaming, &s.caughtUp, &s.replayAge); err != nil {
+		return 0, pgError("query: %w", err)
+	}
+	return s.lag()
+}
+
+// wrote records write of users.
+func (rs *replicaSet) wrote(users ...int) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	now := rs.now()
+	for _, user := range users {
+		rs.writes[user] = now
+	}
+	// forget writes which don't affect routing anymore, so map doesn't grow
+	if len(rs.writes) > 1024 {
+		for user, t := range rs.writes {
+			if now.Sub(t) > rs.maxLag {
+				delete(rs.writes, user)
+			}
+		}
+	}
+}
+
+// recentlyWrote reports whether user wrote during maxLag.
+func (rs *replicaSet) recentlyWrote(user int) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	t, ok := rs.writes[user]
+	return ok && rs.now().Sub(t) <= rs.maxLag
+}
+
+// lockInstance opens connection to database which holds instance lock until it is closed. Lock is held exclusively if
+// exclusive is true; otherwise it is shared with other instances without replicas.
+func lockInstance(ctx context.Context, cfg *pgxpool.Config, exclusive bool) (*pgx.Conn, error) {
+	conn, err := pgx.ConnectConfig(ctx, cfg.ConnConfig.Copy())
+	if err != nil {
+		return nil, pgError("connect: %w", err)
+	}
+
+	q := "SELECT pg_try_advisory_lock_shared($1);"
+	if exclusive {
+		q = "SELECT pg_try_advisory_lock($1);"
+	}
+	var ok bool
+	if err := conn.QueryRow(ctx, q, instanceLockKey).Scan(&ok); err != nil {
+		_ = conn.Close(context.Background())
+		return nil, pgError("advisory lock: %w", err)
+	}
+	if !ok {
+		_ = conn.Close(context.Background())
+		return nil, ErrReplicasMultiInstance
+	}
+	return conn, nil
+}
+
+// close closes pools of replicas.
+func (rs *replicaSet) close() {
+	for _, r := range rs.replicas {
+		r.pool.Close()
+	}
+}
+
+// read runs read-only f of user with replica if storage has available one; f is run with primary if there is no
+// available replica, if storage is bound to transaction or if f failed on replica.
+func (s *storage) read(ctx context.Context, user int, f func(db querier) error) error {
+	if s.tx || s.replicas == nil {
+		return f(s.db)
+	}
+
+	r := s.replicas.reader(ctx, user)
+	if r == nil {
+		return f(s.db)
+	}
+
+	err := f(withTimeout(r.pool, s.queryTimeout))
+	if err == nil || errors.Is(err, store.ErrNoContent) || ctx.Err() != nil {
+		return err
+	}
+	s.replicas.fail(r, err)
+	if err := f(s.db); err != nil {
+		return fmt.Errorf("read from primary: %w", err)
+	}
+	return nil
+}
+
+// wrote records write of users, so their reads are routed to primary; writes of transaction are recorded after
+// commit.
+func (s *storage) wrote(users ...int) {
+	if s.replicas == nil {
+		return
+	}
+	if s.tx {
+		*s.written = append(*s.written, users...)
+		return
+	}
+	s.replicas.wrote(users...)
+}
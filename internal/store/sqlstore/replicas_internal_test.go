@@ -0,0 +1,130 @@
+package sqlstore
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/vlad-marlo/gophermart/pkg/logger"
+)
+
+// testReplicaSet returns replica set which replicas were checked at now, so they are not checked by test.
+func testReplicaSet(t *testing.T, now *time.Time, healthy ...bool) *replicaSet {
+	t.Helper()
+
+	log := logrus.New()
+	log.Out = io.Discard
+
+	rs := &replicaSet{
+		maxLag:        5 * time.Second,
+		checkInterval: time.Minute,
+		logger:        logger.GetLoggerByEntry(logrus.NewEntry(log)),
+		now:           func() time.Time { return *now },
+		writes:        make(map[int]time.Time),
+	}
+	for _, h := range healthy {
+		rs.replicas = append(rs.replicas, &replica{healthy: h, checkedAt: *now})
+	}
+	return rs
+}
+
+func TestReplicaSet_Reader(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	t.Run("healthy replicas are used in turn", func(t *testing.T) {
+		rs := testReplicaSet(t, &now, true, true)
+		first := rs.reader(ctx, 1)
+		second := rs.reader(ctx, 1)
+		assert.NotNil(t, first)
+		assert.NotNil(t, second)
+		assert.NotSame(t, first, second)
+	})
+
+	t.Run("unhealthy replica is skipped", func(t *testing.T) {
+		rs := testReplicaSet(t, &now, false, true)
+		for i := 0; i < 3; i++ {
+			assert.Same(t, rs.replicas[1], rs.reader(ctx, 1))
+		}
+	})
+
+	t.Run("primary is used without healthy replicas", func(t *testing.T) {
+		rs := testReplicaSet(t, &now, false, false)
+		assert.Nil(t, rs.reader(ctx, 1))
+	})
+
+	t.Run("read doesn't wait for check of replica", func(t *testing.T) {
+		rs := testReplicaSet(t, &now, true)
+		// replica without pool would panic if it was checked again
+		rs.replicas[0].checkedAt = now.Add(-time.Hour)
+		rs.replicas[0].checking = true
+		assert.Same(t, rs.replicas[0], rs.reader(ctx, 1))
+	})
+
+	t.Run("failed replica is skipped until next check", func(t *testing.T) {
+		rs := testReplicaSet(t, &now, true)
+		r := rs.reader(ctx, 1)
+		assert.NotNil(t, r)
+		rs.fail(r, assert.AnError)
+		assert.Nil(t, rs.reader(ctx, 1))
+	})
+}
+
+func TestReplicaSet_ReadOwnWrites(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	rs := testReplicaSet(t, &now, true)
+
+	rs.wrote(1)
+	assert.Nil(t, rs.reader(ctx, 1), "user is read from primary right after own write")
+	assert.NotNil(t, rs.reader(ctx, 2), "write of user doesn't affect reads of other users")
+
+	now = now.Add(rs.maxLag + time.Millisecond)
+	assert.NotNil(t, rs.reader(ctx, 1), "user is read from replica after max lag")
+}
+
+func TestStorage_WroteInTx(t *testing.T) {
+	now := time.Now()
+	rs := testReplicaSet(t, &now)
+	s := &storage{tx: true, replicas: rs, written: new([]int)}
+
+	s.wrote(1, 2)
+	assert.False(t, rs.recentlyWrote(1), "write of transaction is recorded after commit")
+	assert.Equal(t, []int{1, 2}, *s.written)
+
+	// storage without replicas doesn't record writes
+	(&storage{}).wrote(1)
+}
+
+func TestReplicaStatus_Lag(t *testing.T) {
+	yes, no := true, false
+	age := 2.5
+
+	tests := []struct {
+		name    string
+		status  replicaStatus
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "primary", status: replicaStatus{}},
+		{name: "caught up", status: replicaStatus{recovery: true, streaming: true, caughtUp: &yes, replayAge: &age}},
+		{name: "replaying", status: replicaStatus{recovery: true, streaming: true, caughtUp: &no, replayAge: &age}, want: 2500 * time.Millisecond},
+		{name: "lost WAL receiver", status: replicaStatus{recovery: true, caughtUp: &yes, replayAge: &age}, wantErr: true},
+		{name: "nothing replayed", status: replicaStatus{recovery: true, streaming: true, caughtUp: &no}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lag, err := tt.status.lag()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, lag)
+		})
+	}
+}
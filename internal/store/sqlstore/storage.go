@@ -2,7 +2,14 @@ package sqlstore
 
 import (
 	"context"
-
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/log/logrusadapter"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/vlad-marlo/gophermart/internal/config"
@@ -10,24 +17,49 @@ import (
 	"github.com/vlad-marlo/gophermart/pkg/logger"
 )
 
-type storage struct {
-	db     *pgxpool.Pool
-	logger logger.Logger
-	cfg    *pgxpool.Config
-
-	// repositories
-	user     store.UserRepository
-	order    store.OrderRepository
-	withdraw store.WithdrawRepository
-}
+// maxTxAttempts is max count of runs of transaction which fails on serialization failure or deadlock
+const maxTxAttempts = 5
+
+type (
+	// querier is implemented by pool and by transaction, so the same repositories run queries in transaction if
+	// storage is bound to it; Begin of transaction starts savepoint
+	querier interface {
+		Begin(ctx context.Context) (pgx.Tx, error)
+		Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+		Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+		QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	}
+	storage struct {
+		db     querier
+		pool   *pgxpool.Pool
+		logger logger.Logger
+		cfg    *pgxpool.Config
+		// tx is true if storage is bound to transaction
+		tx bool
+		// queryTimeout is deadline of every query; zero disables deadline
+		queryTimeout time.Duration
+		// replicas could be nil
+		replicas *replicaSet
+		// instanceLock is connection which holds instance lock of database; it is nil for storage bound to transaction
+		// and for test storage
+		instanceLock *pgx.Conn
+		// written contains users which wrote in transaction; they are recorded in replicas after commit
+		written *[]int
+
+		// repositories
+		user     store.UserRepository
+		order    store.OrderRepository
+		withdraw store.WithdrawRepository
+		transfer store.TransferRepository
+	}
+)
 
 // New ...
 func New(ctx context.Context, l logger.Logger, c *config.Config) (store.Storage, error) {
-	cfg, err := pgxpool.ParseConfig(c.DBURI)
+	cfg, err := poolConfig(c.DBURI, c)
 	if err != nil {
-		return nil, pgError("parse config: %v", err)
+		return nil, fmt.Errorf("pool config: %w", err)
 	}
-	cfg.ConnConfig.Logger = logrusadapter.NewLogger(logger.GetEntry())
 
 	db, err := pgxpool.ConnectConfig(ctx, cfg)
 	if err != nil {
@@ -38,28 +70,79 @@ func New(ctx context.Context, l logger.Logger, c *config.Config) (store.Storage,
 		return nil, pgError("ping db: %v", err)
 	}
 
-	s := &storage{
-		db:     db,
-		logger: l,
-		cfg:    cfg,
-	}
-	s.user = &userRepository{s}
-	s.order = &orderRepository{s}
-	s.withdraw = &withdrawRepository{s}
+	s := newStorage(db, withTimeout(db, c.DBStatementTimeout), l)
+	s.cfg = cfg
+	s.queryTimeout = c.DBStatementTimeout
 
-	if err := s.user.Migrate(context.Background()); err != nil {
-		return nil, pgError("user: migrate: %v", err)
+	s.instanceLock, err = lockInstance(ctx, cfg, len(c.DBReplicaURIs) > 0)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("lock instance: %w", err)
 	}
+	if len(c.DBReplicaURIs) > 0 {
+		s.replicas, err = newReplicaSet(ctx, l, c)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("new replica set: %w", err)
+		}
+	}
+	publishStats(s)
+
+	if c.DBMigrate {
+		m, err := newMigrator(db, l)
+		if err != nil {
+			return nil, fmt.Errorf("new migrator: %w", err)
+		}
+		if _, err := m.Up(ctx); err != nil {
+			return nil, fmt.Errorf("migrate: %w", err)
+		}
+	}
+
+	return s, nil
+}
 
-	if err := s.order.Migrate(context.Background()); err != nil {
-		return nil, pgError("orders: migrate: %v", err)
+// poolConfig returns config of pool connected to uri with pool settings and logger of c.
+func poolConfig(uri string, c *config.Config) (*pgxpool.Config, error) {
+	cfg, err := pgxpool.ParseConfig(uri)
+	if err != nil {
+		return nil, pgError("parse config: %v", err)
 	}
+	cfg.ConnConfig.Logger = logrusadapter.NewLogger(logger.GetEntry())
 
-	if err := s.withdraw.Migrate(context.Background()); err != nil {
-		return nil, pgError("withdraws: migrate: %v", err)
+	if c.DBMaxConns > 0 {
+		cfg.MaxConns = int32(c.DBMaxConns)
 	}
+	if c.DBMinConns > 0 {
+		cfg.MinConns = int32(c.DBMinConns)
+	}
+	if c.DBMaxConnLifetime > 0 {
+		cfg.MaxConnLifetime = c.DBMaxConnLifetime
+	}
+	if c.DBMaxConnIdleTime > 0 {
+		cfg.MaxConnIdleTime = c.DBMaxConnIdleTime
+	}
+	if c.DBHealthCheckPeriod > 0 {
+		cfg.HealthCheckPeriod = c.DBHealthCheckPeriod
+	}
+	// server cancels query which outlived deadline of context, for example when client lost connection
+	if c.DBStatementTimeout > 0 {
+		cfg.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(c.DBStatementTimeout.Milliseconds(), 10)
+	}
+	return cfg, nil
+}
 
-	return s, nil
+// newStorage returns storage which runs queries with db.
+func newStorage(pool *pgxpool.Pool, db querier, l logger.Logger) *storage {
+	s := &storage{
+		db:     db,
+		pool:   pool,
+		logger: l,
+	}
+	s.user = &userRepository{s}
+	s.order = &orderRepository{s}
+	s.withdraw = &withdrawRepository{s}
+	s.transfer = &transferRepository{s}
+	return s
 }
 
 // User ...
@@ -77,7 +160,95 @@ func (s *storage) Withdraws() store.WithdrawRepository {
 	return s.withdraw
 }
 
-// Close ...
+// Transfers ...
+func (s *storage) Transfers() store.TransferRepository {
+	return s.transfer
+}
+
+// WithTx runs outer transaction with serializable isolation level, so repository calls composed by f don't need explicit
+// locks; it is retried on serialization failure or deadlock. Nested transaction is savepoint, which is retried only as
+// part of outer transaction.
+func (s *storage) WithTx(ctx context.Context, f func(tx store.Storage) error) error {
+	if s.tx {
+		return s.runTx(ctx, f)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := s.runTx(ctx, f)
+		if !retryable(err) || attempt == maxTxAttempts || ctx.Err() != nil {
+			return err
+		}
+		s.logger.Debugf("with tx: attempt %d: %v", attempt, err)
+	}
+}
+
+// runTx runs f in transaction once.
+func (s *storage) runTx(ctx context.Context, f func(tx store.Storage) error) error {
+	var (
+		tx  pgx.Tx
+		err error
+	)
+	if s.tx {
+		tx, err = s.db.Begin(ctx)
+	} else {
+		tx, err = s.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable})
+		if err == nil {
+			tx = txWithTimeout(tx, s.queryTimeout)
+		}
+	}
+	if err != nil {
+		return pgError("start transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.logger.Errorf("with tx: unable to rollback: %v", err)
+		}
+	}()
+
+	txStorage := newStorage(s.pool, tx, s.logger)
+	txStorage.tx = true
+	txStorage.queryTimeout = s.queryTimeout
+	txStorage.replicas = s.replicas
+	txStorage.written = s.written
+	if !s.tx {
+		txStorage.written = new([]int)
+	}
+	if err := f(txStorage); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return pgError("commit: %w", err)
+	}
+	if !s.tx {
+		s.wrote(*txStorage.written...)
+	}
+	return nil
+}
+
+// retryable checks transaction failed with err could succeed if it is run again.
+func retryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == pgerrcode.SerializationFailure || pgErr.Code == pgerrcode.DeadlockDetected
+}
+
+// Close closes pools; storage bound to transaction doesn't own pools, so they are not closed.
 func (s *storage) Close() {
-	s.db.Close()
+	if s.tx {
+		return
+	}
+	s.pool.Close()
+	if s.replicas != nil {
+		s.replicas.close()
+	}
+	// lock is released when its session is closed
+	if s.instanceLock != nil {
+		if err := s.instanceLock.Close(context.Background()); err != nil {
+			s.logger.Errorf("close instance lock: %v", err)
+		}
+	}
 }
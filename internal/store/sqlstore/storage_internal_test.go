@@ -0,0 +1,57 @@
+package sqlstore
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "serialization failure",
+			err:  pgError("commit: %w", &pgconn.PgError{Code: pgerrcode.SerializationFailure}),
+			want: true,
+		},
+		{
+			name: "deadlock wrapped by repository",
+			err:  fmt.Errorf("update order: %w", pgError("get status: %w", &pgconn.PgError{Code: pgerrcode.DeadlockDetected})),
+			want: true,
+		},
+		{
+			name: "unique violation",
+			err:  pgError("exec: %w", &pgconn.PgError{Code: pgerrcode.UniqueViolation}),
+			want: false,
+		},
+		{
+			name: "not postgres error",
+			err:  errors.New("test error"),
+			want: false,
+		},
+		{
+			name: "nil",
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, retryable(tt.err))
+		})
+	}
+}
+
+func TestPgError(t *testing.T) {
+	err := pgError("exec: %w", &pgconn.PgError{Message: "duplicate key", Code: pgerrcode.UniqueViolation})
+	assert.Equal(t, "exec: SQL error: duplicate key, Detail: , Where: , State: 23505, Code: 23505", err.Error())
+
+	var pgErr *pgconn.PgError
+	assert.ErrorAs(t, err, &pgErr)
+}
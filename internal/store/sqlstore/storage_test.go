@@ -2,6 +2,8 @@ package sqlstore_test
 
 import (
 	"os"
+
+	"github.com/vlad-marlo/gophermart/internal/model"
 )
 
 var (
@@ -11,8 +13,9 @@ var (
 	userTableName        = "users"
 	ordersTableName      = "orders"
 	withdrawalsTableName = "withdrawals"
-	orderNum1            = 79927398713
-	orderNum2            = 4929972884676289
-	orderNum3            = 4532733309529845
-	orderNum4            = 4539088167512356
+	transfersTableName   = "transfers"
+	orderNum1            = model.OrderNumber("79927398713")
+	orderNum2            = model.OrderNumber("4929972884676289")
+	orderNum3            = model.OrderNumber("4532733309529845")
+	orderNum4            = model.OrderNumber("4539088167512356")
 )
@@ -28,23 +28,13 @@ func TestStore(t *testing.T, con string) (store.Storage, func(...string)) {
 		t.Fatalf("test store: db ping: %v", err)
 	}
 
-	s := &storage{
-		db:     db,
-		logger: l,
-	}
-	s.user = &userRepository{s}
-	s.order = &orderRepository{s}
-	s.withdraw = &withdrawRepository{s}
-
-	if err := s.user.Migrate(context.Background()); err != nil {
-		t.Fatalf("migrate: %v", err)
-	}
+	s := newStorage(db, db, l)
 
-	if err := s.order.Migrate(context.Background()); err != nil {
-		t.Fatalf("migrate: %v", err)
+	m, err := newMigrator(db, l)
+	if err != nil {
+		t.Fatalf("new migrator: %v", err)
 	}
-
-	if err := s.withdraw.Migrate(context.Background()); err != nil {
+	if _, err := m.Up(context.Background()); err != nil {
 		t.Fatalf("migrate: %v", err)
 	}
 
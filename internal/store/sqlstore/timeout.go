@@ -0,0 +1,152 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+type (
+	// timeoutQuerier runs every query with deadline, so slow query doesn't hold connection indefinitely; query is
+	// cancelled by postgres when deadline is exceeded
+	timeoutQuerier struct {
+		querier
+		timeout time.Duration
+	}
+	// timeoutTx is transaction which runs every query with deadline
+	timeoutTx struct {
+		pgx.Tx
+		timeout time.Duration
+	}
+	// timeoutRows releases deadline of query when rows are read or closed
+	timeoutRows struct {
+		pgx.Rows
+		cancel context.CancelFunc
+	}
+	// timeoutRow releases deadline of query when row is scanned
+	timeoutRow struct {
+		pgx.Row
+		cancel context.CancelFunc
+	}
+)
+
+// withTimeout returns querier which runs queries of db with timeout; db is returned as is if timeout is not positive.
+func withTimeout(db querier, timeout time.Duration) querier {
+	if timeout <= 0 {
+		return db
+	}
+	return &timeoutQuerier{querier: db, timeout: timeout}
+}
+
+// txWithTimeout returns transaction which runs queries of tx with timeout; tx is returned as is if timeout is not
+// positive.
+func txWithTimeout(tx pgx.Tx, timeout time.Duration) pgx.Tx {
+	if timeout <= 0 {
+		return tx
+	}
+	return &timeoutTx{Tx: tx, timeout: timeout}
+}
+
+// Begin ...
+func (q *timeoutQuerier) Begin(ctx context.Context) (pgx.Tx, error) {
+	tx, err := q.querier.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return txWithTimeout(tx, q.timeout), nil
+}
+
+// Exec ...
+func (q *timeoutQuerier) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return execWithTimeout(ctx, q.querier, q.timeout, sql, args...)
+}
+
+// Query ...
+func (q *timeoutQuerier) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return queryWithTimeout(ctx, q.querier, q.timeout, sql, args...)
+}
+
+// QueryRow ...
+func (q *timeoutQuerier) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return queryRowWithTimeout(ctx, q.querier, q.timeout, sql, args...)
+}
+
+// Begin starts savepoint.
+func (tx *timeoutTx) Begin(ctx context.Context) (pgx.Tx, error) {
+	nested, err := tx.Tx.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return txWithTimeout(nested, tx.timeout), nil
+}
+
+// Exec ...
+func (tx *timeoutTx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return execWithTimeout(ctx, tx.Tx, tx.timeout, sql, args...)
+}
+
+// Query ...
+func (tx *timeoutTx) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return queryWithTimeout(ctx, tx.Tx, tx.timeout, sql, args...)
+}
+
+// QueryRow ...
+func (tx *timeoutTx) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return queryRowWithTimeout(ctx, tx.Tx, tx.timeout, sql, args...)
+}
+
+// Next ...
+func (r *timeoutRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.cancel()
+	return false
+}
+
+// Close ...
+func (r *timeoutRows) Close() {
+	r.Rows.Close()
+	r.cancel()
+}
+
+// Scan ...
+func (r *timeoutRow) Scan(dest ...interface{}) error {
+	defer r.cancel()
+	return observeTimeout(r.Row.Scan(dest...))
+}
+
+func execWithTimeout(ctx context.Context, db querier, timeout time.Duration, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	tag, err := db.Exec(ctx, sql, args...)
+	return tag, observeTimeout(err)
+}
+
+func queryWithTimeout(ctx context.Context, db querier, timeout time.Duration, sql string, args ...interface{}) (pgx.Rows, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+
+	rows, err := db.Query(ctx, sql, args...)
+	if err != nil {
+		cancel()
+		return nil, observeTimeout(err)
+	}
+	return &timeoutRows{Rows: rows, cancel: cancel}, nil
+}
+
+func queryRowWithTimeout(ctx context.Context, db querier, timeout time.Duration, sql string, args ...interface{}) pgx.Row {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return &timeoutRow{Row: db.QueryRow(ctx, sql, args...), cancel: cancel}
+}
+
+// observeTimeout counts queries which exceeded deadline and returns err as is.
+func observeTimeout(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) || pgconn.Timeout(err) {
+		metrics.Add(metricQueryTimeouts, 1)
+	}
+	return err
+}
@@ -0,0 +1,250 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v4"
+	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/internal/store"
+)
+
+type transferRepository struct {
+	s *storage
+}
+
+func (r *transferRepository) Transfer(ctx context.Context, user int, t *model.Transfer, dailyLimit float64) (int, error) {
+	if t.Sum <= 0 {
+		return 0, store.ErrIncorrectData
+	}
+
+	qGetRecipient := debugQuery(`
+	SELECT
+		id
+	FROM
+		users
+	WHERE
+		login = $1;
+	`)
+	// both rows are locked in the same order to avoid deadlocks between opposite transfers
+	qLockBalances := debugQuery(`
+	SELECT
+		id, balance::FLOAT8
+	FROM
+		users
+	WHERE
+		id IN ($1, $2)
+	ORDER BY id
+	FOR UPDATE;
+	`)
+	qSentToday := debugQuery(`
+	SELECT
+		COALESCE(SUM(amount), 0.0)::FLOAT8
+	FROM
+		transfers
+	WHERE
+		sender_id = $1 AND created_at >= date_trunc('day', CURRENT_TIMESTAMP);
+	`)
+	qChangeBalance := debugQuery(`
+	UPDATE
+		users
+	SET
+		balance = balance + $1::DOUBLE PRECISION
+	WHERE
+		id = $2;
+	`)
+	qInsertTransfer := debugQuery(`
+	INSERT INTO
+		transfers(
+			sender_id,
+			recipient_id,
+			amount,
+			idempotency_key
+		)
+	VALUES ($1, $2, $3, NULLIF($4, ''))
+	RETURNING created_at;
+	`)
+
+	tx, err := r.s.db.Begin(ctx)
+	if err != nil {
+		return 0, pgError("tx begin: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			r.s.logger.WithFields(map[string]interface{}{
+				"request_id": middleware.GetReqID(ctx),
+			}).Error(pgError("transfer: unable to rollback: %w", err))
+		}
+	}()
+
+	if t.IdempotencyKey != "" {
+		recipient, ok, err := r.replay(ctx, tx, user, t)
+		if err != nil || ok {
+			return recipient, err
+		}
+	}
+
+	var recipient int
+	if err := tx.QueryRow(ctx, qGetRecipient, t.Login).Scan(&recipient); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, store.ErrRecipientNotFound
+		}
+		return 0, pgError("get recipient: %w", err)
+	}
+	if recipient == user {
+		return 0, store.ErrIncorrectData
+	}
+
+	rows, err := tx.Query(ctx, qLockBalances, user, recipient)
+	if err != nil {
+		return 0, pgError("lock balances: %w", err)
+	}
+	var bal float64
+	for rows.Next() {
+		var (
+			id int
+			b  float64
+		)
+		if err := rows.Scan(&id, &b); err != nil {
+			rows.Close()
+			return 0, pgError("rows scan: %w", err)
+		}
+		if id == user {
+			bal = b
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, pgError("rows err: %w", err)
+	}
+
+	if dailyLimit > 0 {
+		var sent float64
+		if err := tx.QueryRow(ctx, qSentToday, user).Scan(&sent); err != nil {
+			return 0, pgError("get sent today: %w", err)
+		}
+		if sent+t.Sum > dailyLimit {
+			return 0, store.ErrDailyLimitExceeded
+		}
+	}
+
+	if bal < t.Sum {
+		return 0, store.ErrPaymentRequired
+	}
+
+	if _, err := tx.Exec(ctx, qChangeBalance, -t.Sum, user); err != nil {
+		return 0, pgError("debit sender: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, qChangeBalance, t.Sum, recipient); err != nil {
+		return 0, pgError("credit recipient: %w", err)
+	}
+
+	if err := tx.QueryRow(ctx, qInsertTransfer, user, recipient, t.Sum, t.IdempotencyKey).Scan(&t.ProcessedAt); err != nil {
+		// transfer with the same idempotency key was committed concurrently; it is compared with t after rollback,
+		// because failed transaction can't run queries
+		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == pgerrcode.UniqueViolation {
+			if err := tx.Rollback(ctx); err != nil {
+				return 0, pgError("rollback: %w", err)
+			}
+			recipient, _, err := r.replay(ctx, r.s.db, user, t)
+			return recipient, err
+		}
+		return 0, pgError("insert transfer: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, pgError("commit: %w", err)
+	}
+	r.s.wrote(user, recipient)
+	return recipient, nil
+}
+
+// replay returns recipient of transfer committed by user with idempotency key of t and reports whether it exists.
+// ErrIdempotencyKeyReused is returned if recipient or sum of committed transfer differ from t.
+func (r *transferRepository) replay(ctx context.Context, db querier, user int, t *model.Transfer) (int, bool, error) {
+	q := debugQuery(`
+	SELECT
+		t.recipient_id, u.login, t.amount::FLOAT8, t.created_at
+	FROM
+		transfers t
+	JOIN
+		users u ON u.id = t.recipient_id
+	WHERE
+		t.sender_id = $1 AND t.idempotency_key = $2;
+	`)
+
+	var (
+		recipient int
+		login     string
+		sum       float64
+		createdAt time.Time
+	)
+	if err := db.QueryRow(ctx, q, user, t.IdempotencyKey).Scan(&recipient, &login, &sum, &createdAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, false, nil
+		}
+		return 0, false, pgError("get transfer by idempotency key: %w", err)
+	}
+	if login != t.Login || sum != t.Sum {
+		return 0, true, store.ErrIdempotencyKeyReused
+	}
+	t.ProcessedAt = createdAt
+	return recipient, true, nil
+}
+
+func (r *transferRepository) GetAllByUser(ctx context.Context, user int) (res []*model.Transfer, err error) {
+	err = r.s.read(ctx, user, func(db querier) (err error) {
+		res, err = r.getAllByUser(ctx, db, user)
+		return err
+	})
+	return res, err
+}
+
+func (r *transferRepository) getAllByUser(ctx context.Context, db querier, user int) (res []*model.Transfer, err error) {
+	q := debugQuery(`
+	SELECT
+		CASE WHEN t.sender_id = $1 THEN 'outgoing' ELSE 'incoming' END,
+		u.login, t.amount::FLOAT8, t.created_at
+	FROM
+		transfers t
+	JOIN
+		users u ON u.id = CASE WHEN t.sender_id = $1 THEN t.recipient_id ELSE t.sender_id END
+	WHERE
+		t.sender_id = $1 OR t.recipient_id = $1
+	ORDER BY t.created_at;
+	`)
+
+	rows, err := db.Query(ctx, q, user)
+	if err != nil {
+		return nil, pgError("query: %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		t := new(model.Transfer)
+
+		if err := rows.Scan(&t.Direction, &t.Login, &t.Sum, &t.ProcessedAt); err != nil {
+			return nil, pgError("rows scan: %w", err)
+		}
+
+		t.ToRepresentation()
+		res = append(res, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, pgError("rows err: %w", err)
+	}
+
+	if len(res) == 0 {
+		return nil, store.ErrNoContent
+	}
+
+	return res, nil
+}
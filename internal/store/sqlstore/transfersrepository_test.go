@@ -0,0 +1,191 @@
+package sqlstore_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/internal/store"
+	"github.com/vlad-marlo/gophermart/internal/store/sqlstore"
+	"github.com/vlad-marlo/gophermart/pkg/logger"
+)
+
+func TestTransferRepository_Transfer(t *testing.T) {
+	if conStr == "" {
+		t.Skip("connect string was not provided")
+	}
+
+	ctx := context.Background()
+
+	s, teardown := sqlstore.TestStore(t, conStr)
+	defer func() {
+		teardown(userTableName, transfersTableName)
+		logger.DeleteLogFolderAndFile(t)
+	}()
+
+	u1 := model.TestUser(t, userLogin1)
+	u2 := model.TestUser(t, userLogin2)
+	for _, u := range []*model.User{u1, u2} {
+		err := s.User().Create(ctx, u)
+		require.NoErrorf(t, err, "create user: %v", err)
+	}
+
+	err := s.User().IncrementBalance(ctx, u1.ID, 100)
+	require.NoErrorf(t, err, "increment balance: %v", err)
+
+	tests := []struct {
+		name       string
+		login      string
+		sum        float64
+		key        string
+		dailyLimit float64
+		wantErr    error
+		// balances after transfer
+		wantFirst  float64
+		wantSecond float64
+	}{
+		{
+			name:       "positive case #1",
+			login:      userLogin2,
+			sum:        10,
+			key:        "first",
+			wantFirst:  90,
+			wantSecond: 10,
+		},
+		{
+			name:       "positive case #2 - repeated idempotency key",
+			login:      userLogin2,
+			sum:        10,
+			key:        "first",
+			wantFirst:  90,
+			wantSecond: 10,
+		},
+		{
+			name:       "negative case #0 - repeated idempotency key with other sum",
+			login:      userLogin2,
+			sum:        15,
+			key:        "first",
+			wantErr:    store.ErrIdempotencyKeyReused,
+			wantFirst:  90,
+			wantSecond: 10,
+		},
+		{
+			name:       "positive case #3 - without idempotency key",
+			login:      userLogin2,
+			sum:        20,
+			wantFirst:  70,
+			wantSecond: 30,
+		},
+		{
+			name:       "negative case #1 - payment required",
+			login:      userLogin2,
+			sum:        1000,
+			wantErr:    store.ErrPaymentRequired,
+			wantFirst:  70,
+			wantSecond: 30,
+		},
+		{
+			name:       "negative case #2 - unknown recipient",
+			login:      "unknown",
+			sum:        10,
+			wantErr:    store.ErrRecipientNotFound,
+			wantFirst:  70,
+			wantSecond: 30,
+		},
+		{
+			name:       "negative case #3 - transfer to self",
+			login:      userLogin1,
+			sum:        10,
+			wantErr:    store.ErrIncorrectData,
+			wantFirst:  70,
+			wantSecond: 30,
+		},
+		{
+			name:       "negative case #4 - daily limit",
+			login:      userLogin2,
+			sum:        10,
+			dailyLimit: 35,
+			wantErr:    store.ErrDailyLimitExceeded,
+			wantFirst:  70,
+			wantSecond: 30,
+		},
+		{
+			name:       "negative case #5 - not positive sum",
+			login:      userLogin2,
+			sum:        -10,
+			wantErr:    store.ErrIncorrectData,
+			wantFirst:  70,
+			wantSecond: 30,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recipient, err := s.Transfers().Transfer(ctx, u1.ID, &model.Transfer{
+				Login:          tt.login,
+				Sum:            tt.sum,
+				IdempotencyKey: tt.key,
+			}, tt.dailyLimit)
+			if tt.wantErr == nil {
+				require.NoError(t, err)
+				assert.Equal(t, u2.ID, recipient)
+			} else {
+				require.ErrorIs(t, err, tt.wantErr)
+			}
+
+			first, err := s.User().GetBalance(ctx, u1.ID)
+			require.NoErrorf(t, err, "get user balance: %v", err)
+			second, err := s.User().GetBalance(ctx, u2.ID)
+			require.NoErrorf(t, err, "get user balance: %v", err)
+
+			assert.Equal(t, tt.wantFirst, first.Current)
+			assert.Equal(t, tt.wantSecond, second.Current)
+		})
+	}
+}
+
+func TestTransferRepository_GetAllByUser(t *testing.T) {
+	if conStr == "" {
+		t.Skip("connect string was not provided")
+	}
+
+	ctx := context.Background()
+
+	s, teardown := sqlstore.TestStore(t, conStr)
+	defer func() {
+		teardown(userTableName, transfersTableName)
+		logger.DeleteLogFolderAndFile(t)
+	}()
+
+	u1 := model.TestUser(t, userLogin1)
+	u2 := model.TestUser(t, userLogin2)
+	for _, u := range []*model.User{u1, u2} {
+		err := s.User().Create(ctx, u)
+		require.NoErrorf(t, err, "create user: %v", err)
+	}
+
+	_, err := s.Transfers().GetAllByUser(ctx, u1.ID)
+	require.ErrorIs(t, err, store.ErrNoContent)
+
+	err = s.User().IncrementBalance(ctx, u1.ID, 100)
+	require.NoErrorf(t, err, "increment balance: %v", err)
+
+	_, err = s.Transfers().Transfer(ctx, u1.ID, &model.Transfer{Login: userLogin2, Sum: 42}, 0)
+	require.NoErrorf(t, err, "transfer: %v", err)
+
+	sent, err := s.Transfers().GetAllByUser(ctx, u1.ID)
+	require.NoError(t, err)
+	require.Len(t, sent, 1)
+	assert.Equal(t, model.TransferOutgoing, sent[0].Direction)
+	assert.Equal(t, userLogin2, sent[0].Login)
+	assert.Equal(t, 42.0, sent[0].Sum)
+
+	received, err := s.Transfers().GetAllByUser(ctx, u2.ID)
+	require.NoError(t, err)
+	require.Len(t, received, 1)
+	assert.Equal(t, model.TransferIncoming, received[0].Direction)
+	assert.Equal(t, userLogin1, received[0].Login)
+	assert.Equal(t, 42.0, received[0].Sum)
+}
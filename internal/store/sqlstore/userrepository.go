@@ -8,6 +8,7 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v4"
 	"github.com/sirupsen/logrus"
 	"github.com/vlad-marlo/gophermart/internal/model"
 	"github.com/vlad-marlo/gophermart/internal/store"
@@ -17,22 +18,6 @@ type userRepository struct {
 	s *storage
 }
 
-// Migrate ...
-func (r *userRepository) Migrate(ctx context.Context) error {
-	q := debugQuery(`
-	CREATE TABLE IF NOT EXISTS users(
-		id BIGSERIAL UNIQUE PRIMARY KEY NOT NULL,
-		login VARCHAR UNIQUE NOT NULL,
-		password VARCHAR NOT NULL,
-		balance DOUBLE PRECISION DEFAULT 0::DOUBLE PRECISION
-	);
-	`)
-	if _, err := r.s.db.Exec(ctx, q); err != nil {
-		return pgError("exec: %w", err)
-	}
-	return nil
-}
-
 // Create ...
 func (r *userRepository) Create(ctx context.Context, u *model.User) error {
 	q := debugQuery(`
@@ -47,7 +32,20 @@ func (r *userRepository) Create(ctx context.Context, u *model.User) error {
 		return fmt.Errorf("before create: %w", err)
 	}
 
-	if err := r.s.db.QueryRow(
+	// user is inserted in its own transaction, which is savepoint if storage is bound to transaction, so violation of
+	// unique constraint doesn't abort outer transaction
+	tx, err := r.s.db.Begin(ctx)
+	if err != nil {
+		return pgError("start transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			r.s.logger.Errorf("create user: unable to rollback: %v", err)
+		}
+	}()
+
+	if err := tx.QueryRow(
 		ctx,
 		q,
 		u.Login,
@@ -59,6 +57,10 @@ func (r *userRepository) Create(ctx context.Context, u *model.User) error {
 		return pgError("scan: %w", err)
 	}
 
+	if err := tx.Commit(ctx); err != nil {
+		return pgError("commit: %w", err)
+	}
+	r.s.wrote(u.ID)
 	return nil
 }
 
@@ -131,6 +133,15 @@ func (r *userRepository) ExistsWithID(ctx context.Context, id int) bool {
 
 // GetBalance ...
 func (r *userRepository) GetBalance(ctx context.Context, id int) (balance *model.UserBalance, err error) {
+	err = r.s.read(ctx, id, func(db querier) (err error) {
+		balance, err = r.getBalance(ctx, db, id)
+		return err
+	})
+	return balance, err
+}
+
+// getBalance ...
+func (r *userRepository) getBalance(ctx context.Context, db querier, id int) (balance *model.UserBalance, err error) {
 	// оно вроде работает
 	q := debugQuery(`
 	SELECT
@@ -159,7 +170,7 @@ func (r *userRepository) GetBalance(ctx context.Context, id int) (balance *model
 	`)
 	balance = new(model.UserBalance)
 
-	rows, err := r.s.db.Query(ctx, q, id)
+	rows, err := db.Query(ctx, q, id)
 	if err != nil {
 		return nil, pgError("exec query: %w", err)
 	}
@@ -198,5 +209,6 @@ func (r *userRepository) IncrementBalance(ctx context.Context, id int, add float
 	if _, err := r.s.db.Exec(ctx, q, add, id); err != nil {
 		return pgError("db exec: %w", err)
 	}
+	r.s.wrote(id)
 	return nil
 }
@@ -7,33 +7,13 @@ import (
 	"github.com/jackc/pgx/v4"
 	"github.com/vlad-marlo/gophermart/internal/model"
 	"github.com/vlad-marlo/gophermart/internal/store"
-	"github.com/vlad-marlo/gophermart/pkg/luhn"
 )
 
 type withdrawRepository struct {
 	s *storage
 }
 
-func (r *withdrawRepository) Migrate(ctx context.Context) error {
-	q := debugQuery(`CREATE TABLE IF NOT EXISTS withdrawals(
-			id BIGSERIAL UNIQUE PRIMARY KEY,
-			processed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			user_id BIGINT,
-			order_id BIGINT,
-			order_sum DOUBLE PRECISION DEFAULT 0::DOUBLE PRECISION,
-			FOREIGN KEY (user_id) REFERENCES users(id)
-		);`)
-
-	if _, err := r.s.db.Exec(ctx, q); err != nil {
-		return pgError("query: %w", err)
-	}
-	return nil
-}
-
 func (r *withdrawRepository) Withdraw(ctx context.Context, user int, w *model.Withdraw) error {
-	if !luhn.Valid(w.Order) {
-		return store.ErrIncorrectData
-	}
 	var bal float64
 	qGetBal := debugQuery(`
 	SELECT
@@ -94,10 +74,19 @@ func (r *withdrawRepository) Withdraw(ctx context.Context, user int, w *model.Wi
 	if err := tx.Commit(ctx); err != nil {
 		return pgError("update drivers: %w", err)
 	}
+	r.s.wrote(user)
 	return nil
 }
 
 func (r *withdrawRepository) GetAllByUser(ctx context.Context, user int) (res []*model.Withdraw, err error) {
+	err = r.s.read(ctx, user, func(db querier) (err error) {
+		res, err = r.getAllByUser(ctx, db, user)
+		return err
+	})
+	return res, err
+}
+
+func (r *withdrawRepository) getAllByUser(ctx context.Context, db querier, user int) (res []*model.Withdraw, err error) {
 	q := debugQuery(`
 	SELECT 
 		order_id, order_sum::FLOAT8, processed_at
@@ -107,7 +96,7 @@ func (r *withdrawRepository) GetAllByUser(ctx context.Context, user int) (res []
 		user_id = $1
 	ORDER BY processed_at;
 	`)
-	rows, err := r.s.db.Query(ctx, q, user)
+	rows, err := db.Query(ctx, q, user)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, store.ErrNoContent
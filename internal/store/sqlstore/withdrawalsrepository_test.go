@@ -10,7 +10,6 @@ import (
 	"github.com/vlad-marlo/gophermart/internal/store"
 	"github.com/vlad-marlo/gophermart/internal/store/sqlstore"
 	"github.com/vlad-marlo/gophermart/pkg/logger"
-	"github.com/vlad-marlo/gophermart/pkg/luhn"
 	"testing"
 )
 
@@ -132,14 +131,14 @@ func TestWithdrawalsRepository_GetAllByUser(t *testing.T) {
 		require.NoErrorf(t, err, "create user: %s", err)
 	}
 
-	orderNum := 12345678903
+	orderNum := model.OrderNumber("12345678903")
 	err := s.Order().Register(ctx, u1.ID, orderNum)
 	require.NoErrorf(t, err, "register order: %s", err)
 
 	tests := []struct {
 		name     string
 		sum      float64
-		order    int
+		order    model.OrderNumber
 		user     *model.User
 		anotherU *model.User
 		wantErr  error
@@ -178,7 +177,7 @@ func TestWithdrawalsRepository_GetAllByUser(t *testing.T) {
 			require.NoError(t, err)
 			require.NoErrorf(t, err, "increment user balance: %w", err)
 			err = s.Withdraws().Withdraw(ctx, tt.user.ID, model.TestWithdraw(t, tt.order, tt.sum))
-			if luhn.Valid(tt.order) {
+			if tt.order.Valid() {
 				require.NoError(t, err)
 			} else {
 				require.ErrorIs(t, store.ErrIncorrectData, err)
@@ -0,0 +1,579 @@
+// Package storetest contains conformance tests which every implementation of store.Storage must pass.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vlad-marlo/gophermart/internal/model"
+	"github.com/vlad-marlo/gophermart/internal/store"
+)
+
+// Factory returns empty storage and function which releases it.
+type Factory func(t *testing.T) (store.Storage, func())
+
+var (
+	userLogin1 = "first"
+	userLogin2 = "second"
+	orderNum1  = model.OrderNumber("79927398713")
+	orderNum2  = model.OrderNumber("4929972884676289")
+	orderNum3  = model.OrderNumber("4532733309529845")
+)
+
+// Run runs conformance tests against storages returned by newStorage. Every test gets its own empty storage.
+func Run(t *testing.T, newStorage Factory) {
+	tests := []struct {
+		name string
+		f    func(t *testing.T, s store.Storage)
+	}{
+		{"users", testUsers},
+		{"orders register", testOrdersRegister},
+		{"orders register batch", testOrdersRegisterBatch},
+		{"orders change status", testOrdersChangeStatus},
+		{"orders credit once", testOrdersCreditOnce},
+		{"orders cancel", testOrdersCancel},
+		{"orders polling", testOrdersPolling},
+		{"orders dead letter", testOrdersDeadLetter},
+		{"orders listen registered", testOrdersListenRegistered},
+		{"withdrawals", testWithdrawals},
+		{"transfers", testTransfers},
+		{"with tx commit", testWithTxCommit},
+		{"with tx rollback", testWithTxRollback},
+		{"with tx nested", testWithTxNested},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, teardown := newStorage(t)
+			defer teardown()
+
+			tt.f(t, s)
+		})
+	}
+}
+
+// createUsers creates users with provided logins.
+func createUsers(t *testing.T, s store.Storage, logins ...string) []*model.User {
+	t.Helper()
+
+	users := make([]*model.User, 0, len(logins))
+	for _, login := range logins {
+		u := model.TestUser(t, login)
+		require.NoError(t, s.User().Create(context.Background(), u))
+		users = append(users, u)
+	}
+	return users
+}
+
+func testUsers(t *testing.T, s store.Storage) {
+	ctx := context.Background()
+
+	u := createUsers(t, s, userLogin1)[0]
+	assert.NotZero(t, u.ID)
+	assert.True(t, s.User().ExistsWithID(ctx, u.ID))
+	assert.False(t, s.User().ExistsWithID(ctx, u.ID+1))
+
+	err := s.User().Create(ctx, model.TestUser(t, userLogin1))
+	assert.ErrorIs(t, err, store.ErrLoginAlreadyInUse)
+
+	got, err := s.User().GetByLogin(ctx, userLogin1)
+	require.NoError(t, err)
+	assert.Equal(t, u.ID, got.ID)
+	assert.NoError(t, got.ComparePassword(u.Password))
+
+	_, err = s.User().GetByLogin(ctx, userLogin2)
+	assert.ErrorIs(t, err, store.ErrIncorrectLoginData)
+
+	assert.ErrorIs(t, s.User().IncrementBalance(ctx, u.ID, 0), store.ErrIncorrectData)
+	assert.ErrorIs(t, s.User().IncrementBalance(ctx, u.ID, -1), store.ErrIncorrectData)
+	require.NoError(t, s.User().IncrementBalance(ctx, u.ID, 15.5))
+
+	balance, err := s.User().GetBalance(ctx, u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, &model.UserBalance{Current: 15.5}, balance)
+
+	_, err = s.User().GetBalance(ctx, u.ID+1)
+	assert.ErrorIs(t, err, store.ErrNoContent)
+}
+
+func testOrdersRegister(t *testing.T, s store.Storage) {
+	ctx := context.Background()
+	users := createUsers(t, s, userLogin1, userLogin2)
+
+	_, err := s.Order().GetAllByUser(ctx, users[0].ID)
+	assert.ErrorIs(t, err, store.ErrNoContent)
+
+	require.NoError(t, s.Order().Register(ctx, users[0].ID, orderNum1))
+	assert.ErrorIs(t, s.Order().Register(ctx, users[0].ID, orderNum1), store.ErrAlreadyRegisteredByUser)
+	assert.ErrorIs(t, s.Order().Register(ctx, users[1].ID, orderNum1), store.ErrAlreadyRegisteredByAnotherUser)
+	require.NoError(t, s.Order().Register(ctx, users[0].ID, orderNum2))
+
+	orders, err := s.Order().GetAllByUser(ctx, users[0].ID)
+	require.NoError(t, err)
+	require.Len(t, orders, 2)
+	assert.Equal(t, orderNum1, orders[0].Number)
+	assert.Equal(t, orderNum2, orders[1].Number)
+	for _, o := range orders {
+		assert.Equal(t, model.StatusNew, o.Status)
+		_, err := time.Parse(time.RFC3339, o.UploadedAt)
+		assert.NoError(t, err)
+	}
+
+	order, err := s.Order().GetByNumber(ctx, users[0].ID, orderNum1)
+	require.NoError(t, err)
+	require.Len(t, order.History, 1)
+	assert.Equal(t, model.StatusNew, order.History[0].Status)
+
+	_, err = s.Order().GetByNumber(ctx, users[1].ID, orderNum1)
+	assert.ErrorIs(t, err, store.ErrNoContent)
+
+	active, err := s.Order().GetActiveByNumber(ctx, orderNum1)
+	require.NoError(t, err)
+	assert.Equal(t, users[0].ID, active.User)
+	assert.Equal(t, model.StatusNew, active.Status)
+
+	_, err = s.Order().GetActiveByNumber(ctx, orderNum3)
+	assert.ErrorIs(t, err, store.ErrNoContent)
+}
+
+func testOrdersRegisterBatch(t *testing.T, s store.Storage) {
+	ctx := context.Background()
+	users := createUsers(t, s, userLogin1, userLogin2)
+
+	require.NoError(t, s.Order().Register(ctx, users[0].ID, orderNum1))
+	require.NoError(t, s.Order().Register(ctx, users[1].ID, orderNum2))
+
+	res, err := s.Order().RegisterBatch(ctx, users[0].ID, []model.OrderNumber{orderNum1, orderNum2, orderNum3})
+	require.NoError(t, err)
+	assert.Equal(t, []*model.OrderRegistration{
+		{Number: orderNum1, Result: model.RegistrationDuplicateOwn},
+		{Number: orderNum2, Result: model.RegistrationDuplicateOther},
+		{Number: orderNum3, Result: model.RegistrationAccepted},
+	}, res)
+
+	_, err = s.Order().GetByNumber(ctx, users[0].ID, orderNum3)
+	assert.NoError(t, err)
+}
+
+func testOrdersChangeStatus(t *testing.T, s store.Storage) {
+	ctx := context.Background()
+	users := createUsers(t, s, userLogin1, userLogin2)
+
+	require.NoError(t, s.Order().Register(ctx, users[0].ID, orderNum1))
+
+	err := s.Order().ChangeStatus(ctx, users[1].ID, &model.OrderInAccrual{Number: orderNum1, Status: model.StatusProcessing})
+	assert.ErrorIs(t, err, store.ErrNoContent)
+
+	for _, status := range []string{model.StatusProcessing, model.StatusProcessing, model.StatusInvalid, model.StatusInvalid} {
+		err := s.Order().ChangeStatus(ctx, users[0].ID, &model.OrderInAccrual{Number: orderNum1, Status: status})
+		require.NoErrorf(t, err, "change status to %s", status)
+	}
+
+	var te *model.TransitionError
+	err = s.Order().ChangeStatus(ctx, users[0].ID, &model.OrderInAccrual{Number: orderNum1, Status: model.StatusProcessed})
+	require.ErrorAs(t, err, &te)
+	assert.Equal(t, model.StatusInvalid, te.From)
+	assert.Equal(t, model.StatusProcessed, te.To)
+
+	order, err := s.Order().GetByNumber(ctx, users[0].ID, orderNum1)
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusInvalid, order.Status)
+	// repeated statuses are not written to history
+	statuses := make([]string, 0, len(order.History))
+	for _, c := range order.History {
+		statuses = append(statuses, c.Status)
+	}
+	assert.Equal(t, []string{model.StatusNew, model.StatusProcessing, model.StatusInvalid}, statuses)
+}
+
+func testOrdersCreditOnce(t *testing.T, s store.Storage) {
+	ctx := context.Background()
+	u := createUsers(t, s, userLogin1)[0]
+
+	require.NoError(t, s.Order().Register(ctx, u.ID, orderNum1))
+
+	m := &model.OrderInAccrual{Number: orderNum1, Status: model.StatusProcessed, Accrual: 100}
+	credited, err := s.Order().ChangeStatusAndIncrementUserBalance(ctx, u.ID, m)
+	require.NoError(t, err)
+	assert.True(t, credited)
+
+	credited, err = s.Order().ChangeStatusAndIncrementUserBalance(ctx, u.ID, m)
+	require.NoError(t, err)
+	assert.False(t, credited)
+
+	balance, err := s.User().GetBalance(ctx, u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 100.0, balance.Current)
+
+	orders, err := s.Order().GetAllByUser(ctx, u.ID)
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	assert.Equal(t, 100.0, orders[0].Accrual)
+}
+
+func testOrdersCancel(t *testing.T, s store.Storage) {
+	ctx := context.Background()
+	users := createUsers(t, s, userLogin1, userLogin2)
+
+	require.NoError(t, s.Order().Register(ctx, users[0].ID, orderNum1))
+	require.NoError(t, s.Order().Register(ctx, users[0].ID, orderNum2))
+	require.NoError(t, s.Order().ChangeStatus(ctx, users[0].ID, &model.OrderInAccrual{
+		Number: orderNum2,
+		Status: model.StatusProcessing,
+	}))
+
+	assert.ErrorIs(t, s.Order().Cancel(ctx, users[1].ID, orderNum1), store.ErrNoContent)
+	assert.ErrorIs(t, s.Order().Cancel(ctx, users[0].ID, orderNum2), store.ErrNotCancellable)
+	require.NoError(t, s.Order().Cancel(ctx, users[0].ID, orderNum1))
+	assert.ErrorIs(t, s.Order().Cancel(ctx, users[0].ID, orderNum1), store.ErrNoContent)
+
+	orders, err := s.Order().GetAllByUser(ctx, users[0].ID)
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	assert.Equal(t, orderNum2, orders[0].Number)
+
+	// number of cancelled order can be registered again
+	require.NoError(t, s.Order().Register(ctx, users[1].ID, orderNum1))
+}
+
+// claimDue claims all orders which check is due by owner which is not used by other tests.
+func claimDue(t *testing.T, s store.Storage) []*model.OrderInPoll {
+	t.Helper()
+
+	orders, err := s.Order().ClaimUnprocessedOrders(context.Background(), "storetest", 100, time.Minute)
+	require.NoError(t, err)
+	return orders
+}
+
+func testOrdersPolling(t *testing.T, s store.Storage) {
+	ctx := context.Background()
+	u := createUsers(t, s, userLogin1)[0]
+
+	for _, number := range []model.OrderNumber{orderNum1, orderNum2, orderNum3} {
+		require.NoError(t, s.Order().Register(ctx, u.ID, number))
+	}
+	require.NoError(t, s.Order().ChangeStatus(ctx, u.ID, &model.OrderInAccrual{
+		Number: orderNum3,
+		Status: model.StatusInvalid,
+	}))
+
+	claimed, err := s.Order().ClaimUnprocessedOrders(ctx, "a", 1, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+
+	// the other instance can't claim order leased by the first one
+	other, err := s.Order().ClaimUnprocessedOrders(ctx, "b", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, other, 1)
+	assert.NotEqual(t, claimed[0].Number, other[0].Number)
+
+	ok, err := s.Order().ClaimOrder(ctx, "b", claimed[0].Number, time.Minute)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = s.Order().ClaimOrder(ctx, "a", claimed[0].Number, time.Minute)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = s.Order().ClaimOrder(ctx, "a", orderNum3, time.Minute)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// order leased by one instance can't be updated by the other one
+	owners := map[model.OrderNumber]string{claimed[0].Number: "a", other[0].Number: "b"}
+	assert.ErrorIs(t, s.Order().Postpone(ctx, owners[orderNum2], orderNum1, time.Hour), store.ErrLeaseLost)
+	assert.ErrorIs(t, s.Order().MarkNeedsAttention(ctx, owners[orderNum1], orderNum2), store.ErrLeaseLost)
+
+	require.NoError(t, s.Order().Postpone(ctx, owners[orderNum1], orderNum1, time.Hour))
+	require.NoError(t, s.Order().MarkNeedsAttention(ctx, owners[orderNum2], orderNum2))
+	// lease is released by postpone
+	assert.ErrorIs(t, s.Order().Postpone(ctx, owners[orderNum1], orderNum1, time.Hour), store.ErrLeaseLost)
+
+	assert.Empty(t, claimDue(t, s))
+
+	o, err := s.Order().GetActiveByNumber(ctx, orderNum1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, o.Attempts)
+
+	// rescheduled order is released without counting attempt
+	ok, err = s.Order().ClaimOrder(ctx, "a", orderNum1, time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.ErrorIs(t, s.Order().Reschedule(ctx, "b", orderNum1, 0), store.ErrLeaseLost)
+	require.NoError(t, s.Order().Reschedule(ctx, "a", orderNum1, 0))
+
+	due := claimDue(t, s)
+	require.Len(t, due, 1)
+	assert.Equal(t, orderNum1, due[0].Number)
+	assert.Equal(t, 1, due[0].Attempts)
+}
+
+func testOrdersDeadLetter(t *testing.T, s store.Storage) {
+	ctx := context.Background()
+	u := createUsers(t, s, userLogin1)[0]
+
+	_, err := s.Order().GetDeadLettered(ctx)
+	assert.ErrorIs(t, err, store.ErrNoContent)
+	_, err = s.Order().RecordFailure(ctx, "storetest", orderNum1, "reason", "", 2)
+	assert.ErrorIs(t, err, store.ErrLeaseLost)
+
+	require.NoError(t, s.Order().Register(ctx, u.ID, orderNum1))
+	assert.ErrorIs(t, s.Order().RetryDeadLettered(ctx, orderNum1), store.ErrNoContent)
+
+	// failure can be recorded only by owner of lease
+	_, err = s.Order().RecordFailure(ctx, "storetest", orderNum1, "reason", "", 2)
+	assert.ErrorIs(t, err, store.ErrLeaseLost)
+	require.Len(t, claimDue(t, s), 1)
+
+	dead, err := s.Order().RecordFailure(ctx, "storetest", orderNum1, "first", "", 2)
+	require.NoError(t, err)
+	assert.False(t, dead)
+	dead, err = s.Order().RecordFailure(ctx, "storetest", orderNum1, "second", `{"status":"UNKNOWN"}`, 2)
+	require.NoError(t, err)
+	assert.True(t, dead)
+
+	letters, err := s.Order().GetDeadLettered(ctx)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Equal(t, orderNum1, letters[0].Number)
+	assert.Equal(t, u.ID, letters[0].User)
+	assert.Equal(t, 2, letters[0].Failures)
+	assert.Equal(t, "second", letters[0].LastError)
+	assert.Equal(t, `{"status":"UNKNOWN"}`, letters[0].LastPayload)
+
+	assert.Empty(t, claimDue(t, s))
+
+	require.NoError(t, s.Order().RetryDeadLettered(ctx, orderNum1))
+	_, err = s.Order().GetDeadLettered(ctx)
+	assert.ErrorIs(t, err, store.ErrNoContent)
+
+	assert.Len(t, claimDue(t, s), 1)
+}
+
+func testOrdersListenRegistered(t *testing.T, s store.Storage) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	u := createUsers(t, s, userLogin1)[0]
+
+	orders := make(chan *model.OrderInPoll)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.Order().ListenRegistered(ctx, orders)
+	}()
+
+	// listener may subscribe after registration, so orders are registered until one of them is received
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	numbers := []model.OrderNumber{orderNum1, orderNum2, orderNum3}
+	for i := 0; ; {
+		select {
+		case o := <-orders:
+			assert.Equal(t, u.ID, o.User)
+			assert.Equal(t, model.StatusNew, o.Status)
+			cancel()
+			assert.Error(t, <-errs)
+			return
+		case <-ticker.C:
+			require.Less(t, i, len(numbers), "no notification received")
+			require.NoError(t, s.Order().Register(ctx, u.ID, numbers[i]))
+			i++
+		case <-ctx.Done():
+			t.Fatal("no notification received")
+		}
+	}
+}
+
+func testWithdrawals(t *testing.T, s store.Storage) {
+	ctx := context.Background()
+	u := createUsers(t, s, userLogin1)[0]
+
+	_, err := s.Withdraws().GetAllByUser(ctx, u.ID)
+	assert.ErrorIs(t, err, store.ErrNoContent)
+
+	require.NoError(t, s.User().IncrementBalance(ctx, u.ID, 100))
+
+	err = s.Withdraws().Withdraw(ctx, u.ID, model.TestWithdraw(t, orderNum1, 101))
+	assert.ErrorIs(t, err, store.ErrPaymentRequired)
+	require.NoError(t, s.Withdraws().Withdraw(ctx, u.ID, model.TestWithdraw(t, orderNum1, 30)))
+	require.NoError(t, s.Withdraws().Withdraw(ctx, u.ID, model.TestWithdraw(t, orderNum2, 20)))
+
+	balance, err := s.User().GetBalance(ctx, u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, &model.UserBalance{Current: 50, Withdrawn: 50}, balance)
+
+	withdrawals, err := s.Withdraws().GetAllByUser(ctx, u.ID)
+	require.NoError(t, err)
+	require.Len(t, withdrawals, 2)
+	assert.Equal(t, orderNum1, withdrawals[0].Order)
+	assert.Equal(t, 30.0, withdrawals[0].Sum)
+	assert.NotEmpty(t, withdrawals[0].ProcessedAtString)
+	assert.Equal(t, orderNum2, withdrawals[1].Order)
+}
+
+func testTransfers(t *testing.T, s store.Storage) {
+	ctx := context.Background()
+	users := createUsers(t, s, userLogin1, userLogin2)
+
+	_, err := s.Transfers().GetAllByUser(ctx, users[0].ID)
+	assert.ErrorIs(t, err, store.ErrNoContent)
+
+	require.NoError(t, s.User().IncrementBalance(ctx, users[0].ID, 100))
+
+	tests := []struct {
+		name       string
+		login      string
+		sum        float64
+		key        string
+		dailyLimit float64
+		wantErr    error
+	}{
+		{name: "not positive sum", login: userLogin2, sum: 0, wantErr: store.ErrIncorrectData},
+		{name: "unknown recipient", login: "unknown", sum: 10, wantErr: store.ErrRecipientNotFound},
+		{name: "to self", login: userLogin1, sum: 10, wantErr: store.ErrIncorrectData},
+		{name: "not enough money", login: userLogin2, sum: 101, wantErr: store.ErrPaymentRequired},
+		{name: "positive", login: userLogin2, sum: 30, key: "first"},
+		{name: "repeated idempotency key", login: userLogin2, sum: 30, key: "first"},
+		{name: "idempotency key with other sum", login: userLogin2, sum: 31, key: "first", wantErr: store.ErrIdempotencyKeyReused},
+		{name: "idempotency key with other recipient", login: "unknown", sum: 30, key: "first", wantErr: store.ErrIdempotencyKeyReused},
+		{name: "daily limit exceeded", login: userLogin2, sum: 30, dailyLimit: 50, wantErr: store.ErrDailyLimitExceeded},
+		{name: "within daily limit", login: userLogin2, sum: 20, dailyLimit: 50},
+	}
+	for _, tt := range tests {
+		recipient, err := s.Transfers().Transfer(ctx, users[0].ID, &model.Transfer{
+			Login:          tt.login,
+			Sum:            tt.sum,
+			IdempotencyKey: tt.key,
+		}, tt.dailyLimit)
+		if tt.wantErr != nil {
+			assert.ErrorIsf(t, err, tt.wantErr, "%s", tt.name)
+			continue
+		}
+		assert.NoErrorf(t, err, "%s", tt.name)
+		assert.Equalf(t, users[1].ID, recipient, "%s", tt.name)
+	}
+
+	for i, want := range []float64{50, 50} {
+		balance, err := s.User().GetBalance(ctx, users[i].ID)
+		require.NoError(t, err)
+		assert.Equal(t, want, balance.Current)
+	}
+
+	outgoing, err := s.Transfers().GetAllByUser(ctx, users[0].ID)
+	require.NoError(t, err)
+	require.Len(t, outgoing, 2)
+	assert.Equal(t, model.TransferOutgoing, outgoing[0].Direction)
+	assert.Equal(t, userLogin2, outgoing[0].Login)
+	assert.Equal(t, 30.0, outgoing[0].Sum)
+
+	incoming, err := s.Transfers().GetAllByUser(ctx, users[1].ID)
+	require.NoError(t, err)
+	require.Len(t, incoming, 2)
+	assert.Equal(t, model.TransferIncoming, incoming[0].Direction)
+	assert.Equal(t, userLogin1, incoming[0].Login)
+}
+
+func testWithTxCommit(t *testing.T, s store.Storage) {
+	ctx := context.Background()
+	u := createUsers(t, s, userLogin1)[0]
+
+	err := s.WithTx(ctx, func(tx store.Storage) error {
+		if err := tx.User().IncrementBalance(ctx, u.ID, 100); err != nil {
+			return err
+		}
+		if err := tx.Order().Register(ctx, u.ID, orderNum1); err != nil {
+			return err
+		}
+		// error of repository doesn't abort transaction
+		if err := tx.Order().Register(ctx, u.ID, orderNum1); !errors.Is(err, store.ErrAlreadyRegisteredByUser) {
+			return fmt.Errorf("register again: %v", err)
+		}
+		if err := tx.User().Create(ctx, model.TestUser(t, userLogin1)); !errors.Is(err, store.ErrLoginAlreadyInUse) {
+			return fmt.Errorf("create again: %v", err)
+		}
+
+		// transaction sees its own changes
+		if _, err := tx.Order().GetByNumber(ctx, u.ID, orderNum1); err != nil {
+			return err
+		}
+		return tx.Withdraws().Withdraw(ctx, u.ID, model.TestWithdraw(t, orderNum2, 40))
+	})
+	require.NoError(t, err)
+
+	balance, err := s.User().GetBalance(ctx, u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, &model.UserBalance{Current: 60, Withdrawn: 40}, balance)
+
+	_, err = s.Order().GetByNumber(ctx, u.ID, orderNum1)
+	assert.NoError(t, err)
+}
+
+func testWithTxRollback(t *testing.T, s store.Storage) {
+	ctx := context.Background()
+	u := createUsers(t, s, userLogin1)[0]
+	require.NoError(t, s.User().IncrementBalance(ctx, u.ID, 100))
+
+	errTest := errors.New("test error")
+	err := s.WithTx(ctx, func(tx store.Storage) error {
+		if err := tx.Order().Register(ctx, u.ID, orderNum1); err != nil {
+			return err
+		}
+		if err := tx.Withdraws().Withdraw(ctx, u.ID, model.TestWithdraw(t, orderNum2, 40)); err != nil {
+			return err
+		}
+		if err := tx.User().Create(ctx, model.TestUser(t, userLogin2)); err != nil {
+			return err
+		}
+		return errTest
+	})
+	assert.ErrorIs(t, err, errTest)
+
+	balance, err := s.User().GetBalance(ctx, u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, &model.UserBalance{Current: 100}, balance)
+
+	_, err = s.Order().GetByNumber(ctx, u.ID, orderNum1)
+	assert.ErrorIs(t, err, store.ErrNoContent)
+	_, err = s.User().GetByLogin(ctx, userLogin2)
+	assert.ErrorIs(t, err, store.ErrIncorrectLoginData)
+
+	// storage is usable after rollback
+	require.NoError(t, s.Order().Register(ctx, u.ID, orderNum1))
+}
+
+func testWithTxNested(t *testing.T, s store.Storage) {
+	ctx := context.Background()
+	u := createUsers(t, s, userLogin1)[0]
+
+	errTest := errors.New("test error")
+	err := s.WithTx(ctx, func(tx store.Storage) error {
+		if err := tx.Order().Register(ctx, u.ID, orderNum1); err != nil {
+			return err
+		}
+
+		err := tx.WithTx(ctx, func(nested store.Storage) error {
+			if err := nested.Order().Register(ctx, u.ID, orderNum2); err != nil {
+				return err
+			}
+			return errTest
+		})
+		if !errors.Is(err, errTest) {
+			return fmt.Errorf("nested: %v", err)
+		}
+
+		return tx.WithTx(ctx, func(nested store.Storage) error {
+			return nested.Order().Register(ctx, u.ID, orderNum3)
+		})
+	})
+	require.NoError(t, err)
+
+	orders, err := s.Order().GetAllByUser(ctx, u.ID)
+	require.NoError(t, err)
+	numbers := make([]model.OrderNumber, 0, len(orders))
+	for _, o := range orders {
+		numbers = append(numbers, o.Number)
+	}
+	assert.Equal(t, []model.OrderNumber{orderNum1, orderNum3}, numbers)
+}
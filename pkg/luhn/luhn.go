@@ -1,8 +1,11 @@
 package luhn
 
-// CalculateLuhn return the check number
-func CalculateLuhn(number int) int {
-	checkNumber := checksum(number)
+// CalculateLuhn return the check number; number must contain only decimal digits, otherwise -1 is returned
+func CalculateLuhn(number string) int {
+	checkNumber, ok := checksum(number)
+	if !ok {
+		return -1
+	}
 
 	if checkNumber == 0 {
 		return 0
@@ -11,16 +14,33 @@ func CalculateLuhn(number int) int {
 }
 
 // Valid check number is valid or not based on Luhn algorithm
-func Valid(number int) bool {
-	return (number%10+checksum(number/10))%10 == 0
+func Valid(number string) bool {
+	if len(number) == 0 {
+		return false
+	}
+
+	last := number[len(number)-1]
+	if !isDigit(last) {
+		return false
+	}
+
+	sum, ok := checksum(number[:len(number)-1])
+	if !ok {
+		return false
+	}
+	return (int(last-'0')+sum)%10 == 0
 }
 
 // checksum ...
-func checksum(number int) int {
+func checksum(number string) (int, bool) {
 	var luhn int
 
-	for i := 0; number > 0; i++ {
-		cur := number % 10
+	for i := 0; i < len(number); i++ {
+		c := number[len(number)-1-i]
+		if !isDigit(c) {
+			return 0, false
+		}
+		cur := int(c - '0')
 
 		if i%2 == 0 { // even
 			cur *= 2
@@ -30,7 +50,11 @@ func checksum(number int) int {
 		}
 
 		luhn += cur
-		number /= 10
 	}
-	return luhn % 10
+	return luhn % 10, true
+}
+
+// isDigit ...
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
 }